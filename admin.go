@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+const adminPath = "/api/v1/queries"
+
+// adminAPI manages the queries of a running scheduler:
+//
+//	GET    /api/v1/queries                list the queries and their status
+//	PUT    /api/v1/queries/{name}         add or replace a query
+//	DELETE /api/v1/queries/{name}         remove a query
+//	POST   /api/v1/queries/{name}/pause   stop running a query on its schedule
+//	POST   /api/v1/queries/{name}/resume  run a paused query on its schedule again
+//	POST   /api/v1/queries/{name}/trigger run a query right away
+type adminAPI struct {
+	scheduler *prom2log.Scheduler
+	// config is the current configuration, the defaults of the added queries come from it.
+	config atomic.Pointer[Configuration]
+	// persist is the path of the config file the changes are saved to, if set.
+	persist string
+
+	mu sync.Mutex
+}
+
+func (a *adminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, adminPath), "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(a.scheduler.Status())
+		return
+	}
+
+	name, action, _ := strings.Cut(path, "/")
+	var err error
+	switch {
+	case action == "" && r.Method == http.MethodPut:
+		err = a.set(name, r)
+	case action == "" && r.Method == http.MethodDelete:
+		err = a.remove(name)
+	case action == "pause" && r.Method == http.MethodPost:
+		err = a.scheduler.Pause(name)
+	case action == "resume" && r.Method == http.MethodPost:
+		err = a.scheduler.Resume(name)
+	case action == "trigger" && r.Method == http.MethodPost:
+		err = a.scheduler.Trigger(name)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	var badRequest *badRequestError
+	switch {
+	case errors.Is(err, prom2log.ErrUnknownQuery):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.As(err, &badRequest):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type badRequestError struct {
+	err error
+}
+
+func (e *badRequestError) Error() string {
+	return e.err.Error()
+}
+
+func (a *adminAPI) set(name string, r *http.Request) error {
+	var raw map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
+		return &badRequestError{err: err}
+	}
+	var q prom2log.Query
+	if err := strictDecode(raw, &q); err != nil {
+		return &badRequestError{err: err}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.persistable(name); err != nil {
+		return err
+	}
+	config := a.config.Load()
+	queries := map[string]prom2log.Query{name: config.query(q)}
+	// binding the variables adds the servers given by URL to the map, so it's done on a copy
+	if err := prom2log.BindVariables(queries, config.Variables, a.scheduler.CurrentServers()); err != nil {
+		return &badRequestError{err: err}
+	}
+	if err := a.scheduler.SetQuery(name, queries[name]); err != nil {
+		return &badRequestError{err: err}
+	}
+	if a.persist == "" {
+		return nil
+	}
+	return persistQuery(a.persist, name, raw)
+}
+
+func (a *adminAPI) remove(name string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.persistable(name); err != nil {
+		return err
+	}
+	if err := a.scheduler.RemoveQuery(name); err != nil {
+		return err
+	}
+	if a.persist == "" {
+		return nil
+	}
+	return persistQuery(a.persist, name, nil)
+}
+
+// persistable checks that changes to a query can be saved, queries from the config dir can't.
+func (a *adminAPI) persistable(name string) error {
+	if a.persist == "" {
+		return nil
+	}
+	doc, err := readConfigNode(a.persist)
+	if err != nil {
+		return err
+	}
+	queries := mappingValue(doc.Content[0], "queries")
+	if queries != nil && mappingValue(queries, name) != nil {
+		return nil
+	}
+	for _, st := range a.scheduler.Status() {
+		if st.Name == name {
+			return &badRequestError{err: fmt.Errorf("query %s isn't defined in %s, changes to it can't be saved", name, a.persist)}
+		}
+	}
+	return nil
+}
+
+// persistQuery sets, or removes when q is nil, a query in the config file, keeping the rest of the file as is.
+func persistQuery(path, name string, q map[string]interface{}) error {
+	doc, err := readConfigNode(path)
+	if err != nil {
+		return err
+	}
+	root := doc.Content[0]
+	queries := mappingValue(root, "queries")
+	if queries == nil {
+		queries = &yaml.Node{Kind: yaml.MappingNode}
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "queries"}, queries)
+	}
+
+	for i := 0; i < len(queries.Content); i += 2 {
+		if queries.Content[i].Value == name {
+			queries.Content = append(queries.Content[:i], queries.Content[i+2:]...)
+			break
+		}
+	}
+	if q != nil {
+		var value yaml.Node
+		if err := value.Encode(q); err != nil {
+			return err
+		}
+		queries.Content = append(queries.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, &value)
+	}
+
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	// write to a temporary file renamed over the config, so it's never left half written
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b.Bytes()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readConfigNode parses a config file into a YAML document node with a mapping at its root.
+func readConfigNode(path string) (*yaml.Node, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: expected a mapping", path)
+	}
+	return &doc, nil
+}
+
+// mappingValue returns the value of a key in a mapping node.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
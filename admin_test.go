@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+// recordSink keeps the names of the queries it got records from.
+type recordSink struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (s *recordSink) Write(_ context.Context, r prom2log.Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names = append(s.names, r.Name)
+	return nil
+}
+
+func (s *recordSink) Close() error {
+	return nil
+}
+
+func (s *recordSink) count(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, got := range s.names {
+		if got == name {
+			n++
+		}
+	}
+	return n
+}
+
+// startAdmin runs a scheduler with no queries and returns an admin API managing it.
+func startAdmin(t *testing.T, persist string) (*adminAPI, *recordSink) {
+	t.Helper()
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {"job": "prometheus"}, "value": [1700000000, "1"]}]}}`)
+	}))
+	t.Cleanup(prom.Close)
+
+	c := &Configuration{Servers: map[string]prom2log.ServerConfig{"main": {URL: prom.URL}}}
+	scheduler, err := c.scheduler(map[string]prom2log.Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordSink{}
+	scheduler.Sinks = map[string]prom2log.Sink{"test": sink}
+	scheduler.Output = []string{"test"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = scheduler.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	for !scheduler.Running() {
+		time.Sleep(time.Millisecond)
+	}
+
+	admin := &adminAPI{scheduler: scheduler, persist: persist}
+	admin.config.Store(c)
+	return admin, sink
+}
+
+func adminRequest(admin *adminAPI, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+func TestAdminAPI(t *testing.T) {
+	admin, sink := startAdmin(t, "")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{name: "add", method: http.MethodPut, path: "/api/v1/queries/up", body: `{"server": "main", "promQL": "up", "interval": "1h"}`, want: http.StatusNoContent},
+		{name: "invalid JSON", method: http.MethodPut, path: "/api/v1/queries/up", body: `{`, want: http.StatusBadRequest},
+		{name: "unknown field", method: http.MethodPut, path: "/api/v1/queries/up", body: `{"promql": "up"}`, want: http.StatusBadRequest},
+		{name: "invalid interval", method: http.MethodPut, path: "/api/v1/queries/other", body: `{"server": "main", "promQL": "up", "interval": "soon"}`, want: http.StatusBadRequest},
+		{name: "pause", method: http.MethodPost, path: "/api/v1/queries/up/pause", want: http.StatusNoContent},
+		{name: "trigger", method: http.MethodPost, path: "/api/v1/queries/up/trigger", want: http.StatusNoContent},
+		{name: "resume", method: http.MethodPost, path: "/api/v1/queries/up/resume", want: http.StatusNoContent},
+		{name: "pause unknown", method: http.MethodPost, path: "/api/v1/queries/nope/pause", want: http.StatusNotFound},
+		{name: "unknown action", method: http.MethodPost, path: "/api/v1/queries/up/stop", want: http.StatusNotFound},
+		{name: "list with PUT", method: http.MethodPut, path: "/api/v1/queries", want: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := adminRequest(admin, tt.method, tt.path, tt.body)
+			if w.Code != tt.want {
+				t.Errorf("got status %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), tt.want)
+			}
+		})
+	}
+
+	w := adminRequest(admin, http.MethodGet, "/api/v1/queries", "")
+	var status []prom2log.QueryStatus
+	if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 1 || status[0].Name != "up" || status[0].Paused {
+		t.Errorf("got status %+v, want the running up query", status)
+	}
+	for deadline := time.Now().Add(5 * time.Second); sink.count("up") < 2 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := sink.count("up"); n < 2 {
+		t.Errorf("got %d records, want the scheduled and the triggered runs", n)
+	}
+
+	if w := adminRequest(admin, http.MethodDelete, "/api/v1/queries/up", ""); w.Code != http.StatusNoContent {
+		t.Errorf("remove: got status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if w := adminRequest(admin, http.MethodDelete, "/api/v1/queries/up", ""); w.Code != http.StatusNotFound {
+		t.Errorf("remove again: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// TestAdminAPIConcurrent sets queries concurrently, to be run with -race.
+func TestAdminAPIConcurrent(t *testing.T) {
+	admin, _ := startAdmin(t, "")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body := fmt.Sprintf(`{"server": "main", "promQL": "up{n=\"%d\"}", "interval": "1h"}`, i)
+			if w := adminRequest(admin, http.MethodPut, fmt.Sprintf("/api/v1/queries/q%d", i), body); w.Code != http.StatusNoContent {
+				t.Errorf("got status %d (%s)", w.Code, strings.TrimSpace(w.Body.String()))
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := len(admin.scheduler.Status()); got != 8 {
+		t.Errorf("got %d queries, want 8", got)
+	}
+}
+
+func TestAdminAPIPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config := "# the servers\nservers:\n  main:\n    url: http://localhost:9090\nqueries:\n  kept:\n    server: main\n    promQL: up\n"
+	if err := os.WriteFile(path, []byte(config), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	admin, _ := startAdmin(t, path)
+
+	if w := adminRequest(admin, http.MethodPut, "/api/v1/queries/up", `{"server": "main", "promQL": "up", "interval": "1h"}`); w.Code != http.StatusNoContent {
+		t.Fatalf("add: got status %d (%s)", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# the servers", "kept:", "up:", "interval: 1h"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("config doesn't contain %q:\n%s", want, b)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Errorf("got mode %v, want %v", info.Mode().Perm(), os.FileMode(0o640))
+	}
+
+	if w := adminRequest(admin, http.MethodDelete, "/api/v1/queries/up", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("remove: got status %d (%s)", w.Code, strings.TrimSpace(w.Body.String()))
+	}
+	if b, err = os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "up:") || !strings.Contains(string(b), "kept:") {
+		t.Errorf("got config:\n%s\nwant only the up query removed", b)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files, want the temporary files removed", len(entries))
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+// Exit codes of the check command, following the Nagios plugin conventions.
+const (
+	checkOK       = 0
+	checkWarning  = 1
+	checkCritical = 2
+	checkUnknown  = 3
+)
+
+var checkStates = map[int]string{
+	checkOK:       "OK",
+	checkWarning:  "WARNING",
+	checkCritical: "CRITICAL",
+	checkUnknown:  "UNKNOWN",
+}
+
+// exitCode is returned by commands that already reported their outcome and only need to exit with the given status.
+type exitCode int
+
+func (e exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+type CheckCMD struct {
+	baseCMD
+	Name   string `help:"Name shown in the status line, defaults to the query"`
+	Warn   string `help:"Condition for a sample to be a warning, e.g. '> 0.8'"`
+	Crit   string `help:"Condition for a sample to be critical, e.g. '> 0.95'"`
+	Server string `arg:"" help:"URL or name of the Prometheus server"`
+	Query  string `arg:""`
+}
+
+func (ch *CheckCMD) Run(c *Configuration) error {
+	name := ch.Name
+	if name == "" {
+		name = ch.Query
+	}
+	err := ch.run(c, name)
+	var code exitCode
+	if err != nil && !errors.As(err, &code) {
+		fmt.Printf("%s - %s: %v\n", checkStates[checkUnknown], name, err)
+		return exitCode(checkUnknown)
+	}
+	return err
+}
+
+func (ch *CheckCMD) run(c *Configuration, name string) error {
+	if ch.Warn == "" && ch.Crit == "" {
+		return errors.New("at least one of --warn or --crit is required")
+	}
+	query := prom2log.Query{
+		Server:     ch.Server,
+		PromQL:     ch.Query,
+		Timeout:    metav1.Duration{Duration: c.Timeout},
+		Thresholds: prom2log.Thresholds{Warn: ch.Warn, Crit: ch.Crit},
+	}
+	if err := query.Validate(); err != nil {
+		return err
+	}
+	queries := map[string]prom2log.Query{name: query}
+	if err := c.bind(queries); err != nil {
+		return err
+	}
+	query = queries[name]
+
+	status, details := check(query, query.Run(context.Background(), name))
+	fmt.Printf("%s - %s: %s\n", checkStates[status], name, details[0])
+	for _, d := range details[1:] {
+		fmt.Println(d)
+	}
+	if status != checkOK {
+		return exitCode(status)
+	}
+	return nil
+}
+
+// check returns the status of a query result and its details, the summary followed by the samples that aren't ok.
+func check(q prom2log.Query, r prom2log.Record) (int, []string) {
+	samples, err := r.Samples()
+	if err != nil {
+		return checkUnknown, []string{err.Error()}
+	}
+	switch len(samples) {
+	case 0:
+		return checkOK, []string{"no samples"}
+	case 1:
+		s := samples[0]
+		return severityStatus(q.Thresholds.Severity(s.Value)), []string{formatFloat(s.Value)}
+	}
+
+	status := checkOK
+	counts := make(map[string]int)
+	var details []string
+	for _, s := range samples {
+		severity := q.Thresholds.Severity(s.Value)
+		counts[severity]++
+		if severity == prom2log.SeverityInfo {
+			continue
+		}
+		if st := severityStatus(severity); st > status {
+			status = st
+		}
+		details = append(details, fmt.Sprintf("%s %s %s", severity, formatMetric(s.Metric), formatFloat(s.Value)))
+	}
+	summary := fmt.Sprintf("%d critical, %d warning, %d ok", counts[prom2log.SeverityCritical], counts[prom2log.SeverityWarning], counts[prom2log.SeverityInfo])
+	return status, append([]string{summary}, details...)
+}
+
+func severityStatus(severity string) int {
+	switch severity {
+	case prom2log.SeverityCritical:
+		return checkCritical
+	case prom2log.SeverityWarning:
+		return checkWarning
+	}
+	return checkOK
+}
+
+// formatMetric renders a label set like Prometheus does, e.g. up{job="node"}.
+func formatMetric(m map[string]string) string {
+	var labels []string
+	for _, k := range sortedKeys(m) {
+		if k != "__name__" {
+			labels = append(labels, k+"="+strconv.Quote(m[k]))
+		}
+	}
+	return m["__name__"] + "{" + strings.Join(labels, ", ") + "}"
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+// The completion scripts call the hidden __complete command with the words of the command line, the last one being
+// the word to complete, and fall back to completing file names when it doesn't print any candidates.
+const (
+	bashCompletion = `_{{name}}() {
+	local IFS=$'\n'
+	COMPREPLY=($({{name}} __complete -- "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _{{name}} {{name}}
+`
+	zshCompletion = `#compdef {{name}}
+_{{name}}() {
+	local -a candidates
+	candidates=("${(@f)$({{name}} __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ -n ${candidates[1]} ]]; then
+		compadd -- "${candidates[@]}"
+	else
+		_files
+	fi
+}
+compdef _{{name}} {{name}}
+`
+	fishCompletion = `function __{{name}}_complete
+	set -l words (commandline -opc)
+	set -l candidates ({{name}} __complete -- $words[2..-1] (commandline -ct) 2>/dev/null)
+	if test (count $candidates) -gt 0
+		printf '%s\n' $candidates
+	else
+		__fish_complete_path (commandline -ct)
+	end
+end
+complete -c {{name}} -f -a '(__{{name}}_complete)'
+`
+)
+
+type CompletionCMD struct {
+	Shell string `arg:"" enum:"bash,zsh,fish" help:"Shell to generate the completion script for (bash, zsh or fish)"`
+}
+
+func (cmd *CompletionCMD) Run(ctx *kong.Context) error {
+	scripts := map[string]string{"bash": bashCompletion, "zsh": zshCompletion, "fish": fishCompletion}
+	_, err := fmt.Fprint(os.Stdout, strings.ReplaceAll(scripts[cmd.Shell], "{{name}}", ctx.Model.Name))
+	return err
+}
+
+// CompleteCMD prints the candidates to complete the last of the given words, one per line.
+type CompleteCMD struct {
+	Words []string `arg:"" optional:"" passthrough:""`
+}
+
+func (cmd *CompleteCMD) Run(ctx *kong.Context) error {
+	for _, c := range complete(ctx.Model.Node, cmd.Words, func() []string { return queryNames(cmd.Words) }) {
+		fmt.Println(c)
+	}
+	return nil
+}
+
+// complete returns the candidates for the last of the words given to the app: the commands, flags or arguments that
+// can follow the previous words, or the values of the flag before it. Values tagged with predictor:"query" complete
+// with the names of the configured queries and predictor:"theme" with the colour themes.
+func complete(app *kong.Node, words []string, queries func() []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	node := app
+	args := 0
+	var flag *kong.Flag
+	for _, w := range words[:len(words)-1] {
+		switch {
+		case w == "=":
+			// bash splits --flag=value in three words
+		case flag != nil:
+			flag = nil
+		case strings.HasPrefix(w, "-"):
+			name, _, hasValue := strings.Cut(w, "=")
+			if f := findFlag(node, name); f != nil && !hasValue && !f.IsBool() && !f.IsCounter() {
+				flag = f
+			}
+		default:
+			if child := findChild(node, w); child != nil {
+				node, args = child, 0
+			} else {
+				args++
+			}
+		}
+	}
+
+	cur := words[len(words)-1]
+	if cur == "=" {
+		cur = ""
+	}
+	if flag != nil {
+		return matching(values(flag.Value, queries), "", cur)
+	}
+	if name, value, ok := strings.Cut(cur, "="); ok && strings.HasPrefix(cur, "--") {
+		if f := findFlag(node, name); f != nil {
+			return matching(values(f.Value, queries), name+"=", value)
+		}
+		return nil
+	}
+	if strings.HasPrefix(cur, "-") {
+		var flags []string
+		for n := node; n != nil; n = n.Parent {
+			for _, f := range n.Flags {
+				if !f.Hidden {
+					flags = append(flags, "--"+f.Name)
+				}
+			}
+		}
+		return matching(flags, "", cur)
+	}
+	var candidates []string
+	for _, child := range node.Children {
+		if !child.Hidden {
+			candidates = append(candidates, child.Name)
+		}
+	}
+	if len(node.Positional) > 0 {
+		p := node.Positional[len(node.Positional)-1]
+		if args < len(node.Positional) {
+			p = node.Positional[args]
+		}
+		if args < len(node.Positional) || p.Target.Kind() == reflect.Slice {
+			candidates = append(candidates, values(p, queries)...)
+		}
+	}
+	return matching(candidates, "", cur)
+}
+
+func findFlag(node *kong.Node, name string) *kong.Flag {
+	for n := node; n != nil; n = n.Parent {
+		for _, f := range n.Flags {
+			if "--"+f.Name == name || (f.Short != 0 && "-"+string(f.Short) == name) {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+func findChild(node *kong.Node, name string) *kong.Node {
+	for _, child := range node.Children {
+		if child.Name == name {
+			return child
+		}
+		for _, alias := range child.Aliases {
+			if alias == name {
+				return child
+			}
+		}
+	}
+	return nil
+}
+
+// values returns the values a flag or argument can take, if they're known.
+func values(v *kong.Value, queries func() []string) []string {
+	if v.Tag != nil {
+		switch v.Tag.Get("predictor") {
+		case "query":
+			return queries()
+		case "theme":
+			return append(prom2log.Themes(), prom2log.ThemeAuto)
+		}
+	}
+	if v.Enum != "" {
+		return v.EnumSlice()
+	}
+	return nil
+}
+
+// matching returns the candidates starting with prefix, sorted and with before prepended.
+func matching(candidates []string, before, prefix string) []string {
+	var matches []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			matches = append(matches, before+c)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// queryNames returns the names of the queries in the configuration given by the --config and --config-dir flags
+// among the words, or in the default config file, and none when it can't be loaded.
+func queryNames(words []string) []string {
+	args := []string{"validate"}
+	for i, w := range words {
+		name, _, hasValue := strings.Cut(w, "=")
+		switch name {
+		case "-c", "--config", "--config-dir":
+			switch {
+			case hasValue:
+				args = append(args, w)
+			case i+3 < len(words) && words[i+1] == "=":
+				args = append(args, name, words[i+2])
+			case i+2 < len(words) && words[i+1] != "=":
+				// the last word is the one being completed
+				args = append(args, name, words[i+1])
+			}
+		}
+	}
+	var c cli
+	parser, err := kong.New(&c, kong.Configuration(configLoader, defaultConfig))
+	if err != nil {
+		return nil
+	}
+	if _, err := parser.Parse(args); err != nil {
+		return nil
+	}
+	if err := c.includeDir(); err != nil {
+		return nil
+	}
+	return sortedKeys(c.queries())
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	kongyaml "github.com/alecthomas/kong-yaml"
+	"gopkg.in/yaml.v3"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+// envRef matches ${NAME} references, $${NAME} escapes them.
+var envRef = regexp.MustCompile(`\$(\$?)\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// configLoader loads YAML config files expanding the environment variable references in their values.
+func configLoader(r io.Reader) (kong.Resolver, error) {
+	config := map[string]interface{}{}
+	if err := yaml.NewDecoder(r).Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("YAML config decode error: %w", err)
+	}
+	expanded, err := expand(config)
+	if err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(expanded)
+	if err != nil {
+		return nil, err
+	}
+	return kongyaml.Loader(bytes.NewReader(b))
+}
+
+// expand replaces the ${NAME} references in the string values of a decoded YAML document.
+func expand(v interface{}) (interface{}, error) {
+	var err error
+	switch v := v.(type) {
+	case string:
+		return expandString(v)
+	case map[string]interface{}:
+		for k, e := range v {
+			if v[k], err = expand(e); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			if v[i], err = expand(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+// expandString replaces ${NAME} with the value of the NAME environment variable or, when it's not set,
+// with the contents of the file named by NAME_FILE, like mounted secrets.
+func expandString(s string) (string, error) {
+	var err error
+	s = envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		m := envRef.FindStringSubmatch(ref)
+		if m[1] != "" {
+			return ref[1:]
+		}
+		name := m[2]
+		if v, ok := os.LookupEnv(name); ok {
+			return v
+		}
+		if path, ok := os.LookupEnv(name + "_FILE"); ok {
+			b, e := os.ReadFile(path)
+			if e != nil {
+				err = fmt.Errorf("reading %s_FILE: %w", name, e)
+				return ref
+			}
+			return strings.TrimRight(string(b), "\r\n")
+		}
+		err = fmt.Errorf("environment variable %s is not set", name)
+		return ref
+	})
+	return s, err
+}
+
+// includeDir merges the queries, servers and sinks defined in the YAML files of the config dir, in lexical order.
+// Names must be unique across all the files, including the config file.
+func (c *Configuration) includeDir() error {
+	if c.ConfigDir == "" {
+		return nil
+	}
+	files, err := configDirFiles(c.ConfigDir)
+	if err != nil {
+		return err
+	}
+	queries := make(map[string]string)
+	servers := make(map[string]string)
+	sinks := make(map[string]string)
+	for _, f := range files {
+		var fc struct {
+			Queries map[string]prom2log.Query
+			Servers map[string]prom2log.ServerConfig
+			Sinks   map[string]prom2log.SinkConfig
+		}
+		if err := loadConfigFile(f, &fc); err != nil {
+			return fmt.Errorf("%s: %w", f, err)
+		}
+		if err := merge(&c.Queries, fc.Queries, queries, "query", f); err != nil {
+			return err
+		}
+		if err := merge(&c.Servers, fc.Servers, servers, "server", f); err != nil {
+			return err
+		}
+		if err := merge(&c.Sinks, fc.Sinks, sinks, "sink", f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configDirFiles returns the paths of the YAML files in a directory, sorted by name.
+func configDirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if ext := filepath.Ext(e.Name()); !e.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	return files, nil
+}
+
+// loadConfigFile decodes a config dir file, expanding environment variables, into v.
+func loadConfigFile(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return err
+	}
+	for k := range config {
+		if k != "queries" && k != "servers" && k != "sinks" {
+			return fmt.Errorf("unexpected key %q, only queries, servers and sinks can be set in the config dir", k)
+		}
+	}
+	expanded, err := expand(config)
+	if err != nil {
+		return err
+	}
+	if b, err = json.Marshal(expanded); err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// merge adds the entries of src to dst, sources keeps track of the file each entry came from to report duplicates.
+func merge[T any](dst *map[string]T, src map[string]T, sources map[string]string, kind, file string) error {
+	if *dst == nil {
+		*dst = make(map[string]T, len(src))
+	}
+	for name, v := range src {
+		if _, ok := (*dst)[name]; ok {
+			from, ok := sources[name]
+			if !ok {
+				from = "the config file"
+			}
+			return fmt.Errorf("%s: %s %q is already defined in %s", file, kind, name, from)
+		}
+		(*dst)[name] = v
+		sources[name] = file
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/prometheus/common/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+type DiffCMD struct {
+	baseCMD
+	TimeA       string   `help:"Evaluation time of the query on the first server, absolute or relative, e.g. now-1d"`
+	TimeB       string   `help:"Evaluation time of the query on the second server"`
+	Tolerance   float64  `help:"Largest difference between two values considered equal"`
+	Relative    bool     `help:"Take the tolerance as a fraction of the first value"`
+	IgnoreLabel []string `help:"Labels ignored when matching series, e.g. external labels only one of the servers adds"`
+	JSON        bool     `help:"Print the differences as JSON"`
+	ServerA     string   `arg:"" help:"URL or name of the first Prometheus server"`
+	ServerB     string   `arg:"" help:"URL or name of the second Prometheus server, can be the same one with different times"`
+	Query       string   `arg:""`
+}
+
+// seriesDiff is the comparison of the results of a query on two servers.
+type seriesDiff struct {
+	OnlyA   []diffSample  `json:"only_a"`
+	OnlyB   []diffSample  `json:"only_b"`
+	Changed []diffChanged `json:"changed"`
+	Equal   int           `json:"equal"`
+}
+
+type diffSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  float64           `json:"value"`
+}
+
+type diffChanged struct {
+	Metric map[string]string `json:"metric"`
+	A      float64           `json:"a"`
+	B      float64           `json:"b"`
+	Delta  float64           `json:"delta"`
+}
+
+func (d *DiffCMD) Run(c *Configuration) error {
+	// like diff(1), exit with 1 if there are differences and 2 if there's a problem
+	diff, err := d.diff(c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return exitCode(2)
+	}
+	if d.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(diff); err != nil {
+			return err
+		}
+	} else {
+		for _, s := range diff.OnlyA {
+			fmt.Printf("- %s %s\n", formatMetric(s.Metric), formatFloat(s.Value))
+		}
+		for _, s := range diff.OnlyB {
+			fmt.Printf("+ %s %s\n", formatMetric(s.Metric), formatFloat(s.Value))
+		}
+		for _, s := range diff.Changed {
+			fmt.Printf("~ %s %s -> %s (%+g)\n", formatMetric(s.Metric), formatFloat(s.A), formatFloat(s.B), s.Delta)
+		}
+		fmt.Printf("%d only in %s, %d only in %s, %d changed, %d equal\n",
+			len(diff.OnlyA), d.ServerA, len(diff.OnlyB), d.ServerB, len(diff.Changed), diff.Equal)
+	}
+	if len(diff.OnlyA)+len(diff.OnlyB)+len(diff.Changed) > 0 {
+		return exitCode(1)
+	}
+	return nil
+}
+
+func (d *DiffCMD) diff(c *Configuration) (*seriesDiff, error) {
+	queries := map[string]prom2log.Query{
+		"a": {Server: d.ServerA, PromQL: d.Query, Time: d.TimeA, Timeout: metav1.Duration{Duration: c.Timeout}},
+		"b": {Server: d.ServerB, PromQL: d.Query, Time: d.TimeB, Timeout: metav1.Duration{Duration: c.Timeout}},
+	}
+	if err := c.bind(queries); err != nil {
+		return nil, err
+	}
+	results := make(map[string]map[string]diffSample, len(queries))
+	for name, q := range queries {
+		if err := q.Validate(); err != nil {
+			return nil, err
+		}
+		r := q.Run(context.Background(), name)
+		if _, ok := r.Result.(model.Matrix); ok {
+			return nil, fmt.Errorf("range vectors can't be compared, use an instant vector query")
+		}
+		samples, err := r.Samples()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", q.Server, err)
+		}
+		results[name] = d.index(samples)
+	}
+
+	diff := &seriesDiff{OnlyA: []diffSample{}, OnlyB: []diffSample{}, Changed: []diffChanged{}}
+	a, b := results["a"], results["b"]
+	for _, k := range sortedKeys(a) {
+		sa := a[k]
+		sb, ok := b[k]
+		switch {
+		case !ok:
+			diff.OnlyA = append(diff.OnlyA, sa)
+		case d.equal(sa.Value, sb.Value):
+			diff.Equal++
+		default:
+			diff.Changed = append(diff.Changed, diffChanged{Metric: sa.Metric, A: sa.Value, B: sb.Value, Delta: sb.Value - sa.Value})
+		}
+	}
+	for _, k := range sortedKeys(b) {
+		if _, ok := a[k]; !ok {
+			diff.OnlyB = append(diff.OnlyB, b[k])
+		}
+	}
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return math.Abs(diff.Changed[i].Delta) > math.Abs(diff.Changed[j].Delta)
+	})
+	return diff, nil
+}
+
+// index returns the samples by series, without the ignored labels.
+func (d *DiffCMD) index(samples []prom2log.Sample) map[string]diffSample {
+	index := make(map[string]diffSample, len(samples))
+	for _, s := range samples {
+		metric := make(map[string]string, len(s.Metric))
+		for k, v := range s.Metric {
+			if !contains(d.IgnoreLabel, k) {
+				metric[k] = v
+			}
+		}
+		index[formatMetric(metric)] = diffSample{Metric: metric, Value: s.Value}
+	}
+	return index
+}
+
+func (d *DiffCMD) equal(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	tolerance := d.Tolerance
+	if d.Relative {
+		tolerance *= math.Abs(a)
+	}
+	return math.Abs(a-b) <= tolerance
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+// exploreOps are the options shared by the commands exploring the labels and series of a server.
+type exploreOps struct {
+	formatOps
+	baseCMD
+	Start  string `help:"Start of the time range, absolute or relative, e.g. now-1h"`
+	End    string `help:"End of the time range, absolute or relative"`
+	Server string `arg:"" help:"URL or name of the Prometheus server"`
+}
+
+// query returns a query bound to the server, to send the requests with its settings.
+func (e *exploreOps) query(c *Configuration) (prom2log.Query, error) {
+	queries := map[string]prom2log.Query{
+		"": {Server: e.Server, Timeout: metav1.Duration{Duration: c.Timeout}},
+	}
+	if err := c.bind(queries); err != nil {
+		return prom2log.Query{}, err
+	}
+	return queries[""], nil
+}
+
+func (e *exploreOps) selector(match []string) (prom2log.SeriesSelector, error) {
+	sel := prom2log.SeriesSelector{Match: match}
+	now := time.Now()
+	var err error
+	if e.Start != "" {
+		if sel.Start, err = prom2log.ParseTime(e.Start, now); err != nil {
+			return sel, err
+		}
+	}
+	if e.End != "" {
+		if sel.End, err = prom2log.ParseTime(e.End, now); err != nil {
+			return sel, err
+		}
+	}
+	return sel, nil
+}
+
+func (e *exploreOps) print(v interface{}) error {
+	formatter := e.formatter()
+	return formatter.FormatJSON(os.Stdout, v)
+}
+
+type LabelsCMD struct {
+	exploreOps
+	Match []string `help:"Only list the labels of the series matching the selector, e.g. up{job=\"node\"}, can be repeated"`
+}
+
+func (l *LabelsCMD) Run(c *Configuration) error {
+	query, err := l.query(c)
+	if err != nil {
+		return err
+	}
+	sel, err := l.selector(l.Match)
+	if err != nil {
+		return err
+	}
+	labels, err := query.Labels(context.Background(), sel)
+	if err != nil {
+		return err
+	}
+	return l.print(labels)
+}
+
+type LabelValuesCMD struct {
+	exploreOps
+	Match []string `help:"Only list the values in the series matching the selector, can be repeated"`
+	Label string   `arg:"" help:"Name of the label, e.g. job or __name__ for the metric names"`
+}
+
+func (l *LabelValuesCMD) Run(c *Configuration) error {
+	query, err := l.query(c)
+	if err != nil {
+		return err
+	}
+	sel, err := l.selector(l.Match)
+	if err != nil {
+		return err
+	}
+	values, err := query.LabelValues(context.Background(), l.Label, sel)
+	if err != nil {
+		return err
+	}
+	return l.print(values)
+}
+
+type SeriesCMD struct {
+	exploreOps
+	Match []string `arg:"" help:"Selectors of the series to list, e.g. up{job=\"node\"}"`
+}
+
+func (s *SeriesCMD) Run(c *Configuration) error {
+	query, err := s.query(c)
+	if err != nil {
+		return err
+	}
+	sel, err := s.selector(s.Match)
+	if err != nil {
+		return err
+	}
+	series, err := query.Series(context.Background(), sel)
+	if err != nil {
+		return err
+	}
+	return s.print(series)
+}
+
+type MetadataCMD struct {
+	formatOps
+	baseCMD
+	Limit  int    `help:"Maximum number of metrics to list"`
+	Server string `arg:"" help:"URL or name of the Prometheus server"`
+	Metric string `arg:"" optional:"" help:"Name of the metric, defaults to all"`
+}
+
+func (m *MetadataCMD) Run(c *Configuration) error {
+	e := exploreOps{Server: m.Server}
+	query, err := e.query(c)
+	if err != nil {
+		return err
+	}
+	metadata, err := query.Metadata(context.Background(), m.Metric, m.Limit)
+	if err != nil {
+		return err
+	}
+	formatter := m.formatter()
+	return formatter.FormatJSON(os.Stdout, metadata)
+}
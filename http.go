@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"golang.org/x/exp/slog"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+// serve starts an HTTP server exposing the status, health and metrics of the scheduler, it's shut down when ctx is done.
+func (s *StartCMD) serve(ctx context.Context, scheduler *prom2log.Scheduler) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	if err := prom2log.RegisterMetrics(registry); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/status", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(scheduler.Status())
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	var ready atomic.Bool
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		if !ready.Load() && s.ready(scheduler) {
+			ready.Store(true)
+		}
+		if !ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return listenAndServe(ctx, s.Listen, mux)
+}
+
+// serveAdmin starts an HTTP server with the admin API, apart from the status server so it's only reachable from
+// localhost unless --admin-listen says otherwise. It's shut down when ctx is done.
+func (s *StartCMD) serveAdmin(ctx context.Context, admin *adminAPI) error {
+	mux := http.NewServeMux()
+	mux.Handle(adminPath, admin)
+	mux.Handle(adminPath+"/", admin)
+	return listenAndServe(ctx, s.AdminListen, mux)
+}
+
+// serveDebug starts an HTTP server with the pprof handlers and a dump of the scheduler's state,
+// it's shut down when ctx is done.
+func (s *StartCMD) serveDebug(ctx context.Context, scheduler *prom2log.Scheduler) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/state", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(struct {
+			Goroutines int                    `json:"goroutines"`
+			Queries    []prom2log.QueryStatus `json:"queries"`
+			Pending    map[string]int         `json:"pending_records"`
+		}{
+			Goroutines: runtime.NumGoroutine(),
+			Queries:    scheduler.Status(),
+			Pending:    scheduler.Pending(),
+		})
+	})
+	return listenAndServe(ctx, s.DebugListen, mux)
+}
+
+// listenAndServe serves handler on addr in the background until ctx is done.
+func listenAndServe(ctx context.Context, addr string, handler http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP server failed", "address", addr, "error", err)
+		}
+	}()
+	return nil
+}
+
+// ready reports whether the scheduler is running and, unless ReadyOnStart is set or it's standing by,
+// any query ran successfully.
+func (s *StartCMD) ready(scheduler *prom2log.Scheduler) bool {
+	if !scheduler.Running() {
+		return false
+	}
+	status := scheduler.Status()
+	if s.ReadyOnStart || len(status) == 0 || scheduler.Standby() {
+		return true
+	}
+	for _, st := range status {
+		if !st.LastSuccess.IsZero() {
+			return true
+		}
+	}
+	return false
+}
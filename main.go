@@ -1,146 +1,423 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/alecthomas/chroma/quick"
 	"github.com/alecthomas/kong"
-	kongyaml "github.com/alecthomas/kong-yaml"
+	"golang.org/x/exp/slog"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-)
 
-const (
-	logFmt = `{"time": "%s", "name": "%s", "result": %s}
-`
-	urlFmt = "%s/api/v1/query?query=%s"
+	"github.com/luisdavim/prom2log/pkg/prom2log"
 )
 
 type Configuration struct {
-	Queries map[string]Query
+	ConfigDir string   `type:"existingdir" help:"Directory with additional YAML files defining queries, servers and sinks"`
+	Defaults  defaults `help:"Settings inherited by the queries that don't set their own"`
+	Queries   map[string]prom2log.Query
+	Variables map[string]prom2log.Variable     `help:"Values the queries can use in their PromQL with {{ var \"name\" }}"`
+	Servers   map[string]prom2log.ServerConfig `help:"Prometheus servers the queries can refer to by name"`
+	Sinks     map[string]prom2log.SinkConfig   `help:"Output sinks the query results can be sent to"`
+	Output    []string                         `help:"Names of the sinks used by queries that don't set their own"`
+	Format    string                           `help:"Output format of the queries that don't set their own (json, logfmt, csv, tsv or ecs)"`
+	Timeout   time.Duration                    `default:"2m" help:"Timeout of the queries that don't set their own"`
+	Limits    limits                           `help:"Limits of the requests sent by all the queries: max_concurrency, rate_limit and burst"`
 }
 
-type Query struct {
-	Server   string
-	PromQL   string
-	Interval metav1.Duration
+// queries returns the configured queries with the global settings applied.
+func (c *Configuration) queries() map[string]prom2log.Query {
+	queries := make(map[string]prom2log.Query, len(c.Queries))
+	for name, q := range c.Queries {
+		queries[name] = c.query(q)
+	}
+	return queries
 }
 
-func (q *Query) Get() ([]byte, error) {
-	response, err := http.Get(fmt.Sprintf(urlFmt, q.Server, url.QueryEscape(q.PromQL)))
-	if err != nil {
-		return nil, err
+// query returns the query with the global settings applied.
+func (c *Configuration) query(q prom2log.Query) prom2log.Query {
+	q = q.Inherit(c.Defaults.Query)
+	if q.Format == "" {
+		q.Format = c.Format
+	}
+	if q.Timeout.Duration == 0 {
+		q.Timeout.Duration = c.Timeout
 	}
-	defer response.Body.Close()
-	return io.ReadAll(response.Body)
+	return q
 }
 
-func (q *Query) Log(name string) {
-	b, err := q.Get()
-	if err != nil {
-		fmt.Printf(logFmt, time.Now(), name, err)
-	}
-	fmt.Printf(logFmt, time.Now(), name, b)
+// defaults is the query with the settings inherited by the other queries.
+type defaults struct {
+	prom2log.Query
 }
 
-func prettyJSON(str string) (string, error) {
-	var pj bytes.Buffer
-	if err := json.Indent(&pj, []byte(str), "", "  "); err != nil {
-		return "", err
+// Decode decodes the defaults from the config file or from JSON on the command line.
+func (d *defaults) Decode(ctx *kong.DecodeContext) error {
+	return decodeJSON(ctx, &d.Query)
+}
+
+// limits bound the requests sent by all the queries.
+type limits struct {
+	prom2log.Limits
+}
+
+// Decode decodes the limits from the config file or from JSON on the command line.
+func (l *limits) Decode(ctx *kong.DecodeContext) error {
+	return decodeJSON(ctx, &l.Limits)
+}
+
+// decodeJSON decodes a block of the config file, or JSON given on the command line, into v.
+func decodeJSON(ctx *kong.DecodeContext, v interface{}) error {
+	var (
+		b   []byte
+		err error
+	)
+	switch value := ctx.Scan.Pop().Value.(type) {
+	case string:
+		b = []byte(value)
+	default:
+		if b, err = json.Marshal(value); err != nil {
+			return err
+		}
 	}
-	return pj.String(), nil
+	return json.Unmarshal(b, v)
 }
 
-func prettyQuery(name string, query Query, f formatOps) error {
+type formatOps struct {
+	NoPrettyJSON bool   `help:"Disable JSON pretty printing"`
+	NoColour     bool   `help:"Disable coloured output"`
+	Plain        bool   `short:"P" help:"Disable JSON pretty printing and colors"`
+	Theme        string `default:"auto" predictor:"theme" help:"Colour theme, auto picks a light or dark one depending on the terminal background, see the themes command"`
+}
+
+// Validate checks the theme.
+func (f formatOps) Validate() error {
+	return prom2log.ValidateTheme(f.Theme)
+}
+
+func (f formatOps) formatter() prom2log.Formatter {
 	if f.Plain {
 		f.NoColour = true
 		f.NoPrettyJSON = true
 	}
 
-	if !f.NoColour {
-		o, _ := os.Stdout.Stat()
-		if (o.Mode() & os.ModeCharDevice) != os.ModeCharDevice {
-			// output is not a terminal
-			f.NoColour = true
-			f.NoPrettyJSON = true
+	if !f.NoColour && !isTerminal(os.Stdout) {
+		f.NoColour = true
+		f.NoPrettyJSON = true
+	}
+
+	return prom2log.Formatter{
+		NoPrettyJSON: f.NoPrettyJSON,
+		NoColour:     f.NoColour,
+		Theme:        f.Theme,
+	}
+}
+
+type ThemesCMD struct{}
+
+func (t *ThemesCMD) Run() error {
+	for _, theme := range prom2log.Themes() {
+		fmt.Println(theme)
+	}
+	return nil
+}
+
+func isTerminal(f *os.File) bool {
+	o, err := f.Stat()
+	return err == nil && (o.Mode()&os.ModeCharDevice) == os.ModeCharDevice
+}
+
+func prettyQuery(ctx context.Context, w io.Writer, name string, query prom2log.Query, formatter *prom2log.Formatter) error {
+	if err := query.Validate(); err != nil {
+		return err
+	}
+	if query.Stream {
+		_, _, err := query.RunStream(ctx, name, func(r prom2log.Record) error {
+			for _, r := range query.Process(r) {
+				if err := formatter.Format(w, r); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return err
+	}
+	r := query.Run(ctx, name)
+	if r.Err != nil {
+		return r.Err
+	}
+	for _, r := range query.Process(r) {
+		if err := formatter.Format(w, r); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+type baseCMD struct {
+	Config    kong.ConfigFlag `short:"c" type:"path" help:"Path to the config file"`
+	Debug     bool            `short:"d" help:" Enable debug output" env:"DEBUG"`
+	LogFormat string          `enum:"text,json" default:"text" help:"Format of the logs written to stderr (text or json)"`
+}
+
+// AfterApply sets up the logger for prom2log's own diagnostics, logging debug messages with --debug.
+func (b *baseCMD) AfterApply() error {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if b.Debug {
+		opts.Level = slog.LevelDebug
+	}
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if b.LogFormat == "json" {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+type StartCMD struct {
+	baseCMD
+	Listen       string        `help:"Address of the HTTP server exposing the status, health and metrics of the queries, e.g. :8080"`
+	ReadyOnStart bool          `help:"Report ready as soon as the queries are scheduled instead of after the first successful run"`
+	DebugListen  string        `default:"localhost:6060" help:"Address of the pprof and debug server started with --debug"`
+	Admin        bool          `help:"Serve an API to add, remove, pause, resume and trigger queries at runtime"`
+	AdminListen  string        `default:"localhost:8081" help:"Address of the admin API server started with --admin"`
+	AdminPersist bool          `help:"Save the changes made through the admin API to the config file"`
+	DrainTimeout time.Duration `default:"30s" help:"How long to wait for the running queries to finish when stopping, before cancelling them"`
+
+	LeaderElect    bool          `help:"Only run the queries while holding a Kubernetes Lease, keeping the other replicas on standby"`
+	LeaseName      string        `default:"prom2log" help:"Name of the Lease used for the leader election"`
+	LeaseNamespace string        `help:"Namespace of the Lease, defaults to the namespace of the pod"`
+	LeaseDuration  time.Duration `default:"15s" help:"How long the Lease is valid after being renewed, a standby replica takes over after it expires"`
+
+	StateFile      string `type:"path" help:"File recording the last successful run of each query, to resume where it left off after a restart"`
+	StateConfigMap string `help:"Kubernetes ConfigMap recording the last successful run of each query, instead of a file"`
+	StateNamespace string `help:"Namespace of the state ConfigMap, defaults to the namespace of the pod"`
+	CatchUp        string `enum:"skip,backfill" default:"skip" help:"What to do with the runs missed while stopped: skip them and run the query once, or backfill them with range queries (skip or backfill)"`
 
-	b, err := query.Get()
+	ShardCount int `help:"Number of replicas splitting the queries between them"`
+	ShardIndex int `default:"-1" help:"Shard of the queries run by this replica, from 0, defaults to the ordinal of the StatefulSet pod"`
+}
+
+// bind sets the server of each of the given queries.
+func (c *Configuration) bind(queries map[string]prom2log.Query) error {
+	servers, err := prom2log.NewServers(c.Servers)
 	if err != nil {
 		return err
 	}
-	res := fmt.Sprintf(logFmt, time.Now(), name, b)
-	if !f.NoPrettyJSON {
-		var err error
-		res, err = prettyJSON(res)
+	if err := prom2log.BindVariables(queries, c.Variables, servers); err != nil {
+		return err
+	}
+	return prom2log.BindServers(queries, servers)
+}
+
+// scheduler returns a scheduler for the given queries writing to the configured sinks.
+func (c *Configuration) scheduler(queries map[string]prom2log.Query) (*prom2log.Scheduler, error) {
+	servers, err := prom2log.NewServers(c.Servers)
+	if err != nil {
+		return nil, err
+	}
+	if err := prom2log.BindVariables(queries, c.Variables, servers); err != nil {
+		return nil, err
+	}
+	sinks, err := prom2log.NewSinks(c.Sinks)
+	if err != nil {
+		return nil, err
+	}
+	return &prom2log.Scheduler{
+		Queries: queries,
+		Servers: servers,
+		Sinks:   sinks,
+		Output:  c.Output,
+		Limits:  c.Limits.Limits,
+		Formatter: prom2log.Formatter{
+			NoPrettyJSON: true,
+			NoColour:     true,
+		},
+	}, nil
+}
+
+func (s *StartCMD) Run(c *Configuration) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	scheduler, err := c.scheduler(c.queries())
+	if err != nil {
+		return err
+	}
+	if scheduler.Shard, err = s.shard(); err != nil {
+		return err
+	}
+	scheduler.DrainTimeout = s.DrainTimeout
+	if scheduler.State, err = s.state(); err != nil {
+		return err
+	}
+	scheduler.CatchUp = s.CatchUp
+	// the sinks are replaced when the configuration is reloaded, they're closed once the running queries are drained
+	// to flush the records they buffer
+	defer func() {
+		if err := prom2log.CloseSinks(scheduler.CurrentSinks()); err != nil {
+			slog.Error("failed to close the sinks", "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		// a second signal stops right away
+		cancel()
+	}()
+
+	path := string(s.Config)
+	if path == "" {
+		path = defaultConfig
+	}
+	var (
+		admin    *adminAPI
+		reloaded func(*Configuration)
+	)
+	if s.Listen != "" {
+		if err := s.serve(ctx, scheduler); err != nil {
+			return err
+		}
+	}
+	if s.Admin {
+		admin = &adminAPI{scheduler: scheduler}
+		admin.config.Store(c)
+		if s.AdminPersist {
+			admin.persist = path
+		}
+		if err := s.serveAdmin(ctx, admin); err != nil {
+			return err
+		}
+	}
+	if s.Debug {
+		if err := s.serveDebug(ctx, scheduler); err != nil {
+			return err
+		}
+	}
+	if admin != nil {
+		reloaded = admin.config.Store
+	}
+	if s.LeaderElect {
+		elector, err := prom2log.NewLeaderElector(prom2log.LeaseConfig{
+			Name:      s.LeaseName,
+			Namespace: s.LeaseNamespace,
+			Duration:  s.LeaseDuration,
+		})
 		if err != nil {
 			return err
 		}
+		scheduler.SetStandby(true)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			elector.Run(ctx, func(leading bool) { scheduler.SetStandby(!leading) })
+		}()
+		// wait for the lease to be released
+		defer func() { <-done }()
 	}
+	go watch(ctx, path, c.ConfigDir, c, scheduler, reloaded)
+	return scheduler.Run(ctx)
+}
 
-	if f.NoColour {
-		fmt.Print(res)
-		return nil
+// state returns the store of the scheduler state, if one is configured.
+func (s *StartCMD) state() (prom2log.StateStore, error) {
+	switch {
+	case s.StateFile != "" && s.StateConfigMap != "":
+		return nil, errors.New("--state-file and --state-configmap are mutually exclusive")
+	case s.StateFile != "":
+		return prom2log.FileState(s.StateFile), nil
+	case s.StateConfigMap != "":
+		return prom2log.NewConfigMapState(prom2log.ConfigMapConfig{Name: s.StateConfigMap, Namespace: s.StateNamespace})
 	}
-
-	return quick.Highlight(os.Stdout, res, "json", "terminal", "native")
+	return nil, nil
 }
 
-type formatOps struct {
-	NoPrettyJSON bool `help:"Disable JSON pretty printing"`
-	NoColour     bool `help:"Disable coloured output"`
-	Plain        bool `short:"P" help:"Disable JSON pretty printing and colors"`
+// shard returns the shard of the queries run by this replica.
+func (s *StartCMD) shard() (prom2log.Shard, error) {
+	shard := prom2log.Shard{Index: s.ShardIndex, Count: s.ShardCount}
+	if shard.Count > 1 && shard.Index < 0 {
+		ordinal, err := prom2log.PodOrdinal()
+		if err != nil {
+			return shard, fmt.Errorf("--shard-index is required: %w", err)
+		}
+		shard.Index = ordinal
+	}
+	return shard, nil
 }
 
-type baseCMD struct {
-	Config kong.ConfigFlag `short:"c" type:"path" help:"Path to the config file"`
-	Debug  bool            `short:"d" help:" Enable debug output" env:"DEBUG"`
+type BackfillCMD struct {
+	baseCMD
+	Start string        `required:"" help:"Start of the time range, absolute or relative, e.g. now-7d"`
+	End   string        `default:"now" help:"End of the time range"`
+	Step  time.Duration `default:"1m" help:"Time between evaluations"`
+	Names []string      `arg:"" optional:"" predictor:"query" help:"Names of the queries to backfill, defaults to all"`
 }
 
-type StartCMD baseCMD
+func (b *BackfillCMD) Run(c *Configuration) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
 
-func (s *StartCMD) Run(c *Configuration) error {
-	ctx, cancel := context.WithCancel(context.Background())
-	for name, query := range c.Queries {
-		go func(name string, q Query) {
-			q.Log(name)
-			ticker := time.NewTicker(q.Interval.Duration)
-			defer ticker.Stop()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case <-ticker.C:
-					q.Log(name)
-				}
-			}
-		}(name, query)
+	now := time.Now()
+	start, err := prom2log.ParseTime(b.Start, now)
+	if err != nil {
+		return err
 	}
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
-	<-signals
-	cancel()
-	return nil
+	end, err := prom2log.ParseTime(b.End, now)
+	if err != nil {
+		return err
+	}
+
+	queries, err := selectQueries(c.queries(), b.Names)
+	if err != nil {
+		return err
+	}
+
+	scheduler, err := c.scheduler(queries)
+	if err != nil {
+		return err
+	}
+	defer prom2log.CloseSinks(scheduler.Sinks)
+	return scheduler.Backfill(ctx, start, end, b.Step)
+}
+
+// selectQueries returns the queries with the given names, or all of them if there are none.
+func selectQueries(queries map[string]prom2log.Query, names []string) (map[string]prom2log.Query, error) {
+	if len(names) == 0 {
+		return queries, nil
+	}
+	selected := make(map[string]prom2log.Query, len(names))
+	for _, name := range names {
+		q, ok := queries[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown query %q", name)
+		}
+		selected[name] = q
+	}
+	return selected, nil
 }
 
 type RunCMD struct {
 	formatOps
 	baseCMD
+	Names []string `name:"name" predictor:"query" help:"Names of the queries to run, can be repeated, defaults to all"`
 }
 
 func (r *RunCMD) Run(c *Configuration) error {
-	for name, query := range c.Queries {
-		if err := prettyQuery(name, query, r.formatOps); err != nil {
+	formatter := r.formatter()
+	queries, err := selectQueries(c.queries(), r.Names)
+	if err != nil {
+		return err
+	}
+	if err := c.bind(queries); err != nil {
+		return err
+	}
+	for name, query := range queries {
+		if err := prettyQuery(context.Background(), os.Stdout, name, query, &formatter); err != nil {
 			return err
 		}
 	}
@@ -149,28 +426,96 @@ func (r *RunCMD) Run(c *Configuration) error {
 
 type QueryCMD struct {
 	formatOps
-	Name   string
-	Server string `arg:""`
-	Query  string `arg:""`
+	baseCMD
+	Name     string
+	Flatten  bool          `help:"Output one record per sample"`
+	Template string        `help:"Go template used to render each sample"`
+	Extract  string        `help:"jq-like path selecting the part of the result to output, e.g. '.[].value[1]'"`
+	Time     string        `help:"Evaluation time of an instant query, absolute or relative, e.g. now-1h"`
+	Start    string        `help:"Start time of a range query, absolute or relative, e.g. now-1h"`
+	End      string        `help:"End time of a range query, defaults to now"`
+	Step     time.Duration `help:"Resolution of range queries" default:"1m"`
+	Watch    bool          `short:"w" help:"Run the query repeatedly, redrawing the result"`
+	Interval time.Duration `default:"5s" help:"Time between runs with --watch"`
+	Post     bool          `help:"Send the query in the body of a POST request, for expressions too long for a URL"`
+	Server   string        `arg:"" help:"URL or name of the Prometheus server"`
+	Query    string        `arg:""`
 }
 
-func (q *QueryCMD) Run() error {
-	query := Query{
-		Server: q.Server,
-		PromQL: q.Query,
+func (q *QueryCMD) Run(c *Configuration) error {
+	query := prom2log.Query{
+		Server:   q.Server,
+		PromQL:   q.Query,
+		Flatten:  q.Flatten,
+		Format:   c.Format,
+		Timeout:  metav1.Duration{Duration: c.Timeout},
+		Template: q.Template,
+		Extract:  q.Extract,
+		Time:     q.Time,
+		Start:    q.Start,
+		End:      q.End,
+		Step:     metav1.Duration{Duration: q.Step},
+	}
+	if q.Post {
+		query.Method = http.MethodPost
+	}
+	queries := map[string]prom2log.Query{q.Name: query}
+	if err := c.bind(queries); err != nil {
+		return err
+	}
+	formatter := q.formatter()
+	if q.Watch {
+		return watchQuery(q.Interval, q.Name, queries[q.Name], &formatter)
 	}
-	return prettyQuery(q.Name, query, q.formatOps)
+	return prettyQuery(context.Background(), os.Stdout, q.Name, queries[q.Name], &formatter)
 }
 
-func main() {
-	var cli struct {
-		Configuration
-		Start StartCMD `cmd:"" help:"Start the server."`
-		Run   RunCMD   `cmd:"" help:"run once."`
-		Query QueryCMD `cmd:"" help:"run the given query."`
+type cli struct {
+	Configuration
+	Start       StartCMD       `cmd:"" help:"Start the server."`
+	Run         RunCMD         `cmd:"" help:"run once."`
+	Query       QueryCMD       `cmd:"" help:"run the given query."`
+	Check       CheckCMD       `cmd:"" help:"check the result of a query against thresholds, exiting like a Nagios plugin."`
+	Repl        ReplCMD        `cmd:"" help:"run queries interactively."`
+	Diff        DiffCMD        `cmd:"" help:"compare the results of a query on two servers or at two points in time."`
+	Labels      LabelsCMD      `cmd:"" help:"list the label names of a server."`
+	LabelValues LabelValuesCMD `cmd:"" help:"list the values of a label."`
+	Series      SeriesCMD      `cmd:"" help:"list the series matching selectors."`
+	Metadata    MetadataCMD    `cmd:"" help:"list the type, help and unit of the metrics."`
+	Backfill    BackfillCMD    `cmd:"" help:"replay the configured queries over a past time range."`
+	Validate    ValidateCMD    `cmd:"" help:"check the configuration."`
+	Status      StatusCMD      `cmd:"" aliases:"list" help:"list the configured queries and their status."`
+	Themes      ThemesCMD      `cmd:"" help:"list the colour themes."`
+	Completion  CompletionCMD  `cmd:"" help:"print the completion script of a shell."`
+	Complete    CompleteCMD    `cmd:"" name:"__complete" hidden:""`
+}
+
+const defaultConfig = "./config.yaml"
+
+// loadConfiguration parses the command line and the config file again, returning the resulting configuration.
+func loadConfiguration() (*Configuration, error) {
+	var c cli
+	parser, err := kong.New(&c, kong.Configuration(configLoader, defaultConfig))
+	if err != nil {
+		return nil, err
 	}
+	if _, err := parser.Parse(os.Args[1:]); err != nil {
+		return nil, err
+	}
+	if err := c.includeDir(); err != nil {
+		return nil, err
+	}
+	return &c.Configuration, nil
+}
 
-	ctx := kong.Parse(&cli, kong.Configuration(kongyaml.Loader, "./config.yaml"))
-	err := ctx.Run(&cli.Configuration)
+func main() {
+	var c cli
+	ctx := kong.Parse(&c, kong.Configuration(configLoader, defaultConfig))
+	ctx.FatalIfErrorf(c.includeDir())
+	err := ctx.Run(&c.Configuration)
+	var code exitCode
+	if errors.As(err, &code) {
+		ctx.Exit(int(code))
+	}
 	ctx.FatalIfErrorf(err)
 }
@@ -0,0 +1,82 @@
+package prom2log
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// BasicAuth configures HTTP basic authentication.
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password,omitempty"`
+	// PasswordFile is read on every request, so rotated passwords are picked up.
+	PasswordFile string `json:"password_file,omitempty"`
+}
+
+// AuthConfig configures how requests to a server are authenticated, only one method can be used.
+type AuthConfig struct {
+	BasicAuth   *BasicAuth `json:"basic_auth,omitempty"`
+	BearerToken string     `json:"bearer_token,omitempty"`
+	// BearerTokenFile is read on every request, so rotated tokens are picked up.
+	BearerTokenFile string `json:"bearer_token_file,omitempty"`
+}
+
+// IsSet reports whether any authentication method is configured.
+func (a AuthConfig) IsSet() bool {
+	return a.BasicAuth != nil || a.BearerToken != "" || a.BearerTokenFile != ""
+}
+
+func (a AuthConfig) validate() error {
+	methods := 0
+	if a.BasicAuth != nil {
+		methods++
+		if a.BasicAuth.Password != "" && a.BasicAuth.PasswordFile != "" {
+			return errors.New("password and password_file are mutually exclusive")
+		}
+	}
+	if a.BearerToken != "" {
+		methods++
+	}
+	if a.BearerTokenFile != "" {
+		methods++
+	}
+	if methods > 1 {
+		return errors.New("only one of basic_auth, bearer_token and bearer_token_file can be set")
+	}
+	return nil
+}
+
+// apply sets the credentials on the request.
+func (a AuthConfig) apply(req *http.Request) error {
+	switch {
+	case a.BasicAuth != nil:
+		password := a.BasicAuth.Password
+		if a.BasicAuth.PasswordFile != "" {
+			var err error
+			if password, err = readSecret(a.BasicAuth.PasswordFile); err != nil {
+				return err
+			}
+		}
+		req.SetBasicAuth(a.BasicAuth.Username, password)
+	case a.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+a.BearerToken)
+	case a.BearerTokenFile != "":
+		token, err := readSecret(a.BearerTokenFile)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// readSecret returns the contents of a file without the surrounding whitespace.
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
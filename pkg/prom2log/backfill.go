@@ -0,0 +1,96 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// maxPointsPerRequest keeps the range queries under the Prometheus limit of 11000 points per series.
+const maxPointsPerRequest = 10000
+
+// Backfill evaluates the query at every step between start and end, calling fn with one record per evaluation
+// timestamp, in chronological order. The records Time is the evaluation time, not the wall clock.
+// It uses range queries split in chunks, so the server limits on the number of points aren't exceeded.
+func (q *Query) Backfill(ctx context.Context, name string, start, end time.Time, step time.Duration, fn func(Record) error) error {
+	if step <= 0 {
+		return errors.New("step must be positive")
+	}
+	if end.Before(start) {
+		return errors.New("end must not be before start")
+	}
+	expr, err := q.expr(ctx)
+	if err != nil {
+		return err
+	}
+	chunk := step * maxPointsPerRequest
+	for from := start; !from.After(end); from = from.Add(chunk) {
+		to := from.Add(chunk - step)
+		if to.After(end) {
+			to = end
+		}
+		params := url.Values{
+			"query": []string{expr},
+			"start": []string{formatTime(from)},
+			"end":   []string{formatTime(to)},
+			"step":  []string{strconv.FormatFloat(step.Seconds(), 'f', -1, 64)},
+		}
+		b, err := q.get(ctx, queryRangePath, params)
+		if err != nil {
+			return err
+		}
+		v, warnings, err := ParseResult(b)
+		if err != nil {
+			return err
+		}
+		if v, err = q.relabelResult(v); err != nil {
+			return err
+		}
+		matrix, ok := v.(model.Matrix)
+		if !ok {
+			return fmt.Errorf("unexpected result type %q", v.Type())
+		}
+		for _, r := range evaluations(name, matrix, warnings) {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// evaluations regroups the samples of a range query result by timestamp, returning one record per evaluation.
+func evaluations(name string, matrix model.Matrix, warnings []string) []Record {
+	vectors := map[model.Time]model.Vector{}
+	for _, s := range matrix {
+		for _, p := range s.Values {
+			vectors[p.Timestamp] = append(vectors[p.Timestamp], &model.Sample{
+				Metric:    s.Metric,
+				Value:     p.Value,
+				Timestamp: p.Timestamp,
+			})
+		}
+	}
+	timestamps := make([]model.Time, 0, len(vectors))
+	for ts := range vectors {
+		timestamps = append(timestamps, ts)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	records := make([]Record, 0, len(timestamps))
+	for _, ts := range timestamps {
+		records = append(records, Record{
+			Time:     ts.Time(),
+			Name:     name,
+			Result:   vectors[ts],
+			Warnings: warnings,
+		})
+	}
+	return records
+}
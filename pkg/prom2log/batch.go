@@ -0,0 +1,158 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BatchConfig configures how records are grouped before being sent.
+type BatchConfig struct {
+	// BatchSize is the maximum number of records per batch, defaults to 100.
+	BatchSize int `json:"batch_size"`
+	// BatchWait is the maximum time a record waits before its batch is sent, defaults to 1s.
+	BatchWait metav1.Duration `json:"batch_wait"`
+	// Buffer stores the batches that fail to be sent on disk, to send them later.
+	Buffer *BufferConfig `json:"buffer,omitempty"`
+}
+
+// batchError is returned by the sinks sending the records of a batch in parts, e.g. to several streams or as
+// separate documents, when only some of them failed. The other records were sent.
+type batchError struct {
+	// retry are the records that failed with transient errors.
+	retry []Record
+	// rejected are the records that failed with permanent errors, they can't be sent again.
+	rejected []Record
+	err      error
+}
+
+func (e *batchError) Error() string {
+	return e.err.Error()
+}
+
+func (e *batchError) Unwrap() error {
+	return e.err
+}
+
+// failedRecords splits the records of a batch that failed to be sent with err into the ones to send again and
+// the ones rejected with permanent errors.
+func failedRecords(records []Record, err error) (retry, rejected []Record) {
+	var be *batchError
+	if errors.As(err, &be) {
+		return be.retry, be.rejected
+	}
+	var pe *permanentError
+	if errors.As(err, &pe) {
+		return nil, records
+	}
+	return records, nil
+}
+
+// batcher accumulates records and flushes them when the batch is full or on a timer.
+type batcher struct {
+	size  int
+	flush func(ctx context.Context, records []Record) error
+
+	mu      sync.Mutex
+	records []Record
+	done    chan struct{}
+	closed  sync.Once
+	wg      sync.WaitGroup
+	spool   *spool
+}
+
+func newBatcher(cfg BatchConfig, flush func(ctx context.Context, records []Record) error) (*batcher, error) {
+	size := cfg.BatchSize
+	if size <= 0 {
+		size = 100
+	}
+	wait := cfg.BatchWait.Duration
+	if wait <= 0 {
+		wait = time.Second
+	}
+	b := &batcher{
+		size:  size,
+		flush: flush,
+		done:  make(chan struct{}),
+	}
+	if cfg.Buffer != nil {
+		s, err := newSpool(*cfg.Buffer, flush)
+		if err != nil {
+			return nil, err
+		}
+		b.spool, b.flush = s, s.flush
+	}
+	b.wg.Add(1)
+	go b.loop(wait)
+	return b, nil
+}
+
+func (b *batcher) loop(wait time.Duration) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(wait)
+	defer ticker.Stop()
+	var retry <-chan time.Time
+	if b.spool != nil {
+		b.spool.retry(context.Background())
+		t := time.NewTicker(b.spool.cfg.RetryInterval.Duration)
+		defer t.Stop()
+		retry = t.C
+	}
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			if err := b.Flush(context.Background()); err != nil {
+				log().Error("failed to flush batch", "error", err)
+			}
+		case <-retry:
+			b.spool.retry(context.Background())
+		}
+	}
+}
+
+// Add adds a record to the current batch, flushing it if it's full.
+func (b *batcher) Add(ctx context.Context, r Record) error {
+	b.mu.Lock()
+	b.records = append(b.records, r)
+	full := len(b.records) >= b.size
+	b.mu.Unlock()
+	if full {
+		return b.Flush(ctx)
+	}
+	return nil
+}
+
+// Pending returns the number of records waiting to be flushed, including the ones stored in the buffer.
+func (b *batcher) Pending() int {
+	b.mu.Lock()
+	pending := len(b.records)
+	b.mu.Unlock()
+	if b.spool != nil {
+		pending += b.spool.Pending()
+	}
+	return pending
+}
+
+// Flush sends the pending records.
+func (b *batcher) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	records := b.records
+	b.records = nil
+	b.mu.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+	return b.flush(ctx, records)
+}
+
+// Close stops the timer and flushes the pending records, it can be called more than once.
+func (b *batcher) Close() error {
+	b.closed.Do(func() { close(b.done) })
+	b.wg.Wait()
+	return b.Flush(context.Background())
+}
@@ -0,0 +1,140 @@
+package prom2log
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recorder collects the batches flushed to it.
+type recorder struct {
+	mu      sync.Mutex
+	batches [][]Record
+	err     error
+}
+
+func (r *recorder) flush(_ context.Context, records []Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.batches = append(r.batches, records)
+	return nil
+}
+
+// sizes returns the size of each flushed batch.
+func (r *recorder) sizes() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	sizes := make([]int, len(r.batches))
+	for i, b := range r.batches {
+		sizes[i] = len(b)
+	}
+	return sizes
+}
+
+func TestBatcher(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int
+		records int
+		// flushed are the sizes of the batches flushed before Close
+		flushed []int
+		// closed are the sizes of the batches flushed once closed
+		closed []int
+	}{
+		{name: "under the size", size: 3, records: 2, flushed: []int{}, closed: []int{2}},
+		{name: "full batches", size: 2, records: 4, flushed: []int{2, 2}, closed: []int{2, 2}},
+		{name: "full batch and rest", size: 2, records: 5, flushed: []int{2, 2}, closed: []int{2, 2, 1}},
+		{name: "nothing", size: 2, flushed: []int{}, closed: []int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rec recorder
+			// the timer never fires during the test
+			b, err := newBatcher(BatchConfig{BatchSize: tt.size, BatchWait: metav1.Duration{Duration: time.Hour}}, rec.flush)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tt.records; i++ {
+				if err := b.Add(context.Background(), Record{Name: "test"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := rec.sizes(); !equalInts(got, tt.flushed) {
+				t.Errorf("flushed %v before closing, want %v", got, tt.flushed)
+			}
+			if got, want := b.Pending(), tt.records-sum(tt.flushed); got != want {
+				t.Errorf("Pending() = %d, want %d", got, want)
+			}
+			if err := b.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.sizes(); !equalInts(got, tt.closed) {
+				t.Errorf("flushed %v once closed, want %v", got, tt.closed)
+			}
+		})
+	}
+}
+
+func TestBatcherTimer(t *testing.T) {
+	var rec recorder
+	b, err := newBatcher(BatchConfig{BatchSize: 10, BatchWait: metav1.Duration{Duration: 10 * time.Millisecond}}, rec.flush)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.Add(context.Background(), Record{Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(rec.sizes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the batch wasn't flushed by the timer")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sum(values []int) int {
+	n := 0
+	for _, v := range values {
+		n += v
+	}
+	return n
+}
+
+func TestBatcherCloseTwice(t *testing.T) {
+	var r recorder
+	b, err := newBatcher(BatchConfig{BatchSize: 10}, r.flush)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(context.Background(), Record{Name: "up"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := b.Close(); err != nil {
+			t.Fatalf("Close() #%d = %v", i+1, err)
+		}
+	}
+	if got := r.sizes(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("flushed batches of %v, want [1]", got)
+	}
+}
@@ -0,0 +1,112 @@
+package prom2log
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrCircuitOpen is returned for queries not sent because the server's circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// BreakerConfig configures a circuit breaker, it opens after a number of consecutive failures,
+// rejecting requests until the cooldown is over and then lets a single request through to check if the server recovered.
+type BreakerConfig struct {
+	// Failures is the number of consecutive failures that open the circuit, defaults to 5.
+	Failures int `json:"failures"`
+	// Cooldown is how long the circuit stays open, defaults to 1m.
+	Cooldown metav1.Duration `json:"cooldown"`
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+type breaker struct {
+	name     string
+	failures int
+	cooldown time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	count    int
+	openedAt time.Time
+	// probing is set while the request checking if the server recovered is in flight.
+	probing bool
+}
+
+func newBreaker(name string, cfg BreakerConfig) *breaker {
+	b := &breaker{
+		name:     name,
+		failures: cfg.Failures,
+		cooldown: cfg.Cooldown.Duration,
+	}
+	if b.failures <= 0 {
+		b.failures = 5
+	}
+	if b.cooldown <= 0 {
+		b.cooldown = time.Minute
+	}
+	return b
+}
+
+// allow reports whether a request can be sent.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// cancel releases a request given up by its caller without counting its outcome, so a cancelled probe
+// lets another request check if the server recovered.
+func (b *breaker) cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.probing = false
+	}
+}
+
+// record updates the breaker with the outcome of a request.
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerHalfOpen {
+		b.probing = false
+	}
+	if err == nil {
+		if b.state != breakerClosed {
+			log().Info("circuit breaker closed", "server", b.name)
+		}
+		b.state = breakerClosed
+		b.count = 0
+		return
+	}
+	b.count++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.count >= b.failures) {
+		if b.state == breakerClosed {
+			log().Warn("circuit breaker opened", "server", b.name, "failures", b.count, "error", err)
+		}
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
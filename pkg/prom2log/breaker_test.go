@@ -0,0 +1,120 @@
+package prom2log
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBreaker(t *testing.T) {
+	errFailed := errors.New("failed")
+	// each step is applied in order to a breaker opening after 2 failures
+	type step struct {
+		// action is one of allow, success, failure, cancel or cooldown, which makes the cooldown elapse
+		action string
+		// allowed is the expected result of allow
+		allowed bool
+		state   breakerState
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens after consecutive failures",
+			steps: []step{
+				{action: "failure", state: breakerClosed},
+				{action: "allow", allowed: true, state: breakerClosed},
+				{action: "failure", state: breakerOpen},
+				{action: "allow", allowed: false, state: breakerOpen},
+			},
+		},
+		{
+			name: "a success resets the failures",
+			steps: []step{
+				{action: "failure", state: breakerClosed},
+				{action: "success", state: breakerClosed},
+				{action: "failure", state: breakerClosed},
+				{action: "allow", allowed: true, state: breakerClosed},
+			},
+		},
+		{
+			name: "a single probe after the cooldown",
+			steps: []step{
+				{action: "failure", state: breakerClosed},
+				{action: "failure", state: breakerOpen},
+				{action: "cooldown", state: breakerOpen},
+				{action: "allow", allowed: true, state: breakerHalfOpen},
+				{action: "allow", allowed: false, state: breakerHalfOpen},
+			},
+		},
+		{
+			name: "a successful probe closes it",
+			steps: []step{
+				{action: "failure", state: breakerClosed},
+				{action: "failure", state: breakerOpen},
+				{action: "cooldown", state: breakerOpen},
+				{action: "allow", allowed: true, state: breakerHalfOpen},
+				{action: "success", state: breakerClosed},
+				{action: "allow", allowed: true, state: breakerClosed},
+			},
+		},
+		{
+			name: "a failed probe opens it again",
+			steps: []step{
+				{action: "failure", state: breakerClosed},
+				{action: "failure", state: breakerOpen},
+				{action: "cooldown", state: breakerOpen},
+				{action: "allow", allowed: true, state: breakerHalfOpen},
+				{action: "failure", state: breakerOpen},
+				{action: "allow", allowed: false, state: breakerOpen},
+			},
+		},
+		{
+			name: "a cancelled probe lets another one through",
+			steps: []step{
+				{action: "failure", state: breakerClosed},
+				{action: "failure", state: breakerOpen},
+				{action: "cooldown", state: breakerOpen},
+				{action: "allow", allowed: true, state: breakerHalfOpen},
+				{action: "cancel", state: breakerHalfOpen},
+				{action: "allow", allowed: true, state: breakerHalfOpen},
+				{action: "allow", allowed: false, state: breakerHalfOpen},
+			},
+		},
+		{
+			name: "cancelling while closed changes nothing",
+			steps: []step{
+				{action: "failure", state: breakerClosed},
+				{action: "cancel", state: breakerClosed},
+				{action: "failure", state: breakerOpen},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker("test", BreakerConfig{Failures: 2, Cooldown: metav1.Duration{Duration: time.Minute}})
+			for i, s := range tt.steps {
+				switch s.action {
+				case "allow":
+					if got := b.allow(); got != s.allowed {
+						t.Fatalf("step %d: allow() = %v, want %v", i, got, s.allowed)
+					}
+				case "success":
+					b.record(nil)
+				case "failure":
+					b.record(errFailed)
+				case "cancel":
+					b.cancel()
+				case "cooldown":
+					b.openedAt = b.openedAt.Add(-b.cooldown)
+				}
+				if b.state != s.state {
+					t.Fatalf("step %d: %s: state = %d, want %d", i, s.action, b.state, s.state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package prom2log
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// changeFilter drops the records of a query whose result didn't change since the last emitted one, see Query.OnChange.
+// The records of each source, see Record.Source, are compared separately.
+type changeFilter struct {
+	heartbeat time.Duration
+
+	last map[string]emitted
+}
+
+// emitted is the fingerprint and time of the last emitted record of a source.
+type emitted struct {
+	hash uint64
+	at   time.Time
+}
+
+// newChangeFilter returns a filter for the query, or nil if it emits all its records.
+func (q *Query) newChangeFilter() *changeFilter {
+	if !q.OnChange {
+		return nil
+	}
+	return &changeFilter{heartbeat: q.Heartbeat.Duration, last: make(map[string]emitted)}
+}
+
+// emit reports whether the record should be emitted, either because its result changed
+// or because the heartbeat elapsed since the last emitted record.
+func (f *changeFilter) emit(r Record) bool {
+	if f == nil {
+		return true
+	}
+	h := r.fingerprint()
+	last, ok := f.last[r.Source]
+	if ok && h == last.hash && (f.heartbeat <= 0 || r.Time.Sub(last.at) < f.heartbeat) {
+		return false
+	}
+	f.last[r.Source] = emitted{hash: h, at: r.Time}
+	return true
+}
+
+// fingerprint hashes the error or the samples of the record, ignoring their timestamps and order,
+// so results that only differ in when they were evaluated hash the same.
+func (r Record) fingerprint() uint64 {
+	h := fnv.New64a()
+	samples, err := r.Samples()
+	if err != nil {
+		h.Write([]byte("error:" + err.Error()))
+		return h.Sum64()
+	}
+	if v, ok := r.Result.(*model.String); ok {
+		// the value of the sample is NaN for any string that isn't a number
+		h.Write([]byte("string:" + v.Value))
+		return h.Sum64()
+	}
+	lines := make([]string, len(samples))
+	for i, s := range samples {
+		names := make([]string, 0, len(s.Metric))
+		for n := range s.Metric {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		for _, n := range names {
+			b.WriteString(n + "=" + strconv.Quote(s.Metric[n]) + ",")
+		}
+		b.WriteString(" " + strconv.FormatFloat(s.Value, 'g', -1, 64))
+		lines[i] = b.String()
+	}
+	sort.Strings(lines)
+	for _, l := range lines {
+		h.Write([]byte(l + "\n"))
+	}
+	return h.Sum64()
+}
@@ -0,0 +1,134 @@
+package prom2log
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestChangeFilter(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	vector := func(value float64, ts int64, labels ...model.LabelSet) model.Vector {
+		var v model.Vector
+		for _, l := range labels {
+			v = append(v, &model.Sample{Metric: model.Metric(l), Value: model.SampleValue(value), Timestamp: model.TimeFromUnix(ts)})
+		}
+		return v
+	}
+	a, b := model.LabelSet{"job": "a"}, model.LabelSet{"job": "b"}
+	// each record is offset by its index in minutes from start
+	type record struct {
+		r    Record
+		emit bool
+	}
+	tests := []struct {
+		name      string
+		heartbeat time.Duration
+		records   []record
+	}{
+		{
+			name: "unchanged results are dropped",
+			records: []record{
+				{Record{Result: vector(1, 1, a)}, true},
+				{Record{Result: vector(1, 2, a)}, false},
+				{Record{Result: vector(2, 3, a)}, true},
+				{Record{Result: vector(2, 4, a)}, false},
+			},
+		},
+		{
+			name: "the order of the samples doesn't matter",
+			records: []record{
+				{Record{Result: vector(1, 1, a, b)}, true},
+				{Record{Result: vector(1, 2, b, a)}, false},
+				{Record{Result: vector(1, 3, a)}, true},
+			},
+		},
+		{
+			name: "errors are compared too",
+			records: []record{
+				{Record{Err: errors.New("down")}, true},
+				{Record{Err: errors.New("down")}, false},
+				{Record{Err: errors.New("timeout")}, true},
+				{Record{Result: vector(1, 1, a)}, true},
+			},
+		},
+		{
+			name: "sources are compared separately",
+			records: []record{
+				{Record{Source: "x", Result: vector(1, 1, a)}, true},
+				{Record{Source: "y", Result: vector(1, 1, a)}, true},
+				{Record{Source: "x", Result: vector(1, 2, a)}, false},
+			},
+		},
+		{
+			name: "string results",
+			records: []record{
+				{Record{Result: &model.String{Value: "a", Timestamp: model.TimeFromUnix(1)}}, true},
+				{Record{Result: &model.String{Value: "a", Timestamp: model.TimeFromUnix(2)}}, false},
+				{Record{Result: &model.String{Value: "b", Timestamp: model.TimeFromUnix(3)}}, true},
+			},
+		},
+		{
+			name:      "heartbeat",
+			heartbeat: 2 * time.Minute,
+			records: []record{
+				{Record{Result: vector(1, 1, a)}, true},
+				{Record{Result: vector(1, 2, a)}, false},
+				{Record{Result: vector(1, 3, a)}, true},
+				{Record{Result: vector(1, 4, a)}, false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := Query{OnChange: true, Heartbeat: metav1.Duration{Duration: tt.heartbeat}}
+			f := q.newChangeFilter()
+			for i, r := range tt.records {
+				r.r.Time = start.Add(time.Duration(i) * time.Minute)
+				if got := f.emit(r.r); got != r.emit {
+					t.Errorf("record %d: emit() = %v, want %v", i, got, r.emit)
+				}
+			}
+		})
+	}
+}
+
+func TestChangeFilterDisabled(t *testing.T) {
+	f := (&Query{}).newChangeFilter()
+	for i := 0; i < 2; i++ {
+		if !f.emit(Record{}) {
+			t.Fatal("records are dropped without on_change")
+		}
+	}
+}
+
+func TestSamplesString(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{value: "1.5", want: 1.5},
+		{value: "up", want: math.NaN()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			samples, err := Record{Result: &model.String{Value: tt.value, Timestamp: model.TimeFromUnix(1700000000)}}.Samples()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(samples) != 1 {
+				t.Fatalf("got %d samples, want 1", len(samples))
+			}
+			if got := samples[0].Value; got != tt.want && !(math.IsNaN(got) && math.IsNaN(tt.want)) {
+				t.Errorf("got value %v, want %v", got, tt.want)
+			}
+			if !samples[0].Timestamp.Equal(time.Unix(1700000000, 0)) {
+				t.Errorf("got timestamp %v", samples[0].Timestamp)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package prom2log
+
+import (
+	"container/heap"
+	"errors"
+)
+
+var (
+	// ErrNotRunning is returned when changing the queries of a scheduler that isn't running.
+	ErrNotRunning = errors.New("the scheduler isn't running")
+	// ErrUnknownQuery is returned when managing a query that isn't scheduled.
+	ErrUnknownQuery = errors.New("unknown query")
+)
+
+// SetQuery adds a query to a running scheduler, replacing the one with the same name if it exists.
+func (s *Scheduler) SetQuery(name string, q Query) error {
+	s.mu.Lock()
+	queries := make(map[string]Query, len(s.Queries)+1)
+	for n, q := range s.Queries {
+		queries[n] = q
+	}
+	queries[name] = q
+	stopped, err := s.update(queries, s.Servers, s.Sinks, s.Output)
+	s.mu.Unlock()
+	waitIdle(stopped)
+	return err
+}
+
+// CurrentServers returns a copy of the servers the queries run on, which are replaced when the scheduler is updated.
+func (s *Scheduler) CurrentServers() map[string]*Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	servers := make(map[string]*Server, len(s.Servers))
+	for name, srv := range s.Servers {
+		servers[name] = srv
+	}
+	return servers
+}
+
+// CurrentSinks returns a copy of the sinks the records are written to, which are replaced when the scheduler is updated.
+func (s *Scheduler) CurrentSinks() map[string]Sink {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sinks := make(map[string]Sink, len(s.Sinks))
+	for name, sink := range s.Sinks {
+		sinks[name] = sink
+	}
+	return sinks
+}
+
+// RemoveQuery stops and removes a query from a running scheduler.
+func (s *Scheduler) RemoveQuery(name string) error {
+	s.mu.Lock()
+	if _, ok := s.Queries[name]; !ok {
+		s.mu.Unlock()
+		return ErrUnknownQuery
+	}
+	queries := make(map[string]Query, len(s.Queries))
+	for n, q := range s.Queries {
+		if n != name {
+			queries[n] = q
+		}
+	}
+	stopped, err := s.update(queries, s.Servers, s.Sinks, s.Output)
+	s.mu.Unlock()
+	waitIdle(stopped)
+	return err
+}
+
+// Pause stops running a query on its schedule, it can still be triggered.
+func (s *Scheduler) Pause(name string) error {
+	return s.setPaused(name, true)
+}
+
+// Resume runs a paused query on its schedule again.
+func (s *Scheduler) Resume(name string) error {
+	return s.setPaused(name, false)
+}
+
+func (s *Scheduler) setPaused(name string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrUnknownQuery
+	}
+	if paused {
+		s.paused[name] = true
+	} else {
+		delete(s.paused, name)
+	}
+	j.mu.Lock()
+	j.status.Paused = paused
+	j.mu.Unlock()
+	return nil
+}
+
+// Trigger runs a query right away, without waiting for its schedule.
+func (s *Scheduler) Trigger(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrUnknownQuery
+	}
+	switch j.state {
+	case jobWaiting:
+		j.triggered = true
+		heap.Fix(&s.queue, j.index)
+		s.wakeUp()
+	case jobRunning:
+		// it runs again once the current run returns
+		j.triggered = true
+	}
+	// ready jobs are about to run
+	return nil
+}
+
+func (j *job) isPaused() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status.Paused
+}
+
+// SetStandby stops or resumes running all the queries on their schedules, e.g. while another replica is the leader,
+// they can still be triggered. It can be called before Run to start in standby.
+func (s *Scheduler) SetStandby(standby bool) {
+	s.standby.Store(standby)
+	if standby {
+		standbyGauge.Set(1)
+	} else {
+		standbyGauge.Set(0)
+	}
+}
+
+// Standby reports whether the scheduler is standing by, see SetStandby.
+func (s *Scheduler) Standby() bool {
+	return s.standby.Load()
+}
@@ -0,0 +1,440 @@
+package prom2log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const defaultRefreshInterval = 30 * time.Second
+
+// DiscoveryConfig resolves the addresses of a server dynamically instead of setting its URL,
+// exactly one of Kubernetes, DNS or Consul must be set.
+// The server uses one of the addresses, moving to the next one when it can't be reached.
+type DiscoveryConfig struct {
+	Kubernetes *KubernetesDiscovery `json:"kubernetes,omitempty"`
+	DNS        *DNSDiscovery        `json:"dns,omitempty"`
+	Consul     *ConsulDiscovery     `json:"consul,omitempty"`
+	// Scheme of the server URLs, defaults to http.
+	Scheme string `json:"scheme,omitempty"`
+	// Path is the prefix of the API paths, e.g. /prometheus for servers running with --web.route-prefix.
+	Path string `json:"path,omitempty"`
+	// RefreshInterval is how often the addresses are resolved again, defaults to 30s.
+	RefreshInterval metav1.Duration `json:"refresh_interval,omitempty"`
+}
+
+// KubernetesDiscovery resolves the ready endpoints of a Service, or of the Services matching a label selector,
+// using the service account of the pod prom2log runs in.
+type KubernetesDiscovery struct {
+	// Namespace of the Service, defaults to the namespace of the pod.
+	Namespace string `json:"namespace,omitempty"`
+	// Service is the name of the Service.
+	Service string `json:"service,omitempty"`
+	// LabelSelector selects the Services by label instead of by name, e.g. app.kubernetes.io/name=prometheus.
+	LabelSelector string `json:"label_selector,omitempty"`
+	// Port is the name or number of the port, defaults to the first port of the endpoints.
+	Port string `json:"port,omitempty"`
+	// APIServer is the URL of the Kubernetes API, defaults to the one of the cluster prom2log runs in.
+	APIServer string `json:"api_server,omitempty"`
+}
+
+// DNSDiscovery resolves the targets of a DNS SRV record, e.g. _web._tcp.prometheus.monitoring.svc.cluster.local.
+type DNSDiscovery struct {
+	Name string `json:"name"`
+}
+
+// ConsulDiscovery resolves the healthy instances of a Consul service.
+type ConsulDiscovery struct {
+	// Address of the Consul agent, defaults to CONSUL_HTTP_ADDR or http://localhost:8500.
+	Address    string `json:"address,omitempty"`
+	Service    string `json:"service"`
+	Tag        string `json:"tag,omitempty"`
+	Datacenter string `json:"datacenter,omitempty"`
+	// Token is the ACL token, defaults to CONSUL_HTTP_TOKEN.
+	Token string `json:"token,omitempty"`
+}
+
+func (c DiscoveryConfig) validate() error {
+	set := 0
+	for _, ok := range []bool{c.Kubernetes != nil, c.DNS != nil, c.Consul != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("discovery requires exactly one of kubernetes, dns or consul")
+	}
+	switch c.Scheme {
+	case "", "http", "https":
+	default:
+		return fmt.Errorf("unsupported discovery scheme %q", c.Scheme)
+	}
+	switch {
+	case c.Kubernetes != nil && (c.Kubernetes.Service == "") == (c.Kubernetes.LabelSelector == ""):
+		return errors.New("kubernetes discovery requires either service or label_selector")
+	case c.DNS != nil && c.DNS.Name == "":
+		return errors.New("dns discovery requires name")
+	case c.Consul != nil && c.Consul.Service == "":
+		return errors.New("consul discovery requires service")
+	}
+	return nil
+}
+
+// target is a discovered address, the ones with a lower priority are preferred.
+type target struct {
+	addr     string
+	priority int
+}
+
+// resolver keeps the URLs of a discovered server, resolving them again when they're older than the refresh interval.
+type resolver struct {
+	name     string
+	scheme   string
+	path     string
+	interval time.Duration
+	// lookup returns the targets in order of preference.
+	lookup func(ctx context.Context) ([]target, error)
+
+	mu       sync.Mutex
+	targets  []target
+	current  int
+	resolved time.Time
+	// resolving is set while a caller looks the addresses up.
+	resolving bool
+}
+
+func newResolver(cfg DiscoveryConfig) (*resolver, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	r := &resolver{
+		scheme:   cfg.Scheme,
+		path:     strings.TrimSuffix(cfg.Path, "/"),
+		interval: cfg.RefreshInterval.Duration,
+	}
+	if r.scheme == "" {
+		r.scheme = "http"
+	}
+	if r.interval <= 0 {
+		r.interval = defaultRefreshInterval
+	}
+	switch {
+	case cfg.Kubernetes != nil:
+		k, err := newKubernetesLookup(*cfg.Kubernetes)
+		if err != nil {
+			return nil, err
+		}
+		r.name, r.lookup = "kubernetes:"+k.describe(), k.lookup
+	case cfg.DNS != nil:
+		name := cfg.DNS.Name
+		r.name, r.lookup = "dns:"+name, func(ctx context.Context) ([]target, error) {
+			_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+			if err != nil {
+				return nil, err
+			}
+			// the targets with the lowest priority come first, then the ones with the highest weight, see RFC2782,
+			// always in the same order rather than picking them at random in proportion to their weight
+			sort.SliceStable(srvs, func(i, k int) bool {
+				if srvs[i].Priority != srvs[k].Priority {
+					return srvs[i].Priority < srvs[k].Priority
+				}
+				if srvs[i].Weight != srvs[k].Weight {
+					return srvs[i].Weight > srvs[k].Weight
+				}
+				return srvs[i].Target < srvs[k].Target
+			})
+			targets := make([]target, 0, len(srvs))
+			for _, srv := range srvs {
+				targets = append(targets, target{
+					addr:     net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))),
+					priority: int(srv.Priority),
+				})
+			}
+			return targets, nil
+		}
+	case cfg.Consul != nil:
+		c := newConsulLookup(*cfg.Consul)
+		r.name, r.lookup = "consul:"+cfg.Consul.Service, c.lookup
+	}
+	return r, nil
+}
+
+// url returns the base URL of the address in use, resolving the addresses first if they're stale.
+// When resolving fails, the previous addresses are kept until it succeeds.
+func (r *resolver) url(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	// a single caller resolves the stale addresses, without holding the lock as it can take up to the DNS or API
+	// timeout, the others keep using the previous addresses meanwhile, unless there are none yet
+	refresh := time.Since(r.resolved) >= r.interval && (!r.resolving || len(r.targets) == 0)
+	if !refresh {
+		defer r.mu.Unlock()
+		return r.base(), nil
+	}
+	r.resolving = true
+	r.mu.Unlock()
+
+	targets, err := r.lookup(ctx)
+	if err == nil && len(targets) == 0 {
+		err = errors.New("no addresses found")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolving = false
+	switch {
+	case err != nil && len(r.targets) == 0:
+		return "", fmt.Errorf("discovering %s: %w", r.name, err)
+	case err != nil:
+		log().Warn("discovery failed, using the previous addresses", "server", r.name, "error", err)
+	default:
+		r.update(targets)
+	}
+	r.resolved = time.Now()
+	return r.base(), nil
+}
+
+// base returns the base URL of the address in use, r.mu must be held.
+func (r *resolver) base() string {
+	return r.scheme + "://" + r.targets[r.current].addr + r.path
+}
+
+// update replaces the targets with the ones resolved, r.mu must be held.
+func (r *resolver) update(targets []target) {
+	if reflect.DeepEqual(targets, r.targets) {
+		return
+	}
+	addrs := make([]string, len(targets))
+	for i, t := range targets {
+		addrs[i] = t.addr
+	}
+	log().Info("discovered server addresses", "server", r.name, "addresses", addrs)
+	// stay on the same address if it's still there, unless a target with a better priority appeared, so that
+	// after failing over the preferred targets are used again once they're back
+	prev := r.current
+	r.current = 0
+	if len(r.targets) > 0 {
+		current := r.targets[prev]
+		known := make(map[string]bool, len(r.targets))
+		for _, t := range r.targets {
+			known[t.addr] = true
+		}
+		for i, t := range targets {
+			if !known[t.addr] && t.priority < current.priority {
+				break
+			}
+			if t.addr == current.addr {
+				r.current = i
+				break
+			}
+		}
+	}
+	r.targets = targets
+}
+
+// failed moves to the next address after a request to the given base URL failed to connect.
+func (r *resolver) failed(base string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.targets) > 1 && base == r.base() {
+		r.current = (r.current + 1) % len(r.targets)
+	}
+}
+
+// kubernetesLookup lists the endpoints of Services using the Kubernetes API.
+type kubernetesLookup struct {
+	cfg    KubernetesDiscovery
+	client *kubeClient
+}
+
+func newKubernetesLookup(cfg KubernetesDiscovery) (*kubernetesLookup, error) {
+	client, err := newKubeClient(cfg.APIServer)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes discovery: %w", err)
+	}
+	if cfg.Namespace == "" {
+		if cfg.Namespace, err = podNamespace(); err != nil {
+			return nil, fmt.Errorf("kubernetes discovery: %w", err)
+		}
+	}
+	return &kubernetesLookup{cfg: cfg, client: client}, nil
+}
+
+func (k *kubernetesLookup) describe() string {
+	if k.cfg.Service != "" {
+		return k.cfg.Namespace + "/" + k.cfg.Service
+	}
+	return k.cfg.Namespace + "/" + k.cfg.LabelSelector
+}
+
+// endpoints is the part of the Kubernetes Endpoints resource used to find the addresses.
+type endpoints struct {
+	Subsets []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []struct {
+			Name string `json:"name"`
+			Port int    `json:"port"`
+		} `json:"ports"`
+	} `json:"subsets"`
+}
+
+func (k *kubernetesLookup) lookup(ctx context.Context) ([]target, error) {
+	path := "/api/v1/namespaces/" + url.PathEscape(k.cfg.Namespace) + "/endpoints"
+	if k.cfg.Service != "" {
+		path += "/" + url.PathEscape(k.cfg.Service)
+	} else {
+		path += "?labelSelector=" + url.QueryEscape(k.cfg.LabelSelector)
+	}
+	b, err := k.client.get(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	var list []endpoints
+	if k.cfg.Service != "" {
+		var e endpoints
+		if err := json.Unmarshal(b, &e); err != nil {
+			return nil, err
+		}
+		list = []endpoints{e}
+	} else {
+		var l struct {
+			Items []endpoints `json:"items"`
+		}
+		if err := json.Unmarshal(b, &l); err != nil {
+			return nil, err
+		}
+		list = l.Items
+	}
+	var addrs []string
+	for _, e := range list {
+		for _, s := range e.Subsets {
+			port := 0
+			for _, p := range s.Ports {
+				if k.cfg.Port == "" || p.Name == k.cfg.Port || strconv.Itoa(p.Port) == k.cfg.Port {
+					port = p.Port
+					break
+				}
+			}
+			if port == 0 {
+				continue
+			}
+			for _, a := range s.Addresses {
+				addrs = append(addrs, net.JoinHostPort(a.IP, strconv.Itoa(port)))
+			}
+		}
+	}
+	return sortedTargets(addrs), nil
+}
+
+// consulLookup lists the healthy instances of a service using the Consul health API.
+type consulLookup struct {
+	cfg    ConsulDiscovery
+	client *http.Client
+}
+
+func newConsulLookup(cfg ConsulDiscovery) *consulLookup {
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if cfg.Address == "" {
+		cfg.Address = "http://localhost:8500"
+	}
+	if !strings.Contains(cfg.Address, "://") {
+		cfg.Address = "http://" + cfg.Address
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	return &consulLookup{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (c *consulLookup) lookup(ctx context.Context) ([]target, error) {
+	params := url.Values{"passing": []string{"true"}}
+	if c.cfg.Tag != "" {
+		params.Set("tag", c.cfg.Tag)
+	}
+	if c.cfg.Datacenter != "" {
+		params.Set("dc", c.cfg.Datacenter)
+	}
+	u := c.cfg.Address + "/v1/health/service/" + url.PathEscape(c.cfg.Service) + "?" + params.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.cfg.Token != "" {
+		req.Header.Set("X-Consul-Token", c.cfg.Token)
+	}
+	b, err := getJSON(c.client, req)
+	if err != nil {
+		return nil, err
+	}
+	var entries []struct {
+		Node struct {
+			Address string `json:"Address"`
+		} `json:"Node"`
+		Service struct {
+			Address string `json:"Address"`
+			Port    int    `json:"Port"`
+		} `json:"Service"`
+	}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		host := e.Service.Address
+		if host == "" {
+			host = e.Node.Address
+		}
+		addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(e.Service.Port)))
+	}
+	return sortedTargets(addrs), nil
+}
+
+// sortedTargets returns the addresses, sorted so that the order doesn't change between lookups, as targets with the
+// same priority.
+func sortedTargets(addrs []string) []target {
+	sort.Strings(addrs)
+	targets := make([]target, len(addrs))
+	for i, a := range addrs {
+		targets[i] = target{addr: a}
+	}
+	return targets
+}
+
+// getJSON sends the request and returns the body of successful responses.
+func getJSON(client *http.Client, req *http.Request) ([]byte, error) {
+	req.Header.Set("Accept", "application/json")
+	response, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		if len(b) > 256 {
+			b = b[:256]
+		}
+		return nil, fmt.Errorf("unexpected status %s: %s", response.Status, strings.TrimSpace(string(b)))
+	}
+	return b, nil
+}
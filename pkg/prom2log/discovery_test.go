@@ -0,0 +1,47 @@
+package prom2log
+
+import (
+	"context"
+	"testing"
+)
+
+func TestResolverUpdate(t *testing.T) {
+	a, b, c := target{addr: "a:80"}, target{addr: "b:80", priority: 1}, target{addr: "c:80", priority: 1}
+	tests := []struct {
+		name   string
+		first  []target
+		failed int
+		next   []target
+		want   string
+	}{
+		{name: "same targets", first: []target{a, b, c}, failed: 1, next: []target{a, b, c}, want: "b:80"},
+		{name: "current target still listed", first: []target{a, b, c}, failed: 1, next: []target{a, c, b}, want: "b:80"},
+		{name: "current target removed", first: []target{a, b, c}, failed: 1, next: []target{a, c}, want: "a:80"},
+		{name: "better priority target back", first: []target{b, c}, next: []target{a, b, c}, want: "a:80"},
+		{name: "same priority target added", first: []target{a, c}, failed: 1, next: []target{a, b, c}, want: "c:80"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := tt.first
+			r := &resolver{scheme: "http", interval: defaultRefreshInterval, lookup: func(context.Context) ([]target, error) {
+				return targets, nil
+			}}
+			for i := 0; i < tt.failed; i++ {
+				base, err := r.url(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				r.failed(base)
+			}
+			targets = tt.next
+			r.resolved = r.resolved.Add(-r.interval)
+			got, err := r.url(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "http://" + tt.want; got != want {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
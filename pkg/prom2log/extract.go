@@ -0,0 +1,256 @@
+package prom2log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Extractor selects a part of the results of a query, to keep only the relevant values in the records.
+type Extractor struct {
+	expr  string
+	steps []extractStep
+	// multi is set when the expression iterates, its results are then collected in an array.
+	multi bool
+}
+
+type extractStep struct {
+	key     string
+	index   int
+	isIndex bool
+	iterate bool
+}
+
+// ParseExtract parses an extraction expression, a jq-like path made of .name or ["name"] keys, [N] indexes, negative
+// ones counting from the end, and [] iterating over the elements of an array or the values of an object,
+// e.g. .[].metric.instance. The JSONPath forms $, ['name'], [*] and .* are accepted too.
+func ParseExtract(expr string) (*Extractor, error) {
+	e := &Extractor{expr: expr}
+	s := strings.TrimSpace(expr)
+	if s == "" {
+		return nil, errors.New("empty expression")
+	}
+	s = strings.TrimPrefix(s, "$")
+	if s == "." {
+		return e, nil
+	}
+	for s != "" {
+		pos := len(expr) - len(s)
+		var (
+			step extractStep
+			err  error
+		)
+		switch {
+		case strings.HasPrefix(s, ".."):
+			return nil, fmt.Errorf("recursive descent isn't supported, at %d", pos)
+		case strings.HasPrefix(s, ".["):
+			s = s[1:]
+			continue
+		case strings.HasPrefix(s, ".*"):
+			step.iterate, s = true, s[2:]
+		case strings.HasPrefix(s, `."`):
+			step.key, s, err = unquote(s[1:])
+		case s[0] == '.':
+			n := 1
+			for n < len(s) && isIdentChar(s[n]) {
+				n++
+			}
+			if n == 1 {
+				return nil, fmt.Errorf("expected a key after . at %d", pos)
+			}
+			step.key, s = s[1:n], s[n:]
+		case s[0] == '[':
+			step, s, err = parseBracket(s[1:])
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", s[0], pos)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w, at %d", err, pos)
+		}
+		e.multi = e.multi || step.iterate
+		e.steps = append(e.steps, step)
+	}
+	return e, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// parseBracket parses what follows the [ of a step, up to its closing ].
+func parseBracket(s string) (extractStep, string, error) {
+	var step extractStep
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		key, rest, err := unquote(s)
+		if err != nil {
+			return step, "", err
+		}
+		if !strings.HasPrefix(rest, "]") {
+			return step, "", errors.New("expected ]")
+		}
+		step.key = key
+		return step, rest[1:], nil
+	}
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return step, "", errors.New("expected ]")
+	}
+	switch inner := strings.TrimSpace(s[:end]); inner {
+	case "", "*":
+		step.iterate = true
+	default:
+		i, err := strconv.Atoi(inner)
+		if err != nil {
+			return step, "", fmt.Errorf("invalid index %q", inner)
+		}
+		step.index, step.isIndex = i, true
+	}
+	return step, s[end+1:], nil
+}
+
+// unquote returns the string quoted at the start of s, in double quotes with Go escapes or in single quotes,
+// and the rest of s.
+func unquote(s string) (string, string, error) {
+	if s[0] == '\'' {
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : end+1], s[end+2:], nil
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			key, err := strconv.Unquote(s[:i+1])
+			return key, s[i+1:], err
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
+
+// String returns the expression the extractor was parsed from.
+func (e *Extractor) String() string {
+	return e.expr
+}
+
+// Extract returns the part of the JSON document selected by the expression, the results of expressions iterating
+// over arrays or objects are collected in an array. Missing keys and indexes select null.
+func (e *Extractor) Extract(doc []byte) (json.RawMessage, error) {
+	d := json.NewDecoder(bytes.NewReader(doc))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	values := []interface{}{v}
+	for _, step := range e.steps {
+		var next []interface{}
+		for _, v := range values {
+			selected, err := step.apply(v)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, selected...)
+		}
+		values = next
+	}
+	if e.multi {
+		if values == nil {
+			values = []interface{}{}
+		}
+		return json.Marshal(values)
+	}
+	return json.Marshal(values[0])
+}
+
+func (s extractStep) apply(v interface{}) ([]interface{}, error) {
+	switch {
+	case s.iterate:
+		switch v := v.(type) {
+		case []interface{}:
+			return v, nil
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			values := make([]interface{}, len(keys))
+			for i, k := range keys {
+				values[i] = v[k]
+			}
+			return values, nil
+		}
+		return nil, fmt.Errorf("can't iterate over %s", jsonType(v))
+	case s.isIndex:
+		switch v := v.(type) {
+		case []interface{}:
+			i := s.index
+			if i < 0 {
+				i += len(v)
+			}
+			if i < 0 || i >= len(v) {
+				return []interface{}{nil}, nil
+			}
+			return []interface{}{v[i]}, nil
+		case nil:
+			return []interface{}{nil}, nil
+		}
+		return nil, fmt.Errorf("can't index %s with %d", jsonType(v), s.index)
+	default:
+		switch v := v.(type) {
+		case map[string]interface{}:
+			return []interface{}{v[s.key]}, nil
+		case nil:
+			return []interface{}{nil}, nil
+		}
+		return nil, fmt.Errorf("can't index %s with %q", jsonType(v), s.key)
+	}
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	}
+	return "object"
+}
+
+// extracted returns the part of the record selected by its Extract expression, applied to the sample of flattened
+// records, as {"metric": {...}, "value": 1, "timestamp": 1792045490.727}, and to the result otherwise,
+// as the result of the Prometheus API, e.g. [{"metric": {...}, "value": [1792045490.727, "1"]}] for vectors.
+func (r Record) extracted() (json.RawMessage, error) {
+	var doc []byte
+	if r.Sample != nil {
+		metric, err := json.Marshal(r.Sample.Metric)
+		if err != nil {
+			return nil, err
+		}
+		doc = []byte(fmt.Sprintf(`{"metric": %s, "value": %s, "timestamp": %s}`,
+			metric, jsonNumber(r.Sample.Value), unixSeconds(r.Sample.Timestamp)))
+	} else {
+		var err error
+		if doc, err = json.Marshal(r.Result); err != nil {
+			return nil, err
+		}
+	}
+	v, err := r.Extract.Extract(doc)
+	if err != nil {
+		return nil, fmt.Errorf("extracting %s: %w", r.Extract, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,59 @@
+package prom2log
+
+import "testing"
+
+func TestExtract(t *testing.T) {
+	const doc = `{"data": {"result": [
+		{"metric": {"instance": "a", "job": "node"}, "value": [1, "1"]},
+		{"metric": {"instance": "b", "job": "node"}, "value": [1, "0"]}
+	], "odd key": true}}`
+	tests := []struct {
+		expr     string
+		want     string
+		parseErr bool
+		wantErr  bool
+	}{
+		{expr: ".", want: `{"data":{"odd key":true,"result":[{"metric":{"instance":"a","job":"node"},"value":[1,"1"]},{"metric":{"instance":"b","job":"node"},"value":[1,"0"]}]}}`},
+		{expr: "$", want: `{"data":{"odd key":true,"result":[{"metric":{"instance":"a","job":"node"},"value":[1,"1"]},{"metric":{"instance":"b","job":"node"},"value":[1,"0"]}]}}`},
+		{expr: ".data.result[0].metric.instance", want: `"a"`},
+		{expr: ".data.result[-1].value[1]", want: `"0"`},
+		{expr: ".data.result[].metric.instance", want: `["a","b"]`},
+		{expr: ".data.result.[].metric.instance", want: `["a","b"]`},
+		{expr: "$.data.result[*].metric.instance", want: `["a","b"]`},
+		{expr: ".data.result[0].metric.*", want: `["a","node"]`},
+		{expr: `.data["odd key"]`, want: `true`},
+		{expr: `.data['odd key']`, want: `true`},
+		{expr: `.data."odd key"`, want: `true`},
+		{expr: ".data.missing", want: `null`},
+		{expr: ".data.missing.deeper[0]", want: `null`},
+		{expr: ".data.result[5]", want: `null`},
+		{expr: ".data.missing[]", wantErr: true},
+		{expr: ".data.result.metric", wantErr: true},
+		{expr: ".data.result[0][0]", wantErr: true},
+		{expr: "", parseErr: true},
+		{expr: "..data", parseErr: true},
+		{expr: ".data[0", parseErr: true},
+		{expr: ".data[x]", parseErr: true},
+		{expr: `.data["odd key`, parseErr: true},
+		{expr: "data", parseErr: true},
+		{expr: ".data.", parseErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := ParseExtract(tt.expr)
+			if (err != nil) != tt.parseErr {
+				t.Fatalf("ParseExtract(%q) error = %v, want error %v", tt.expr, err, tt.parseErr)
+			}
+			if tt.parseErr {
+				return
+			}
+			got, err := e.Extract([]byte(doc))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Extract(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+			}
+			if !tt.wantErr && string(got) != tt.want {
+				t.Errorf("Extract(%q) = %s, want %s", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package prom2log
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Built-in fields, computed by prom2log, that can be added to the records of a query with BuiltinFields.
+const (
+	// FieldHostname is the name of the host running prom2log.
+	FieldHostname = "hostname"
+	// FieldQuery is the PromQL expression of the query.
+	FieldQuery = "query"
+	// FieldServer is the server of the query, as configured, or its servers separated by commas.
+	FieldServer = "server"
+)
+
+// Builtins lists the supported built-in fields.
+var Builtins = []string{FieldHostname, FieldQuery, FieldServer}
+
+func (q *Query) validateFields() error {
+	for _, f := range q.BuiltinFields {
+		if !contains(Builtins, f) {
+			return fmt.Errorf("unknown built-in field %q", f)
+		}
+	}
+	for _, f := range append(append([]string{}, q.BuiltinFields...), sortedKeys(q.ExtraFields)...) {
+		if reservedFields[f] || f == "severity" {
+			return fmt.Errorf("field %q clashes with a record field", f)
+		}
+	}
+	return nil
+}
+
+// fields returns the extra and built-in fields added to the records of the query.
+func (q *Query) fields() map[string]string {
+	if len(q.ExtraFields) == 0 && len(q.BuiltinFields) == 0 {
+		return nil
+	}
+	fields := make(map[string]string, len(q.ExtraFields)+len(q.BuiltinFields))
+	for k, v := range q.ExtraFields {
+		fields[k] = v
+	}
+	for _, f := range q.BuiltinFields {
+		switch f {
+		case FieldHostname:
+			fields[f], _ = os.Hostname()
+		case FieldQuery:
+			fields[f] = q.PromQL
+		case FieldServer:
+			fields[f] = strings.Join(q.serverNames(), ",")
+		}
+	}
+	return fields
+}
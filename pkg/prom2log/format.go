@@ -0,0 +1,305 @@
+package prom2log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/chroma/quick"
+	"github.com/alecthomas/chroma/styles"
+)
+
+// Output formats.
+const (
+	FormatJSON   = "json"
+	FormatLogfmt = "logfmt"
+	FormatCSV    = "csv"
+	FormatTSV    = "tsv"
+	// FormatECS renders the records as Elastic Common Schema documents.
+	FormatECS = "ecs"
+)
+
+// Formats lists the supported output formats.
+var Formats = []string{FormatJSON, FormatLogfmt, FormatCSV, FormatTSV, FormatECS}
+
+// Formatter renders records as log lines.
+type Formatter struct {
+	// Encoding is the output format used for records that don't set their own, defaults to json.
+	Encoding     string
+	NoPrettyJSON bool
+	NoColour     bool
+	// Theme is the name of the style of the coloured output, see Themes, defaults to ThemeAuto.
+	Theme string
+
+	// header is the last header written by the csv and tsv formats, it's created on first use. The formatters of
+	// the sinks, which format records concurrently, create it upfront with sinkFormatter.
+	header *csvHeader
+}
+
+// Format writes the record to w.
+func (f *Formatter) Format(w io.Writer, r Record) error {
+	if r.Template != nil {
+		res, err := templateRecord(r)
+		if err != nil {
+			return err
+		}
+		return f.highlight(w, res, "plaintext")
+	}
+
+	encoding := r.Format
+	if encoding == "" {
+		encoding = f.Encoding
+	}
+	if r.Extract != nil && encoding != "" && encoding != FormatJSON && encoding != FormatLogfmt {
+		return fmt.Errorf("extract can't be used with the %s format", encoding)
+	}
+
+	var (
+		res   string
+		lexer string
+	)
+	switch encoding {
+	case "", FormatJSON:
+		res = jsonRecord(r)
+		lexer = "json"
+		if !f.NoPrettyJSON {
+			var err error
+			res, err = prettyJSON(res)
+			if err != nil {
+				return err
+			}
+		}
+	case FormatLogfmt:
+		res = logfmtRecord(r)
+		lexer = "plaintext"
+	case FormatECS:
+		var err error
+		if res, err = ecsRecord(r); err != nil {
+			return err
+		}
+		lexer = "json"
+	case FormatCSV, FormatTSV:
+		comma := ','
+		if encoding == FormatTSV {
+			comma = '\t'
+		}
+		var err error
+		if res, err = f.csvRecord(r, comma); err != nil {
+			return err
+		}
+		lexer = "plaintext"
+	default:
+		return fmt.Errorf("unknown format %q", encoding)
+	}
+
+	return f.highlight(w, res, lexer)
+}
+
+// FormatJSON writes v as JSON, pretty printed and coloured unless disabled.
+func (f *Formatter) FormatJSON(w io.Writer, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	res := string(b)
+	if !f.NoPrettyJSON {
+		if res, err = prettyJSON(res); err != nil {
+			return err
+		}
+	}
+	return f.highlight(w, res+"\n", "json")
+}
+
+func (f *Formatter) highlight(w io.Writer, res, lexer string) error {
+	if f.NoColour {
+		_, err := io.WriteString(w, res)
+		return err
+	}
+
+	return quick.Highlight(w, res, lexer, terminalFormatter(), f.theme())
+}
+
+// ThemeAuto picks a light or dark theme depending on the background colour of the terminal.
+const ThemeAuto = "auto"
+
+const (
+	darkTheme  = "native"
+	lightTheme = "github"
+)
+
+// Themes returns the names of the available themes, besides ThemeAuto.
+func Themes() []string {
+	return styles.Names()
+}
+
+// ValidateTheme checks that the theme exists.
+func ValidateTheme(theme string) error {
+	if theme == "" || theme == ThemeAuto || contains(Themes(), theme) {
+		return nil
+	}
+	return fmt.Errorf("unknown theme %q", theme)
+}
+
+func (f *Formatter) theme() string {
+	if f.Theme != "" && f.Theme != ThemeAuto {
+		return f.Theme
+	}
+	if lightBackground() {
+		return lightTheme
+	}
+	return darkTheme
+}
+
+// lightBackground reports whether the terminal has a light background, according to the COLORFGBG variable set by
+// some terminals, e.g. "0;15" for black on white, assuming a dark one otherwise.
+func lightBackground() bool {
+	fgbg := os.Getenv("COLORFGBG")
+	bg, err := strconv.Atoi(fgbg[strings.LastIndex(fgbg, ";")+1:])
+	if err != nil {
+		return false
+	}
+	// the light colours of the 16 colour palette
+	return bg == 7 || (bg >= 9 && bg <= 15)
+}
+
+// terminalFormatter returns the chroma formatter for the colours supported by the terminal.
+func terminalFormatter() string {
+	switch {
+	case os.Getenv("COLORTERM") == "truecolor" || os.Getenv("COLORTERM") == "24bit":
+		return "terminal16m"
+	case strings.Contains(os.Getenv("TERM"), "256color"):
+		return "terminal256"
+	}
+	return "terminal"
+}
+
+func jsonRecord(r Record) string {
+	switch {
+	case r.Err != nil:
+		return jsonHeader(r) + `, "error": ` + jsonString(r.Err.Error()) + "}\n"
+	case r.Extract != nil:
+		v, err := r.extracted()
+		if err != nil {
+			return jsonHeader(r) + `, "error": ` + jsonString(err.Error()) + "}\n"
+		}
+		return jsonHeader(r) + `, "result": ` + string(v) + "}\n"
+	case r.Sample != nil:
+		return sampleJSON(r)
+	default:
+		result, err := resultJSON(r.Result, r.Warnings)
+		if err != nil {
+			return jsonHeader(r) + `, "error": ` + jsonString(err.Error()) + "}\n"
+		}
+		header := jsonHeader(r)
+		if severity := r.Severity(); severity != "" {
+			header += `, "severity": ` + jsonString(severity)
+		}
+		return header + `, "result": ` + string(result) + "}\n"
+	}
+}
+
+// asJSON returns the record to be rendered as a JSON document, for the sinks sending documents rather than lines:
+// records with a template or a format other than json or ecs are rendered as json.
+func asJSON(r Record) Record {
+	r.Template = nil
+	if r.Format != FormatECS {
+		r.Format = FormatJSON
+	}
+	return r
+}
+
+// rendersJSON reports whether the record is rendered as a JSON document, rather than with a template
+// or a format producing lines.
+func (f *Formatter) rendersJSON(r Record) bool {
+	if r.Template != nil {
+		return false
+	}
+	encoding := r.Format
+	if encoding == "" {
+		encoding = f.Encoding
+	}
+	return encoding == "" || encoding == FormatJSON || encoding == FormatECS
+}
+
+// jsonHeader renders the opening of a JSON record, with the fields all of them have.
+func jsonHeader(r Record) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `{"time": %s, "name": %s`, r.timeJSON(), jsonString(r.Name))
+	for _, k := range sortedKeys(r.Fields) {
+		fmt.Fprintf(&sb, `, %s: %s`, jsonString(k), jsonString(r.Fields[k]))
+	}
+	return sb.String()
+}
+
+// reservedFields can't be used by labels promoted to top level fields.
+var reservedFields = map[string]bool{
+	"time":      true,
+	"name":      true,
+	"value":     true,
+	"timestamp": true,
+	"error":     true,
+}
+
+// labelField returns the name of the field a label is promoted to, labels clashing with the reserved fields,
+// the extra fields or the severity field when the record has thresholds, are prefixed with "label_".
+func (r Record) labelField(name string) string {
+	if _, extra := r.Fields[name]; extra || reservedFields[name] || (name == "severity" && r.Thresholds != nil) {
+		return "label_" + name
+	}
+	return name
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// jsonNumber renders v as a JSON number, NaN and infinities are rendered as strings.
+func jsonNumber(v float64) string {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return jsonString(strconv.FormatFloat(v, 'f', -1, 64))
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// unixSeconds renders t as seconds since the epoch with millisecond precision, like the Prometheus API.
+func unixSeconds(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixMilli())/1e3, 'f', -1, 64)
+}
+
+func sampleJSON(r Record) string {
+	var sb strings.Builder
+	sb.WriteString(jsonHeader(r))
+	for _, k := range sortedKeys(r.Sample.Metric) {
+		fmt.Fprintf(&sb, `, %s: %s`, jsonString(r.labelField(k)), jsonString(r.Sample.Metric[k]))
+	}
+	if severity := r.sampleSeverity(*r.Sample); severity != "" {
+		fmt.Fprintf(&sb, `, "severity": %s`, jsonString(severity))
+	}
+	fmt.Fprintf(&sb, `, "value": %s, "timestamp": %s}`+"\n", jsonNumber(r.Sample.Value), unixSeconds(r.Sample.Timestamp))
+	return sb.String()
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func prettyJSON(str string) (string, error) {
+	var pj bytes.Buffer
+	if err := json.Indent(&pj, []byte(str), "", "  "); err != nil {
+		return "", err
+	}
+	return pj.String(), nil
+}
@@ -0,0 +1,97 @@
+package prom2log
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// csvHeader is the last header written by a formatter. It's guarded by a mutex as the sinks format records
+// concurrently, e.g. when batches are flushed by a full batch and the timer at the same time.
+type csvHeader struct {
+	mu   sync.Mutex
+	last string
+}
+
+// sinkFormatter returns the formatter of the sinks sending records over the network, safe for concurrent use.
+func sinkFormatter() Formatter {
+	return Formatter{NoPrettyJSON: true, NoColour: true, header: &csvHeader{}}
+}
+
+// csvRecord renders the record samples as delimiter separated values.
+// The header row, with the extra fields and label names followed by value, timestamp and, with thresholds, severity,
+// is only written when it differs from the previous one.
+func (f *Formatter) csvRecord(r Record, comma rune) (string, error) {
+	if r.Err != nil {
+		return fmt.Sprintf("# %s: %v\n", r.Name, r.Err), nil
+	}
+	samples, err := r.Samples()
+	if err != nil {
+		return "", err
+	}
+
+	names := map[string]struct{}{}
+	for _, s := range samples {
+		for k := range s.Metric {
+			names[k] = struct{}{}
+		}
+	}
+	labels := make([]string, 0, len(names))
+	for k := range names {
+		labels = append(labels, k)
+	}
+	sort.Strings(labels)
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = comma
+	fields := sortedKeys(r.Fields)
+	header := append(append(append([]string{}, fields...), labels...), "value", "timestamp")
+	if r.Thresholds != nil {
+		header = append(header, "severity")
+	}
+	if f.newHeader(strings.Join(header, "\x00")) {
+		if err := w.Write(header); err != nil {
+			return "", err
+		}
+	}
+	row := make([]string, len(header))
+	for i, f := range fields {
+		row[i] = r.Fields[f]
+	}
+	n := len(fields) + len(labels)
+	for _, s := range samples {
+		for i, l := range labels {
+			row[len(fields)+i] = s.Metric[l]
+		}
+		row[n] = strconv.FormatFloat(s.Value, 'f', -1, 64)
+		row[n+1] = unixSeconds(s.Timestamp)
+		if r.Thresholds != nil {
+			row[n+2] = r.sampleSeverity(s)
+		}
+		if err := w.Write(row); err != nil {
+			return "", err
+		}
+	}
+	w.Flush()
+	return buf.String(), w.Error()
+}
+
+// newHeader records the header of a record, reporting whether it differs from the previous one.
+func (f *Formatter) newHeader(key string) bool {
+	if f.header == nil {
+		f.header = &csvHeader{}
+	}
+	h := f.header
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if key == h.last {
+		return false
+	}
+	h.last = key
+	return true
+}
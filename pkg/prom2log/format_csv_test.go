@@ -0,0 +1,117 @@
+package prom2log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestCSVHeader(t *testing.T) {
+	ts := model.TimeFromUnix(1700000000)
+	vector := func(labels ...model.LabelSet) model.Vector {
+		var v model.Vector
+		for _, l := range labels {
+			v = append(v, &model.Sample{Metric: model.Metric(l), Value: 1, Timestamp: ts})
+		}
+		return v
+	}
+	tests := []struct {
+		name    string
+		format  string
+		records []Record
+		want    string
+	}{
+		{
+			name:   "header written once",
+			format: FormatCSV,
+			records: []Record{
+				{Name: "up", Result: vector(model.LabelSet{"job": "a"})},
+				{Name: "up", Result: vector(model.LabelSet{"job": "b"})},
+			},
+			want: "job,value,timestamp\na,1,1700000000\nb,1,1700000000\n",
+		},
+		{
+			name:   "header written again when it changes",
+			format: FormatCSV,
+			records: []Record{
+				{Name: "up", Result: vector(model.LabelSet{"job": "a"})},
+				{Name: "up", Result: vector(model.LabelSet{"job": "a", "instance": "x"})},
+				{Name: "up", Result: vector(model.LabelSet{"job": "b"})},
+			},
+			want: "job,value,timestamp\na,1,1700000000\n" +
+				"instance,job,value,timestamp\nx,a,1,1700000000\n" +
+				"job,value,timestamp\nb,1,1700000000\n",
+		},
+		{
+			name:   "union of the labels",
+			format: FormatTSV,
+			records: []Record{
+				{Name: "up", Result: vector(model.LabelSet{"job": "a"}, model.LabelSet{"instance": "x"})},
+			},
+			want: "instance\tjob\tvalue\ttimestamp\n\ta\t1\t1700000000\nx\t\t1\t1700000000\n",
+		},
+		{
+			name:   "fields and severity",
+			format: FormatCSV,
+			records: []Record{
+				{
+					Name:       "up",
+					Result:     vector(model.LabelSet{"job": "a"}),
+					Fields:     map[string]string{"env": "prod"},
+					Thresholds: &Thresholds{Warn: ">= 1"},
+				},
+			},
+			want: "env,job,value,timestamp,severity\nprod,a,1,1700000000,warning\n",
+		},
+		{
+			name:    "errors are comments",
+			format:  FormatCSV,
+			records: []Record{{Name: "up", Err: errors.New("test error")}},
+			want:    "# up: test error\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Formatter{Encoding: tt.format, NoColour: true}
+			var buf bytes.Buffer
+			for _, r := range tt.records {
+				r.Time = time.Unix(1700000000, 0)
+				if err := f.Format(&buf, r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCSVHeaderConcurrent formats records concurrently with the formatter of a sink, to be run with -race.
+func TestCSVHeaderConcurrent(t *testing.T) {
+	f := sinkFormatter()
+	f.Encoding = FormatCSV
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := Record{Name: "up", Result: model.Vector{
+				&model.Sample{Metric: model.Metric{model.LabelName(fmt.Sprint("l", i)): "v"}, Value: 1},
+			}}
+			for k := 0; k < 100; k++ {
+				if err := f.Format(io.Discard, r); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,75 @@
+package prom2log
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ecsRecord renders the record as Elastic Common Schema documents, one line per sample.
+// The query name is the event dataset, the series labels and extra fields are in labels and the sample value
+// is metric.value. Records without samples, like failed queries, are rendered as a single document.
+func ecsRecord(r Record) (string, error) {
+	if r.Err != nil {
+		doc := ecsDoc(r, r.Time)
+		doc["event"].(map[string]interface{})["outcome"] = "failure"
+		doc["error"] = map[string]interface{}{"message": r.Err.Error()}
+		return ecsLine(doc)
+	}
+	samples, err := r.Samples()
+	if err != nil {
+		return "", err
+	}
+	if len(samples) == 0 {
+		return ecsLine(ecsDoc(r, r.Time))
+	}
+	var sb strings.Builder
+	for _, s := range samples {
+		ts := s.Timestamp
+		if ts.IsZero() {
+			ts = r.Time
+		}
+		doc := ecsDoc(r, ts)
+		labels := doc["labels"].(map[string]string)
+		for k, v := range s.Metric {
+			labels[r.labelField(k)] = v
+		}
+		doc["metric"] = map[string]interface{}{"value": json.RawMessage(jsonNumber(s.Value))}
+		if severity := r.sampleSeverity(s); severity != "" {
+			doc["log"] = map[string]interface{}{"level": severity}
+		}
+		line, err := ecsLine(doc)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(line)
+	}
+	return sb.String(), nil
+}
+
+// ecsDoc returns the fields all the documents of the record have.
+func ecsDoc(r Record, ts time.Time) map[string]interface{} {
+	labels := make(map[string]string, len(r.Fields))
+	for k, v := range r.Fields {
+		labels[k] = v
+	}
+	return map[string]interface{}{
+		"@timestamp": ts.UTC().Format(time.RFC3339Nano),
+		"event": map[string]interface{}{
+			"kind":    "metric",
+			"module":  "prom2log",
+			"dataset": r.Name,
+			"created": r.Time.UTC().Format(time.RFC3339Nano),
+		},
+		"labels": labels,
+	}
+}
+
+func ecsLine(doc map[string]interface{}) (string, error) {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("encoding ECS document: %w", err)
+	}
+	return string(b) + "\n", nil
+}
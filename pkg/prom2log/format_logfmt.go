@@ -0,0 +1,65 @@
+package prom2log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// logfmtRecord renders the record as logfmt, one line per sample.
+func logfmtRecord(r Record) string {
+	prefix := "time=" + logfmtValue(r.timeString()) + " name=" + logfmtValue(r.Name)
+	for _, k := range sortedKeys(r.Fields) {
+		prefix += " " + logfmtKey(k) + "=" + logfmtValue(r.Fields[k])
+	}
+	if r.Err != nil {
+		return prefix + " error=" + logfmtValue(r.Err.Error()) + "\n"
+	}
+	if r.Extract != nil {
+		v, err := r.extracted()
+		if err != nil {
+			return prefix + " error=" + logfmtValue(err.Error()) + "\n"
+		}
+		return prefix + " result=" + logfmtValue(string(v)) + "\n"
+	}
+	samples, err := r.Samples()
+	if err != nil {
+		return prefix + " error=" + logfmtValue(fmt.Sprintf("parsing result: %v", err)) + "\n"
+	}
+	if len(samples) == 0 {
+		return prefix + "\n"
+	}
+	var sb strings.Builder
+	for _, s := range samples {
+		sb.WriteString(prefix)
+		for _, k := range sortedKeys(s.Metric) {
+			sb.WriteString(" " + logfmtKey(r.labelField(k)) + "=" + logfmtValue(s.Metric[k]))
+		}
+		if severity := r.sampleSeverity(s); severity != "" {
+			sb.WriteString(" severity=" + severity)
+		}
+		sb.WriteString(" value=" + strconv.FormatFloat(s.Value, 'f', -1, 64))
+		sb.WriteString(" timestamp=" + unixSeconds(s.Timestamp))
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func logfmtKey(k string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+func logfmtValue(v string) string {
+	if v == "" {
+		return `""`
+	}
+	if strings.ContainsAny(v, " =\"\\") || strings.IndexFunc(v, func(r rune) bool { return r < ' ' }) >= 0 {
+		return strconv.Quote(v)
+	}
+	return v
+}
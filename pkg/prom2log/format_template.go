@@ -0,0 +1,73 @@
+package prom2log
+
+import (
+	"encoding/json"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateData is the data available to the output templates, they are executed once per sample.
+type TemplateData struct {
+	// Name is the query name.
+	Name string
+	// Time is when the query was run.
+	Time      time.Time
+	Labels    map[string]string
+	Value     float64
+	Timestamp time.Time
+	// Severity is the severity of the sample if the query has thresholds.
+	Severity string
+	// Fields are the extra fields of the query.
+	Fields map[string]string
+	// Error is set when the query failed, in which case there are no samples.
+	Error string
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// ParseTemplate parses an output template.
+func ParseTemplate(text string) (*template.Template, error) {
+	return template.New("output").Funcs(templateFuncs).Parse(text)
+}
+
+// templateRecord renders the record samples using its template, one line per sample.
+func templateRecord(r Record) (string, error) {
+	var data []TemplateData
+	if r.Err != nil {
+		data = append(data, TemplateData{Name: r.Name, Time: r.Time, Fields: r.Fields, Error: r.Err.Error()})
+	} else {
+		samples, err := r.Samples()
+		if err != nil {
+			return "", err
+		}
+		for _, s := range samples {
+			data = append(data, TemplateData{
+				Name:      r.Name,
+				Time:      r.Time,
+				Labels:    s.Metric,
+				Value:     s.Value,
+				Timestamp: s.Timestamp,
+				Severity:  r.sampleSeverity(s),
+				Fields:    r.Fields,
+			})
+		}
+	}
+
+	var sb strings.Builder
+	for _, d := range data {
+		start := sb.Len()
+		if err := r.Template.Execute(&sb, d); err != nil {
+			return "", err
+		}
+		if sb.Len() > start && !strings.HasSuffix(sb.String(), "\n") {
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String(), nil
+}
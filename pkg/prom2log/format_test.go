@@ -0,0 +1,55 @@
+package prom2log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestFormatJSONEscaping(t *testing.T) {
+	tests := []struct {
+		name   string
+		record Record
+	}{
+		{
+			name:   "name",
+			record: Record{Name: `up "quoted" \ name`, Result: model.Vector{}},
+		},
+		{
+			name:   "sample",
+			record: Record{Name: "up\n", Sample: &Sample{Metric: map[string]string{"job": `a"b`}, Value: 1, Timestamp: time.Unix(1700000000, 0)}},
+		},
+		{
+			name:   "error",
+			record: Record{Name: `up"`, Err: errors.New("bad \x00 \"input\" é")},
+		},
+		{
+			name:   "fields",
+			record: Record{Name: "up", Result: model.Vector{}, Fields: map[string]string{`k"`: "v\t"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Formatter{Encoding: FormatJSON, NoPrettyJSON: true, NoColour: true}
+			tt.record.Time = time.Unix(1700000000, 0)
+			var buf bytes.Buffer
+			if err := f.Format(&buf, tt.record); err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("invalid JSON %s: %v", buf.Bytes(), err)
+			}
+			if got["name"] != tt.record.Name {
+				t.Errorf("got name %q, want %q", got["name"], tt.record.Name)
+			}
+			if tt.record.Err != nil && got["error"] != tt.record.Err.Error() {
+				t.Errorf("got error %q, want %q", got["error"], tt.record.Err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package prom2log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeClient sends requests to the Kubernetes API using the service account of the pod prom2log runs in.
+type kubeClient struct {
+	apiServer string
+	client    *http.Client
+}
+
+// newKubeClient returns a client for the given API server, defaulting to the one of the cluster prom2log runs in.
+func newKubeClient(apiServer string) (*kubeClient, error) {
+	if apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("the Kubernetes API server must be set when not running in a cluster")
+		}
+		apiServer = "https://" + net.JoinHostPort(host, port)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if _, err := os.Stat(serviceAccountDir + "/ca.crt"); err == nil {
+		tlsConfig, err := TLSConfig{CAFile: serviceAccountDir + "/ca.crt"}.Build()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &kubeClient{
+		apiServer: strings.TrimSuffix(apiServer, "/"),
+		client:    &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}, nil
+}
+
+// podNamespace returns the namespace of the pod prom2log runs in.
+func podNamespace() (string, error) {
+	ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return "", fmt.Errorf("the namespace must be set when not running in a cluster: %w", err)
+	}
+	return strings.TrimSpace(string(ns)), nil
+}
+
+// do sends a request with body, if not nil, encoded as JSON and returns the status code and the response body.
+func (k *kubeClient) do(ctx context.Context, method, path string, body interface{}) (int, []byte, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, k.apiServer+path, r)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// the token is read every time, it's rotated by the kubelet
+	if token, err := os.ReadFile(serviceAccountDir + "/token"); err == nil {
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+	response, err := k.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+	b, err := io.ReadAll(response.Body)
+	return response.StatusCode, b, err
+}
+
+// get returns the body of a successful GET request.
+func (k *kubeClient) get(ctx context.Context, path string) ([]byte, error) {
+	status, b, err := k.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, kubeError(status, b)
+	}
+	return b, nil
+}
+
+// kubeError returns the error of an unexpected response, with the message of the API Status if there's one.
+func kubeError(status int, b []byte) error {
+	var s struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(b, &s) == nil && s.Message != "" {
+		return fmt.Errorf("unexpected status %d: %s", status, s.Message)
+	}
+	if len(b) > 256 {
+		b = b[:256]
+	}
+	return fmt.Errorf("unexpected status %d: %s", status, strings.TrimSpace(string(b)))
+}
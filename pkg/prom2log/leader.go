@@ -0,0 +1,232 @@
+package prom2log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LeaseConfig configures the leader election among replicas using a Kubernetes Lease.
+type LeaseConfig struct {
+	// Name of the Lease, it's created if it doesn't exist.
+	Name string
+	// Namespace of the Lease, defaults to the namespace of the pod.
+	Namespace string
+	// Identity of the replica, defaults to the POD_NAME environment variable or the hostname.
+	Identity string
+	// Duration is how long the Lease is valid after being renewed, defaults to 15s.
+	// The leader renews it every fifth of the Duration and steps down if it can't for two thirds of it.
+	Duration time.Duration
+	// APIServer is the URL of the Kubernetes API, defaults to the one of the cluster prom2log runs in.
+	APIServer string
+}
+
+// LeaderElector competes with the other replicas for a Kubernetes Lease, only one of them holds it at a time.
+type LeaderElector struct {
+	cfg    LeaseConfig
+	client *kubeClient
+
+	// observed is the last seen version of the Lease and observedAt when it was seen to change,
+	// using the local clock, so the expiry of other holders doesn't depend on clocks being in sync.
+	observed   string
+	observedAt time.Time
+	renewedAt  time.Time
+}
+
+// lease is the part of the Kubernetes Lease resource used for the election.
+type lease struct {
+	APIVersion        string `json:"apiVersion"`
+	Kind              string `json:"kind"`
+	metav1.ObjectMeta `json:"metadata"`
+	Spec              leaseSpec `json:"spec"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string            `json:"holderIdentity,omitempty"`
+	LeaseDurationSeconds int               `json:"leaseDurationSeconds,omitempty"`
+	AcquireTime          *metav1.MicroTime `json:"acquireTime,omitempty"`
+	RenewTime            *metav1.MicroTime `json:"renewTime,omitempty"`
+	LeaseTransitions     int               `json:"leaseTransitions,omitempty"`
+}
+
+// NewLeaderElector returns an elector for the Lease with the given configuration.
+func NewLeaderElector(cfg LeaseConfig) (*LeaderElector, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("the lease name is required")
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = 15 * time.Second
+	}
+	if cfg.Duration < time.Second {
+		return nil, errors.New("the lease duration must be at least 1s")
+	}
+	if cfg.Identity == "" {
+		cfg.Identity = os.Getenv("POD_NAME")
+	}
+	if cfg.Identity == "" {
+		var err error
+		if cfg.Identity, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("lease identity: %w", err)
+		}
+	}
+	client, err := newKubeClient(cfg.APIServer)
+	if err != nil {
+		return nil, fmt.Errorf("leader election: %w", err)
+	}
+	if cfg.Namespace == "" {
+		if cfg.Namespace, err = podNamespace(); err != nil {
+			return nil, fmt.Errorf("leader election: %w", err)
+		}
+	}
+	return &LeaderElector{cfg: cfg, client: client}, nil
+}
+
+// Run tries to acquire and then keep renewing the Lease until ctx is cancelled, calling onChange
+// with true when the replica becomes the leader and with false when it stops being it.
+// The Lease is released when ctx is cancelled so another replica can take over right away.
+func (e *LeaderElector) Run(ctx context.Context, onChange func(leading bool)) {
+	period := e.cfg.Duration / 5
+	deadline := e.cfg.Duration * 2 / 3
+	leading := false
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, deadline)
+		err := e.tryAcquire(attemptCtx)
+		cancel()
+		switch {
+		case err == nil && !leading:
+			leading = true
+			log().Info("became the leader", "lease", e.cfg.Namespace+"/"+e.cfg.Name, "identity", e.cfg.Identity)
+			onChange(true)
+		case err != nil && leading && (errors.Is(err, errLeaseHeld) || time.Since(e.renewedAt) > deadline):
+			leading = false
+			log().Warn("lost the leadership", "lease", e.cfg.Namespace+"/"+e.cfg.Name, "error", err)
+			onChange(false)
+		case err != nil && !errors.Is(err, errLeaseHeld) && ctx.Err() == nil:
+			log().Warn("leader election failed", "lease", e.cfg.Namespace+"/"+e.cfg.Name, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			if leading {
+				e.release()
+				onChange(false)
+			}
+			return
+		case <-time.After(period):
+		}
+	}
+}
+
+// errLeaseHeld is returned when another replica holds a valid Lease.
+var errLeaseHeld = errors.New("the lease is held by another replica")
+
+func (e *LeaderElector) path() string {
+	return "/apis/coordination.k8s.io/v1/namespaces/" + url.PathEscape(e.cfg.Namespace) + "/leases"
+}
+
+// tryAcquire creates or updates the Lease to be held by this replica, unless another one holds it and didn't let it expire.
+func (e *LeaderElector) tryAcquire(ctx context.Context) error {
+	now := metav1.NewMicroTime(time.Now())
+	status, b, err := e.client.do(ctx, http.MethodGet, e.path()+"/"+url.PathEscape(e.cfg.Name), nil)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusNotFound {
+		l := lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			ObjectMeta: metav1.ObjectMeta{Name: e.cfg.Name, Namespace: e.cfg.Namespace},
+			Spec: leaseSpec{
+				HolderIdentity:       e.cfg.Identity,
+				LeaseDurationSeconds: int(e.cfg.Duration.Seconds()),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		status, b, err = e.client.do(ctx, http.MethodPost, e.path(), l)
+		if err != nil {
+			return err
+		}
+		if status == http.StatusConflict {
+			return errLeaseHeld
+		}
+		if status != http.StatusCreated {
+			return kubeError(status, b)
+		}
+		e.renewedAt = now.Time
+		return nil
+	}
+	if status != http.StatusOK {
+		return kubeError(status, b)
+	}
+
+	var l lease
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	if l.ResourceVersion != e.observed {
+		e.observed, e.observedAt = l.ResourceVersion, now.Time
+	}
+	holder := l.Spec.HolderIdentity
+	duration := time.Duration(l.Spec.LeaseDurationSeconds) * time.Second
+	if holder != "" && holder != e.cfg.Identity && now.Time.Before(e.observedAt.Add(duration)) {
+		return errLeaseHeld
+	}
+
+	if holder != e.cfg.Identity {
+		l.Spec.AcquireTime = &now
+		l.Spec.LeaseTransitions++
+	}
+	l.Spec.HolderIdentity = e.cfg.Identity
+	l.Spec.LeaseDurationSeconds = int(e.cfg.Duration.Seconds())
+	l.Spec.RenewTime = &now
+	// the update fails with a conflict if another replica changed the Lease since it was read
+	status, b, err = e.client.do(ctx, http.MethodPut, e.path()+"/"+url.PathEscape(e.cfg.Name), l)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusConflict {
+		return errLeaseHeld
+	}
+	if status != http.StatusOK {
+		return kubeError(status, b)
+	}
+	e.renewedAt = now.Time
+	return nil
+}
+
+// release gives up the Lease by clearing its holder.
+func (e *LeaderElector) release() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	status, b, err := e.client.do(ctx, http.MethodGet, e.path()+"/"+url.PathEscape(e.cfg.Name), nil)
+	if err == nil && status != http.StatusOK {
+		err = kubeError(status, b)
+	}
+	var l lease
+	if err == nil {
+		err = json.Unmarshal(b, &l)
+	}
+	if err == nil && l.Spec.HolderIdentity != e.cfg.Identity {
+		return
+	}
+	if err == nil {
+		l.Spec.HolderIdentity = ""
+		l.Spec.LeaseDurationSeconds = 1
+		status, b, err = e.client.do(ctx, http.MethodPut, e.path()+"/"+url.PathEscape(e.cfg.Name), l)
+		if err == nil && status != http.StatusOK {
+			err = kubeError(status, b)
+		}
+	}
+	if err != nil {
+		log().Warn("failed to release the lease", "lease", e.cfg.Namespace+"/"+e.cfg.Name, "error", err)
+		return
+	}
+	log().Info("released the lease", "lease", e.cfg.Namespace+"/"+e.cfg.Name)
+}
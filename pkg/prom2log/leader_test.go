@@ -0,0 +1,146 @@
+package prom2log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeLeases answers the Kubernetes API for a single Lease, rejecting the updates of stale versions like the API does.
+type fakeLeases struct {
+	mu      sync.Mutex
+	lease   *lease
+	version int
+}
+
+func (f *fakeLeases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		if f.lease == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(f.lease)
+	case http.MethodPost, http.MethodPut:
+		var l lease
+		if err := json.NewDecoder(r.Body).Decode(&l); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && f.lease != nil:
+			w.WriteHeader(http.StatusConflict)
+			return
+		case r.Method == http.MethodPut && (f.lease == nil || l.ResourceVersion != f.lease.ResourceVersion):
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		f.version++
+		l.ResourceVersion = strconv.Itoa(f.version)
+		f.lease = &l
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_ = json.NewEncoder(w).Encode(f.lease)
+	}
+}
+
+func (f *fakeLeases) holder() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.lease == nil {
+		return ""
+	}
+	return f.lease.Spec.HolderIdentity
+}
+
+func newTestElector(t *testing.T, url, identity string) *LeaderElector {
+	t.Helper()
+	e, err := NewLeaderElector(LeaseConfig{Name: "prom2log", Namespace: "default", Identity: identity, Duration: time.Second, APIServer: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestLeaderElectorAcquire(t *testing.T) {
+	fake := &fakeLeases{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	a, b := newTestElector(t, srv.URL, "a"), newTestElector(t, srv.URL, "b")
+	ctx := context.Background()
+
+	if err := a.tryAcquire(ctx); err != nil {
+		t.Fatalf("creating the lease: %v", err)
+	}
+	if err := a.tryAcquire(ctx); err != nil {
+		t.Fatalf("renewing the lease: %v", err)
+	}
+	if err := b.tryAcquire(ctx); !errors.Is(err, errLeaseHeld) {
+		t.Fatalf("acquiring a held lease = %v, want %v", err, errLeaseHeld)
+	}
+	if got := fake.holder(); got != "a" {
+		t.Fatalf("got holder %q, want a", got)
+	}
+
+	// the lease expires a duration after b last saw it change
+	time.Sleep(1100 * time.Millisecond)
+	if err := b.tryAcquire(ctx); err != nil {
+		t.Fatalf("acquiring an expired lease: %v", err)
+	}
+	if got := fake.holder(); got != "b" {
+		t.Fatalf("got holder %q, want b", got)
+	}
+	if err := a.tryAcquire(ctx); !errors.Is(err, errLeaseHeld) {
+		t.Fatalf("acquiring a lease taken over = %v, want %v", err, errLeaseHeld)
+	}
+
+	// a released lease is free right away
+	b.release()
+	if got := fake.holder(); got != "" {
+		t.Fatalf("got holder %q after the release, want none", got)
+	}
+	if err := a.tryAcquire(ctx); err != nil {
+		t.Fatalf("acquiring a released lease: %v", err)
+	}
+}
+
+func TestLeaderElectorRun(t *testing.T) {
+	fake := &fakeLeases{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	e := newTestElector(t, srv.URL, "a")
+
+	changes := make(chan bool, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Run(ctx, func(leading bool) { changes <- leading })
+	}()
+	select {
+	case leading := <-changes:
+		if !leading {
+			t.Fatal("got a leadership loss before becoming the leader")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the leadership")
+	}
+	cancel()
+	<-done
+	if leading := <-changes; leading {
+		t.Error("got leading after Run returned")
+	}
+	if got := fake.holder(); got != "" {
+		t.Errorf("got holder %q after Run returned, want the lease released", got)
+	}
+}
@@ -0,0 +1,124 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Limits bounds the number of requests sent to Prometheus at once and per second,
+// requests over the limits wait for their turn.
+type Limits struct {
+	// MaxConcurrency is the maximum number of requests in flight, unlimited by default.
+	MaxConcurrency int `json:"max_concurrency,omitempty"`
+	// RateLimit is the maximum number of requests per second, unlimited by default.
+	RateLimit float64 `json:"rate_limit,omitempty"`
+	// Burst is the number of requests that can be sent at once, above the RateLimit, defaults to 1.
+	Burst int `json:"burst,omitempty"`
+}
+
+// Validate checks the limits.
+func (l Limits) Validate() error {
+	if l.MaxConcurrency < 0 || l.RateLimit < 0 || l.Burst < 0 {
+		return errors.New("max_concurrency, rate_limit and burst can't be negative")
+	}
+	if l.Burst > 0 && l.RateLimit == 0 {
+		return errors.New("burst requires rate_limit")
+	}
+	return nil
+}
+
+// limiter enforces Limits, a nil limiter doesn't limit anything.
+type limiter struct {
+	slots chan struct{}
+	// interval is the time between requests at the rate limit, tolerance how far ahead of it a burst can go.
+	interval  time.Duration
+	tolerance time.Duration
+
+	mu sync.Mutex
+	// next is when the next request would be sent if they were evenly spaced at the rate limit.
+	next time.Time
+}
+
+func newLimiter(l Limits) *limiter {
+	if l.MaxConcurrency == 0 && l.RateLimit == 0 {
+		return nil
+	}
+	lim := &limiter{}
+	if l.MaxConcurrency > 0 {
+		lim.slots = make(chan struct{}, l.MaxConcurrency)
+	}
+	if l.RateLimit > 0 {
+		burst := l.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		lim.interval = time.Duration(float64(time.Second) / l.RateLimit)
+		lim.tolerance = time.Duration(burst-1) * lim.interval
+	}
+	return lim
+}
+
+// wait blocks until a request can be sent, returning the function to call once it's done.
+func (l *limiter) wait(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	if l.interval > 0 {
+		l.mu.Lock()
+		now := time.Now()
+		if l.next.Before(now) {
+			l.next = now
+		}
+		delay := l.next.Sub(now) - l.tolerance
+		l.next = l.next.Add(l.interval)
+		l.mu.Unlock()
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+	if l.slots == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return func() { <-l.slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type limiterKey struct{}
+
+// withLimiter returns a context whose requests are subject to the limiter, on top of the limits of their servers.
+func withLimiter(ctx context.Context, l *limiter) context.Context {
+	if l == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, limiterKey{}, l)
+}
+
+// waitLimits waits for the limiter of the context, if any, and then for the server's one.
+func waitLimits(ctx context.Context, server *limiter) (func(), error) {
+	global, _ := ctx.Value(limiterKey{}).(*limiter)
+	releaseGlobal, err := global.wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	releaseServer, err := server.wait(ctx)
+	if err != nil {
+		releaseGlobal()
+		return nil, err
+	}
+	return func() {
+		releaseServer()
+		releaseGlobal()
+	}, nil
+}
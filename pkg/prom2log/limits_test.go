@@ -0,0 +1,111 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimitsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "all", limits: Limits{MaxConcurrency: 2, RateLimit: 10, Burst: 5}},
+		{name: "negative", limits: Limits{MaxConcurrency: -1}, wantErr: true},
+		{name: "burst without rate", limits: Limits{Burst: 2}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.limits.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		// requests are sent at once, the first ones within the burst or concurrency are sent right away
+		requests int
+		// immediate is the number of requests expected to be sent without waiting
+		immediate int
+	}{
+		{name: "unlimited", requests: 5, immediate: 5},
+		{name: "concurrency", limits: Limits{MaxConcurrency: 2}, requests: 4, immediate: 2},
+		{name: "rate", limits: Limits{RateLimit: 20}, requests: 3, immediate: 1},
+		{name: "burst", limits: Limits{RateLimit: 20, Burst: 3}, requests: 5, immediate: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newLimiter(tt.limits)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			sent := 0
+			for i := 0; i < tt.requests; i++ {
+				if _, err := l.wait(ctx); err != nil {
+					if !errors.Is(err, context.DeadlineExceeded) {
+						t.Fatalf("wait() = %v", err)
+					}
+					break
+				}
+				sent++
+			}
+			if sent != tt.immediate {
+				t.Errorf("sent %d requests right away, want %d", sent, tt.immediate)
+			}
+		})
+	}
+}
+
+func TestLimiterRelease(t *testing.T) {
+	l := newLimiter(Limits{MaxConcurrency: 1})
+	release, err := l.wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := l.wait(ctx); err != nil {
+		t.Fatalf("wait() after release = %v", err)
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	l := newLimiter(Limits{RateLimit: 50})
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := l.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the first request is sent right away, the next ones 20ms apart
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("3 requests at 50/s took %s", elapsed)
+	}
+}
+
+func TestWaitLimits(t *testing.T) {
+	global := newLimiter(Limits{MaxConcurrency: 1})
+	ctx := withLimiter(context.Background(), global)
+	release, err := waitLimits(ctx, newLimiter(Limits{MaxConcurrency: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the global limit applies on top of the server one
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := waitLimits(short, nil); err == nil {
+		t.Fatal("the global limit wasn't enforced")
+	}
+	release()
+	if _, err := waitLimits(ctx, nil); err != nil {
+		t.Fatalf("waitLimits() after release = %v", err)
+	}
+}
@@ -0,0 +1,22 @@
+package prom2log
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/exp/slog"
+)
+
+var logger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger used for the diagnostics of the package, like scheduling, retries and sink errors.
+// It defaults to slog.Default().
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+func log() *slog.Logger {
+	if l := logger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
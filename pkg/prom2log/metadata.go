@@ -0,0 +1,101 @@
+package prom2log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	labelsPath   = "/api/v1/labels"
+	seriesPath   = "/api/v1/series"
+	metadataPath = "/api/v1/metadata"
+)
+
+// SeriesSelector limits the labels and series APIs to the series matching any of the Match selectors,
+// e.g. up{job="node"}, and to the time range from Start to End, the ones not set aren't limited.
+type SeriesSelector struct {
+	Match []string
+	Start time.Time
+	End   time.Time
+}
+
+func (s SeriesSelector) params() url.Values {
+	params := url.Values{}
+	for _, m := range s.Match {
+		params.Add("match[]", m)
+	}
+	if !s.Start.IsZero() {
+		params.Set("start", formatTime(s.Start))
+	}
+	if !s.End.IsZero() {
+		params.Set("end", formatTime(s.End))
+	}
+	return params
+}
+
+// MetricMetadata is the type, help and unit of a metric, as exposed by its targets.
+type MetricMetadata struct {
+	Type string `json:"type"`
+	Help string `json:"help"`
+	Unit string `json:"unit"`
+}
+
+// Labels returns the names of the labels of the selected series, using the query's servers and credentials.
+func (q *Query) Labels(ctx context.Context, sel SeriesSelector) ([]string, error) {
+	var labels []string
+	err := q.getData(ctx, labelsPath, sel.params(), &labels)
+	return labels, err
+}
+
+// LabelValues returns the values of the label in the selected series.
+func (q *Query) LabelValues(ctx context.Context, label string, sel SeriesSelector) ([]string, error) {
+	var values []string
+	err := q.getData(ctx, "/api/v1/label/"+url.PathEscape(label)+"/values", sel.params(), &values)
+	return values, err
+}
+
+// Series returns the label sets of the selected series, at least one Match selector is required.
+func (q *Query) Series(ctx context.Context, sel SeriesSelector) ([]map[string]string, error) {
+	if len(sel.Match) == 0 {
+		return nil, errors.New("at least one series selector is required")
+	}
+	var series []map[string]string
+	err := q.getData(ctx, seriesPath, sel.params(), &series)
+	return series, err
+}
+
+// Metadata returns the metadata of the metrics, by metric name, only the given one if not empty,
+// returning up to limit metrics if it's positive.
+func (q *Query) Metadata(ctx context.Context, metric string, limit int) (map[string][]MetricMetadata, error) {
+	params := url.Values{}
+	if metric != "" {
+		params.Set("metric", metric)
+	}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	var metadata map[string][]MetricMetadata
+	err := q.getData(ctx, metadataPath, params, &metadata)
+	return metadata, err
+}
+
+// getData sends a request to the API and decodes the data of the response into v.
+func (q *Query) getData(ctx context.Context, path string, params url.Values, v interface{}) error {
+	b, err := q.get(ctx, path, params)
+	if err != nil {
+		return err
+	}
+	resp, err := parseAPIResponse(b)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(resp.Data, v); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package prom2log
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queryRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "prom2log",
+		Name:      "query_runs_total",
+		Help:      "Number of times each query ran.",
+	}, []string{"query"})
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "prom2log",
+		Name:      "query_errors_total",
+		Help:      "Number of failed query runs.",
+	}, []string{"query"})
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "prom2log",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of the query runs, including retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 4, 8),
+	}, []string{"query"})
+	querySamples = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "prom2log",
+		Name:      "query_result_samples",
+		Help:      "Number of samples returned by the query runs.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	}, []string{"query"})
+	sinkErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "prom2log",
+		Name:      "sink_write_errors_total",
+		Help:      "Number of records of each query that failed to be written to the sinks.",
+	}, []string{"query"})
+	standbyGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "prom2log",
+		Name:      "standby",
+		Help:      "Whether the scheduled runs are skipped because another replica is the leader.",
+	})
+)
+
+// RegisterMetrics registers the metrics of the scheduled queries with r.
+func RegisterMetrics(r prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{queryRuns, queryErrors, queryDuration, querySamples, sinkErrors, standbyGauge} {
+		if err := r.Register(c); err != nil {
+			var are prometheus.AlreadyRegisteredError
+			if !errors.As(err, &are) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func observeRun(name string, duration time.Duration, samples int, err error) {
+	queryRuns.WithLabelValues(name).Inc()
+	queryDuration.WithLabelValues(name).Observe(duration.Seconds())
+	if err != nil {
+		queryErrors.WithLabelValues(name).Inc()
+		return
+	}
+	querySamples.WithLabelValues(name).Observe(float64(samples))
+}
+
+// deleteMetrics removes the metrics of a query that is no longer scheduled.
+func deleteMetrics(name string) {
+	for _, c := range []*prometheus.MetricVec{queryRuns.MetricVec, queryErrors.MetricVec, queryDuration.MetricVec, querySamples.MetricVec, sinkErrors.MetricVec} {
+		c.DeleteLabelValues(name)
+	}
+}
@@ -0,0 +1,53 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// OAuth2Config configures the OAuth2 client credentials flow, tokens are fetched and refreshed automatically.
+type OAuth2Config struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	// ClientSecretFile is read once, when the server is created.
+	ClientSecretFile string            `json:"client_secret_file,omitempty"`
+	TokenURL         string            `json:"token_url"`
+	Scopes           []string          `json:"scopes,omitempty"`
+	EndpointParams   map[string]string `json:"endpoint_params,omitempty"`
+}
+
+// transport wraps base to authenticate the requests with OAuth2 tokens, which are requested using base as well.
+func (c OAuth2Config) transport(base http.RoundTripper) (http.RoundTripper, error) {
+	if c.ClientID == "" || c.TokenURL == "" {
+		return nil, errors.New("oauth2 requires client_id and token_url")
+	}
+	if c.ClientSecret != "" && c.ClientSecretFile != "" {
+		return nil, errors.New("client_secret and client_secret_file are mutually exclusive")
+	}
+	secret := c.ClientSecret
+	if c.ClientSecretFile != "" {
+		var err error
+		if secret, err = readSecret(c.ClientSecretFile); err != nil {
+			return nil, err
+		}
+	}
+	cfg := clientcredentials.Config{
+		ClientID:       c.ClientID,
+		ClientSecret:   secret,
+		TokenURL:       c.TokenURL,
+		Scopes:         c.Scopes,
+		EndpointParams: map[string][]string{},
+	}
+	for k, v := range c.EndpointParams {
+		cfg.EndpointParams.Set(k, v)
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: base})
+	return &oauth2.Transport{
+		Source: cfg.TokenSource(ctx),
+		Base:   base,
+	}, nil
+}
@@ -0,0 +1,265 @@
+package prom2log
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// The queries are run by a pool of workers: a single goroutine waits for the next query due to run and hands it over
+// to one of the Limits.MaxConcurrency workers, or to a goroutine of its own when it's not set, which is bounded by the
+// number of queries since a job isn't queued again before its run is over. Queries due while all the workers are busy
+// wait for one, in the order they were due.
+
+// jobState is where a job is in its cycle, it's guarded by the scheduler's mutex.
+type jobState int
+
+const (
+	// jobWaiting jobs wait in the queue for their next run.
+	jobWaiting jobState = iota
+	// jobReady jobs are due and wait for a worker.
+	jobReady
+	jobRunning
+	jobStopped
+)
+
+// runKind is why a job runs.
+type runKind int
+
+const (
+	runScheduled runKind = iota
+	runTriggered
+	// runCatchUp handles the runs missed before a restart, see Scheduler.catchUp.
+	runCatchUp
+)
+
+// jobQueue is a heap of the waiting jobs, ordered by when they're due.
+type jobQueue []*job
+
+func (q jobQueue) Len() int           { return len(q) }
+func (q jobQueue) Less(i, k int) bool { return q[i].due().Before(q[k].due()) }
+
+func (q jobQueue) Swap(i, k int) {
+	q[i], q[k] = q[k], q[i]
+	q[i].index, q[k].index = i, k
+}
+
+func (q *jobQueue) Push(x interface{}) {
+	j := x.(*job)
+	j.index = len(*q)
+	*q = append(*q, j)
+}
+
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	j := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	j.index = -1
+	return j
+}
+
+// due returns when the job is due to run, triggered jobs are due right away.
+func (j *job) due() time.Time {
+	if j.triggered {
+		return time.Time{}
+	}
+	return j.next
+}
+
+// advance moves the job to the activation following the one that ran or was skipped.
+func (j *job) advance(now time.Time) {
+	j.next = j.sched.Next(j.next)
+	if j.next.Before(now) {
+		// the previous run took longer than the interval, skip the missed activations
+		log().Warn("query run took longer than its schedule, skipping missed runs", "query", j.name)
+		j.next = j.sched.Next(now)
+	}
+	log().Debug("next run", "query", j.name, "at", j.next)
+}
+
+// startWorkers starts the dispatcher and the workers, s.mu must be held.
+func (s *Scheduler) startWorkers() {
+	s.queue = nil
+	s.ready = nil
+	s.closing = false
+	s.wake = make(chan struct{}, 1)
+	s.readyCond = sync.NewCond(&s.mu)
+	s.wg.Add(1 + s.Limits.MaxConcurrency)
+	go s.dispatch()
+	for i := 0; i < s.Limits.MaxConcurrency; i++ {
+		go s.work()
+	}
+}
+
+// stopWorkers stops dispatching the jobs, the workers return once their current run is over, s.mu must be held.
+func (s *Scheduler) stopWorkers() {
+	s.closing = true
+	s.ready = nil
+	s.readyCond.Broadcast()
+}
+
+// requeue queues the job for its next run, s.mu must be held.
+func (s *Scheduler) requeue(j *job) {
+	j.state = jobWaiting
+	heap.Push(&s.queue, j)
+	s.wakeUp()
+}
+
+// wakeUp makes the dispatcher check the queue again.
+func (s *Scheduler) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// idleWait is how long the dispatcher sleeps when no job is queued, it's woken up when one is.
+const idleWait = time.Hour
+
+// dispatch hands the jobs over to the workers as they're due, until the scheduler's context is cancelled.
+func (s *Scheduler) dispatch() {
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		wait := s.dispatchDue(time.Now())
+		s.mu.Unlock()
+		timer := time.NewTimer(wait)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// dispatchDue hands the jobs due at now over to the workers and returns how long until the next one is due,
+// s.mu must be held.
+func (s *Scheduler) dispatchDue(now time.Time) time.Duration {
+	for len(s.queue) > 0 && !s.closing {
+		j := s.queue[0]
+		if due := j.due(); due.After(now) {
+			return due.Sub(now)
+		}
+		heap.Pop(&s.queue)
+		switch {
+		case j.triggered:
+			j.triggered = false
+			log().Debug("query triggered", "query", j.name)
+			s.enqueue(j, runTriggered)
+		case s.standby.Load():
+			log().Debug("standing by, skipping run", "query", j.name)
+			j.advance(now)
+			s.requeue(j)
+		case j.isPaused():
+			log().Debug("query paused, skipping run", "query", j.name)
+			j.advance(now)
+			s.requeue(j)
+		default:
+			s.enqueue(j, runScheduled)
+		}
+	}
+	return idleWait
+}
+
+// enqueue hands the job over to the workers, or to a goroutine of its own without a pool, s.mu must be held.
+func (s *Scheduler) enqueue(j *job, kind runKind) {
+	j.state, j.kind = jobReady, kind
+	if s.closing {
+		return
+	}
+	if s.Limits.MaxConcurrency > 0 {
+		s.ready = append(s.ready, j)
+		s.readyCond.Signal()
+		return
+	}
+	j.state, j.idle = jobRunning, make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(j)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.finish(j)
+	}()
+}
+
+// work runs the jobs handed over to the workers until they're stopped.
+func (s *Scheduler) work() {
+	defer s.wg.Done()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		for len(s.ready) == 0 && !s.closing {
+			s.readyCond.Wait()
+		}
+		if s.closing {
+			return
+		}
+		j := s.ready[0]
+		s.ready[0] = nil
+		s.ready = s.ready[1:]
+		j.state, j.idle = jobRunning, make(chan struct{})
+		s.mu.Unlock()
+		s.run(j)
+		s.mu.Lock()
+		s.finish(j)
+	}
+}
+
+// run runs the job, s.mu must not be held.
+func (s *Scheduler) run(j *job) {
+	defer close(j.idle)
+	if j.kind == runCatchUp {
+		j.caughtUp = s.catchUp(j.ctx, j, j.sched, j.last)
+		return
+	}
+	s.log(j.ctx, j)
+}
+
+// finish queues the job for its next run once it ran, unless it was stopped meanwhile, s.mu must be held.
+func (s *Scheduler) finish(j *job) {
+	if j.state == jobStopped {
+		return
+	}
+	switch j.kind {
+	case runCatchUp:
+		j.next = j.caughtUp
+		log().Debug("query scheduled", "query", j.name, "next", j.next)
+	case runScheduled:
+		j.advance(time.Now())
+	}
+	s.requeue(j)
+}
+
+// stop cancels the job, returning a channel closed once its run returns if it's running, s.mu must be held.
+// The run needs s.mu to finish, so the channel must be waited for without holding it.
+func (s *Scheduler) stop(j *job) <-chan struct{} {
+	j.cancel()
+	var idle <-chan struct{}
+	switch j.state {
+	case jobWaiting:
+		heap.Remove(&s.queue, j.index)
+	case jobReady:
+		for i, r := range s.ready {
+			if r == j {
+				s.ready = append(s.ready[:i], s.ready[i+1:]...)
+				break
+			}
+		}
+	case jobRunning:
+		idle = j.idle
+	}
+	j.state = jobStopped
+	return idle
+}
+
+// waitIdle waits for the runs of the stopped jobs to return, s.mu must not be held.
+func waitIdle(stopped []<-chan struct{}) {
+	for _, idle := range stopped {
+		<-idle
+	}
+}
@@ -0,0 +1,213 @@
+package prom2log
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// blockingSink records the names of the queries it got records from, blocking the writes until it's released.
+type blockingSink struct {
+	mu       sync.Mutex
+	names    []string
+	inFlight int
+	maxIn    int
+	release  chan struct{}
+	once     sync.Once
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{release: make(chan struct{})}
+}
+
+func (s *blockingSink) Write(_ context.Context, r Record) error {
+	s.mu.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxIn {
+		s.maxIn = s.inFlight
+	}
+	s.mu.Unlock()
+	// the context isn't checked, like a sink stuck on a slow backend
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.inFlight--
+	s.names = append(s.names, r.Name)
+	return nil
+}
+
+// unblock releases the writes.
+func (s *blockingSink) unblock() {
+	s.once.Do(func() { close(s.release) })
+}
+
+func (s *blockingSink) Close() error {
+	return nil
+}
+
+func (s *blockingSink) stats() (inFlight, maxIn, written int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inFlight, s.maxIn, len(s.names)
+}
+
+// waitFor polls cond until it's true, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// runScheduler runs a scheduler for the given queries, on a server answering every query with a sample, until the
+// test ends.
+func runScheduler(t *testing.T, queries map[string]Query, sink Sink, limits Limits) *Scheduler {
+	t.Helper()
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {"job": "prometheus"}, "value": [1700000000, "1"]}]}}`)
+	}))
+	t.Cleanup(prom.Close)
+	srv, err := NewServer(ServerConfig{URL: prom.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Scheduler{
+		Queries: queries,
+		Servers: map[string]*Server{"main": srv},
+		Sinks:   map[string]Sink{"test": sink},
+		Output:  []string{"test"},
+		Limits:  limits,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := s.Run(ctx); err != nil {
+			t.Error(err)
+		}
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	waitFor(t, "the scheduler to start", s.Running)
+	return s
+}
+
+func testQueries(n int) map[string]Query {
+	queries := make(map[string]Query, n)
+	for i := 0; i < n; i++ {
+		queries[fmt.Sprintf("q%d", i)] = Query{Server: "main", PromQL: "up", Interval: metav1.Duration{Duration: time.Hour}}
+	}
+	return queries
+}
+
+func TestPoolConcurrency(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		queries int
+		want    int
+	}{
+		{name: "pool", limits: Limits{MaxConcurrency: 2}, queries: 5, want: 2},
+		{name: "goroutine per run", queries: 5, want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := newBlockingSink()
+			runScheduler(t, testQueries(tt.queries), sink, tt.limits)
+			t.Cleanup(sink.unblock)
+			waitFor(t, "the runs to start", func() bool {
+				inFlight, _, _ := sink.stats()
+				return inFlight == tt.want
+			})
+			// the other runs wait for a worker
+			time.Sleep(50 * time.Millisecond)
+			if _, maxIn, _ := sink.stats(); maxIn != tt.want {
+				t.Errorf("got %d runs at once, want %d", maxIn, tt.want)
+			}
+			sink.unblock()
+			waitFor(t, "the runs to finish", func() bool {
+				_, _, written := sink.stats()
+				return written == tt.queries
+			})
+			if _, maxIn, _ := sink.stats(); maxIn != tt.want {
+				t.Errorf("got %d runs at once, want %d", maxIn, tt.want)
+			}
+		})
+	}
+}
+
+// TestPoolStopRunning replaces a query while it runs: the scheduler must stay usable while waiting for the run
+// to return.
+func TestPoolStopRunning(t *testing.T) {
+	sink := newBlockingSink()
+	s := runScheduler(t, testQueries(1), sink, Limits{MaxConcurrency: 1})
+	t.Cleanup(sink.unblock)
+	waitFor(t, "the run to start", func() bool {
+		inFlight, _, _ := sink.stats()
+		return inFlight == 1
+	})
+
+	replaced := make(chan error, 1)
+	go func() {
+		replaced <- s.SetQuery("q0", Query{Server: "main", PromQL: "up == 1", Interval: metav1.Duration{Duration: time.Hour}})
+	}()
+	// SetQuery waits for the run to return, without holding the scheduler's lock
+	time.Sleep(50 * time.Millisecond)
+	status := make(chan []QueryStatus, 1)
+	go func() { status <- s.Status() }()
+	select {
+	case <-status:
+	case <-time.After(time.Second):
+		t.Fatal("Status() blocked while waiting for the stopped run")
+	}
+	select {
+	case err := <-replaced:
+		t.Fatalf("SetQuery() = %v before the run returned", err)
+	default:
+	}
+
+	sink.unblock()
+	select {
+	case err := <-replaced:
+		if err != nil {
+			t.Fatalf("SetQuery() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for SetQuery")
+	}
+	waitFor(t, "the replaced query to run", func() bool {
+		_, _, written := sink.stats()
+		return written == 2
+	})
+}
+
+func TestPoolRemoveQuery(t *testing.T) {
+	sink := newBlockingSink()
+	sink.unblock()
+	s := runScheduler(t, testQueries(2), sink, Limits{MaxConcurrency: 1})
+	waitFor(t, "the queries to run", func() bool {
+		_, _, written := sink.stats()
+		return written == 2
+	})
+	if err := s.RemoveQuery("q0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveQuery("q0"); err != ErrUnknownQuery {
+		t.Errorf("RemoveQuery() of a removed query = %v, want %v", err, ErrUnknownQuery)
+	}
+	if status := s.Status(); len(status) != 1 || status[0].Name != "q1" {
+		t.Errorf("got status %+v, want only q1", status)
+	}
+}
@@ -0,0 +1,446 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/prometheus/model/relabel"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	queryPath      = "/api/v1/query"
+	queryRangePath = "/api/v1/query_range"
+)
+
+// Query is a PromQL expression to be evaluated against a Prometheus server.
+type Query struct {
+	// Server is the URL of the Prometheus server or the name of one of the configured servers.
+	Server string `json:"server"`
+	// Servers lists several servers, URLs or names, used instead of Server according to the Strategy,
+	// e.g. the replicas of an HA pair or the Prometheus of each cluster.
+	Servers []string `json:"servers,omitempty"`
+	// Strategy is how the Servers are used, see Strategies, defaults to failover.
+	Strategy string `json:"strategy,omitempty"`
+	// Type is what the query polls, see Types, defaults to evaluating its PromQL.
+	Type string `json:"type,omitempty"`
+	// PromQL is the expression to evaluate, it can use variables with {{ var "name" }}, see BindVariables.
+	PromQL   string          `json:"promQL"`
+	Interval metav1.Duration `json:"interval"`
+	// AuthConfig overrides the authentication settings of the server.
+	AuthConfig
+	// Headers are added to the requests, overriding the server's headers with the same name.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Method overrides the HTTP method of the server's requests, GET or POST.
+	Method string `json:"method,omitempty"`
+	// Schedule is a cron expression, with an optional seconds field, used instead of Interval
+	// to run the query at specific times, e.g. "0 * * * *" for the top of every hour.
+	Schedule string `json:"schedule,omitempty"`
+	// Timezone of the Schedule, defaults to the local timezone.
+	Timezone string `json:"timezone,omitempty"`
+	// Jitter delays every run by a random offset, up to the given duration, chosen once per query.
+	// It spreads queries sharing the same interval or schedule so they don't all hit the server at once.
+	Jitter metav1.Duration `json:"jitter,omitempty"`
+	// Align runs the query at multiples of the interval, e.g. at the start of every minute, instead of counting from startup.
+	Align bool `json:"align,omitempty"`
+	// Start and End turn the query into a range query, they can be absolute, RFC3339 or Unix timestamps,
+	// or relative to the time the query runs, e.g. now-1h, End defaults to now.
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+	// Time is the evaluation time of instant queries, absolute or relative like Start, defaults to when the query runs.
+	Time string `json:"time,omitempty"`
+	// Step is the resolution of range queries, defaults to the interval.
+	Step metav1.Duration `json:"step,omitempty"`
+	// Timeout limits how long each request can take, it's also sent to Prometheus to cancel the evaluation.
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// Retry enables retrying requests that fail with connection errors or 429 and 5xx responses without an API error,
+	// each attempt is subject to the Timeout.
+	Retry *RetryConfig `json:"retry,omitempty"`
+	// Sinks lists the names of the sinks the results are sent to, overriding the global output.
+	Sinks []string `json:"sinks,omitempty"`
+	// RelabelConfigs are applied to the series of the result, to rename, drop or filter them by their labels.
+	RelabelConfigs []RelabelConfig `json:"relabel_configs,omitempty"`
+	// Flatten emits one record per sample instead of one record with the whole result.
+	Flatten bool `json:"flatten,omitempty"`
+	// Stream decodes the response while it's received, emitting the records of each series as soon as it's decoded
+	// instead of holding the whole result in memory, for queries returning many series. It requires Flatten
+	// and can't be used with OnChange, fanout queries stream the result of one server after the other.
+	Stream bool `json:"stream,omitempty"`
+	// SkipEmpty doesn't emit results without samples, flattened results never have records for them.
+	SkipEmpty bool `json:"skip_empty,omitempty"`
+	// Format is the output format of the records, see Formats.
+	Format string `json:"format,omitempty"`
+	// Template is a Go text/template used to render each sample, overriding Format, see TemplateData.
+	Template string `json:"template,omitempty"`
+	// Extract is a jq-like path selecting the part of the result, or of each sample of flattened results, emitted
+	// in the records, e.g. .metric.instance, see ParseExtract. It can only be used with the json and logfmt formats.
+	Extract string `json:"extract,omitempty"`
+	// ExtraFields are added to every record of the query, e.g. the environment or the team owning it.
+	ExtraFields map[string]string `json:"extra_fields,omitempty"`
+	// BuiltinFields lists the fields computed by prom2log added to every record of the query, see Builtins.
+	BuiltinFields []string `json:"builtin_fields,omitempty"`
+	// Timestamp configures the time field of the records.
+	Timestamp *TimestampConfig `json:"timestamp,omitempty"`
+	// Thresholds add a severity field to the records based on the sample values.
+	Thresholds
+	// OnChange only emits the result when it differs from the previous one, ignoring the sample timestamps.
+	OnChange bool `json:"on_change,omitempty"`
+	// Heartbeat emits an unchanged result anyway once this long has passed since the last emitted one, requires OnChange.
+	Heartbeat metav1.Duration `json:"heartbeat,omitempty"`
+
+	tmpl    *template.Template
+	extract *Extractor
+	relabel []*relabel.Config
+	server  *Server
+	servers []*Server
+	vars    *variables
+}
+
+// IsRange reports whether the query is a range query.
+func (q *Query) IsRange() bool {
+	return q.Start != ""
+}
+
+func (q *Query) step() time.Duration {
+	if q.Step.Duration > 0 {
+		return q.Step.Duration
+	}
+	if q.Interval.Duration > 0 {
+		return q.Interval.Duration
+	}
+	return time.Minute
+}
+
+// params returns the API path and parameters of the query evaluated at the given time.
+func (q *Query) params(now time.Time) (string, url.Values, error) {
+	switch q.Type {
+	case TypeAlerts:
+		return alertsPath, url.Values{}, nil
+	case TypeRules:
+		return rulesPath, url.Values{}, nil
+	}
+	params := url.Values{"query": []string{q.PromQL}}
+	if q.Type == TypeExemplars {
+		start, end, err := q.timeRange(now)
+		if err != nil {
+			return "", nil, err
+		}
+		params.Set("start", formatTime(start))
+		params.Set("end", formatTime(end))
+		return exemplarsPath, params, nil
+	}
+	if !q.IsRange() {
+		if q.Time != "" {
+			t, err := ParseTime(q.Time, now)
+			if err != nil {
+				return "", nil, fmt.Errorf("time: %w", err)
+			}
+			params.Set("time", formatTime(t))
+		}
+		return queryPath, params, nil
+	}
+	start, end, err := q.timeRange(now)
+	if err != nil {
+		return "", nil, err
+	}
+	params.Set("start", formatTime(start))
+	params.Set("end", formatTime(end))
+	params.Set("step", strconv.FormatFloat(q.step().Seconds(), 'f', -1, 64))
+	return queryRangePath, params, nil
+}
+
+// timeRange returns the Start and End of the query, relative to now.
+func (q *Query) timeRange(now time.Time) (time.Time, time.Time, error) {
+	start, err := ParseTime(q.Start, now)
+	if err != nil {
+		return start, start, fmt.Errorf("start: %w", err)
+	}
+	end, err := ParseTime(q.End, now)
+	if err != nil {
+		return start, end, fmt.Errorf("end: %w", err)
+	}
+	return start, end, nil
+}
+
+// Get runs the query and returns the raw response body.
+func (q *Query) Get(ctx context.Context) ([]byte, error) {
+	path, params, err := q.params(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if q.Type == TypeAlerts || q.Type == TypeRules {
+		return q.get(ctx, path, params)
+	}
+	expr, err := q.expr(ctx)
+	if err != nil {
+		return nil, err
+	}
+	params.Set("query", expr)
+	return q.get(ctx, path, params)
+}
+
+func (q *Query) get(ctx context.Context, path string, params url.Values) ([]byte, error) {
+	var b []byte
+	err := q.fetch(ctx, path, params, func(r io.Reader) error {
+		var err error
+		b, err = io.ReadAll(r)
+		return err
+	})
+	return b, err
+}
+
+// fetch sends the request to the query's servers, retrying and failing over to the next one,
+// until read succeeds with the body of a response.
+func (q *Query) fetch(ctx context.Context, path string, params url.Values, read func(io.Reader) error) error {
+	if q.Timeout.Duration > 0 {
+		params.Set("timeout", strconv.FormatFloat(q.Timeout.Seconds(), 'f', -1, 64))
+	}
+	retry := RetryConfig{MaxRetries: -1}
+	if q.Retry != nil {
+		retry = *q.Retry
+	}
+	servers, err := q.backends()
+	if err != nil {
+		return err
+	}
+	var partial *partialError
+	for i, s := range servers {
+		err = retry.Do(ctx, func() error {
+			return s.stream(ctx, q, path, params, read)
+		})
+		if err == nil || ctx.Err() != nil || i == len(servers)-1 || errors.As(err, &partial) {
+			break
+		}
+		log().Warn("request failed, trying the next server", "server", q.Servers[i], "next", q.Servers[i+1], "error", err)
+	}
+	return err
+}
+
+// Run runs the query and returns the result as a Record.
+func (q *Query) Run(ctx context.Context, name string) Record {
+	r := Record{
+		Name: name,
+	}
+	b, err := q.Get(ctx)
+	r.Time = time.Now()
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.Result, r.Warnings, r.Err = q.parseResponse(b)
+	if r.Err == nil {
+		r.Result, r.Err = q.relabelResult(r.Result)
+	}
+	return r
+}
+
+// Validate checks the query configuration.
+func (q *Query) Validate() error {
+	if q.Format != "" && !contains(Formats, q.Format) {
+		return fmt.Errorf("unknown format %q", q.Format)
+	}
+	if _, err := q.template(); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	if _, err := q.extractor(); err != nil {
+		return fmt.Errorf("invalid extract: %w", err)
+	}
+	if q.Extract != "" && q.Template != "" {
+		return errors.New("extract can't be used with a template")
+	}
+	if q.Extract != "" && q.Format != "" && q.Format != FormatJSON && q.Format != FormatLogfmt {
+		return fmt.Errorf("extract can't be used with the %s format", q.Format)
+	}
+	if err := q.validateType(); err != nil {
+		return err
+	}
+	if err := q.validateServers(); err != nil {
+		return err
+	}
+	if err := q.AuthConfig.validate(); err != nil {
+		return err
+	}
+	if err := validateMethod(q.Method); err != nil {
+		return err
+	}
+	if err := q.Thresholds.validate(); err != nil {
+		return err
+	}
+	if err := q.validateFields(); err != nil {
+		return err
+	}
+	if _, err := q.ExpandPromQL(func(string) (string, error) { return "0", nil }); err != nil {
+		return fmt.Errorf("invalid PromQL: %w", err)
+	}
+	if err := q.Timestamp.validate(); err != nil {
+		return fmt.Errorf("invalid timestamp timezone: %w", err)
+	}
+	if _, err := q.relabelConfigs(); err != nil {
+		return err
+	}
+	if q.Timeout.Duration < 0 {
+		return errors.New("timeout can't be negative")
+	}
+	if q.Heartbeat.Duration < 0 {
+		return errors.New("heartbeat can't be negative")
+	}
+	if q.Heartbeat.Duration > 0 && !q.OnChange {
+		return errors.New("heartbeat requires on_change")
+	}
+	if q.Stream && !q.Flatten {
+		return errors.New("stream requires flatten")
+	}
+	if q.Stream && q.OnChange {
+		return errors.New("stream can't be used with on_change")
+	}
+	if q.End != "" && !q.IsRange() {
+		return errors.New("end requires start")
+	}
+	if q.Time != "" && q.IsRange() {
+		return errors.New("time can't be used with start")
+	}
+	if q.IsRange() || q.Time != "" {
+		if _, _, err := q.params(time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *Query) template() (*template.Template, error) {
+	if q.Template == "" || q.tmpl != nil {
+		return q.tmpl, nil
+	}
+	t, err := ParseTemplate(q.Template)
+	if err != nil {
+		return nil, err
+	}
+	q.tmpl = t
+	return t, nil
+}
+
+func (q *Query) extractor() (*Extractor, error) {
+	if q.Extract == "" || q.extract != nil {
+		return q.extract, nil
+	}
+	e, err := ParseExtract(q.Extract)
+	if err != nil {
+		return nil, err
+	}
+	q.extract = e
+	return e, nil
+}
+
+// Inherit returns the query with the server, interval or schedule, timeout, format, timestamp, sinks, retry policy
+// and built-in fields that it doesn't set taken from defaults, the extra fields are merged with the defaults.
+func (q Query) Inherit(defaults Query) Query {
+	if q.Server == "" && len(q.Servers) == 0 {
+		q.Server, q.Servers, q.Strategy = defaults.Server, defaults.Servers, defaults.Strategy
+	}
+	if q.Interval.Duration == 0 && q.Schedule == "" {
+		q.Interval = defaults.Interval
+		q.Schedule = defaults.Schedule
+		q.Timezone = defaults.Timezone
+	}
+	if q.Timeout.Duration == 0 {
+		q.Timeout = defaults.Timeout
+	}
+	if q.Format == "" {
+		q.Format = defaults.Format
+	}
+	if q.Timestamp == nil {
+		q.Timestamp = defaults.Timestamp
+	}
+	if len(q.Sinks) == 0 {
+		q.Sinks = defaults.Sinks
+	}
+	if q.Retry == nil {
+		q.Retry = defaults.Retry
+	}
+	if len(q.BuiltinFields) == 0 {
+		q.BuiltinFields = defaults.BuiltinFields
+	}
+	if len(defaults.ExtraFields) > 0 {
+		fields := make(map[string]string, len(defaults.ExtraFields)+len(q.ExtraFields))
+		for k, v := range defaults.ExtraFields {
+			fields[k] = v
+		}
+		for k, v := range q.ExtraFields {
+			fields[k] = v
+		}
+		q.ExtraFields = fields
+	}
+	return q
+}
+
+// equal reports whether both queries have the same configuration.
+func (q Query) equal(o Query) bool {
+	q.tmpl, q.extract, q.relabel, q.server, q.servers = nil, nil, nil, nil, nil
+	o.tmpl, o.extract, o.relabel, o.server, o.servers = nil, nil, nil, nil, nil
+	// the variables are shared by all the queries, they only matter to the ones using them
+	if q.vars != nil && o.vars != nil && strings.Contains(q.PromQL, "{{") && !q.vars.equal(o.vars) {
+		return false
+	}
+	q.vars, o.vars = nil, nil
+	return reflect.DeepEqual(q, o)
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Process applies the query's post-processing options to a record, returning the records to be emitted.
+func (q *Query) Process(r Record) []Record {
+	if q.SkipEmpty && r.Err == nil {
+		if samples, err := r.Samples(); err == nil && len(samples) == 0 {
+			return nil
+		}
+	}
+	r.Format = q.Format
+	if t, err := q.template(); err != nil {
+		r.Err = fmt.Errorf("invalid template: %w", err)
+	} else {
+		r.Template = t
+	}
+	if e, err := q.extractor(); err != nil {
+		r.Err = fmt.Errorf("invalid extract: %w", err)
+	} else {
+		r.Extract = e
+	}
+	if q.Thresholds.IsSet() {
+		t := q.Thresholds
+		r.Thresholds = &t
+	}
+	r.Fields = q.fields()
+	if r.Source != "" {
+		if r.Fields == nil {
+			r.Fields = make(map[string]string, 1)
+		}
+		r.Fields[FieldSource] = r.Source
+	}
+	r.Timestamp = q.Timestamp
+	records := []Record{r}
+	// exemplars have a record each
+	if q.Flatten || q.Type == TypeExemplars {
+		records = r.Flatten()
+	}
+	if q.Timestamp != nil && q.Timestamp.Sample {
+		for i := range records {
+			records[i].Time = records[i].sampleTime()
+		}
+	}
+	return records
+}
@@ -0,0 +1,193 @@
+package prom2log
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Record is the outcome of a single query execution.
+type Record struct {
+	Time time.Time
+	Name string
+	// Result is the parsed query result, one of model.Vector, model.Matrix, *model.Scalar or *model.String.
+	Result   model.Value
+	Warnings []string
+	Err      error
+	// Sample is set, instead of Result, on records holding a single sample of a flattened result.
+	Sample *Sample
+	// Format overrides the output format of the record, see Formats.
+	Format string
+	// Template, when set, is used to render the record instead of Format.
+	Template *template.Template
+	// Extract, when set, selects the part of the result rendered by the json and logfmt formats, see ParseExtract.
+	Extract *Extractor
+	// Thresholds, when set, add the severity of the samples to the output, see Severity.
+	Thresholds *Thresholds
+	// Source is the server the result came from, it's only set for queries fanned out to several servers.
+	Source string
+	// Fields are added to the output of the record.
+	Fields map[string]string
+	// Timestamp configures how Time is rendered, it defaults to the Go time.Time string representation.
+	Timestamp *TimestampConfig
+}
+
+// Sample is a single value of a query result.
+type Sample struct {
+	Metric    map[string]string
+	Value     float64
+	Timestamp time.Time
+}
+
+func labels(m model.Metric) map[string]string {
+	if m == nil {
+		return nil
+	}
+	l := make(map[string]string, len(m))
+	for k, v := range m {
+		l[string(k)] = string(v)
+	}
+	return l
+}
+
+// series returns the label sets of the series in the result.
+func (r Record) series() []map[string]string {
+	if r.Err != nil {
+		return nil
+	}
+	if r.Sample != nil {
+		return []map[string]string{r.Sample.Metric}
+	}
+	var series []map[string]string
+	switch v := r.Result.(type) {
+	case model.Vector:
+		for _, s := range v {
+			series = append(series, labels(s.Metric))
+		}
+	case model.Matrix:
+		for _, s := range v {
+			series = append(series, labels(s.Metric))
+		}
+	}
+	return series
+}
+
+// Samples returns all the samples in the result. A string result is a single sample with the string parsed as its
+// value, NaN when it isn't a number.
+func (r Record) Samples() ([]Sample, error) {
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	if r.Sample != nil {
+		return []Sample{*r.Sample}, nil
+	}
+	switch v := r.Result.(type) {
+	case model.Vector:
+		samples := make([]Sample, 0, len(v))
+		for _, s := range v {
+			samples = append(samples, Sample{
+				Metric:    labels(s.Metric),
+				Value:     float64(s.Value),
+				Timestamp: s.Timestamp.Time(),
+			})
+		}
+		return samples, nil
+	case model.Matrix:
+		var samples []Sample
+		for _, s := range v {
+			metric := labels(s.Metric)
+			for _, p := range s.Values {
+				samples = append(samples, Sample{
+					Metric:    metric,
+					Value:     float64(p.Value),
+					Timestamp: p.Timestamp.Time(),
+				})
+			}
+		}
+		return samples, nil
+	case *model.Scalar:
+		return []Sample{{
+			Value:     float64(v.Value),
+			Timestamp: v.Timestamp.Time(),
+		}}, nil
+	case *model.String:
+		value, err := strconv.ParseFloat(v.Value, 64)
+		if err != nil {
+			value = math.NaN()
+		}
+		return []Sample{{
+			Value:     value,
+			Timestamp: v.Timestamp.Time(),
+		}}, nil
+	case nil:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported result type %q", r.Result.Type())
+	}
+}
+
+// Flatten splits the record into one record per sample.
+func (r Record) Flatten() []Record {
+	if r.Err != nil || r.Sample != nil {
+		return []Record{r}
+	}
+	samples, err := r.Samples()
+	if err != nil {
+		r.Err = fmt.Errorf("flattening result: %w", err)
+		r.Result = nil
+		return []Record{r}
+	}
+	records := make([]Record, len(samples))
+	for i := range samples {
+		records[i] = Record{
+			Time:       r.Time,
+			Name:       r.Name,
+			Sample:     &samples[i],
+			Format:     r.Format,
+			Template:   r.Template,
+			Extract:    r.Extract,
+			Thresholds: r.Thresholds,
+			Fields:     r.Fields,
+			Timestamp:  r.Timestamp,
+		}
+	}
+	return records
+}
+
+// sharedLabels returns the labels that have the same value across all the series in the result.
+func (r Record) sharedLabels() map[string]string {
+	series := r.series()
+	if len(series) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(series[0]))
+	for n, v := range series[0] {
+		labels[n] = v
+		for _, s := range series[1:] {
+			if s[n] != v {
+				delete(labels, n)
+				break
+			}
+		}
+	}
+	return labels
+}
+
+// commonLabels returns the given labels that have the same value across all the series in the result.
+func (r Record) commonLabels(names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	shared := r.sharedLabels()
+	labels := make(map[string]string, len(names))
+	for _, n := range names {
+		if v, ok := shared[n]; ok {
+			labels[n] = v
+		}
+	}
+	return labels
+}
@@ -0,0 +1,93 @@
+package prom2log
+
+import (
+	"fmt"
+
+	"github.com/prometheus/common/model"
+	promlabels "github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v3"
+)
+
+// RelabelConfig is a Prometheus relabel config applied to the series of the query result, see
+// https://prometheus.io/docs/prometheus/latest/configuration/configuration/#relabel_config
+type RelabelConfig struct {
+	SourceLabels []string `json:"source_labels,omitempty" yaml:"source_labels,omitempty"`
+	Separator    string   `json:"separator,omitempty" yaml:"separator,omitempty"`
+	Regex        string   `json:"regex,omitempty" yaml:"regex,omitempty"`
+	Modulus      uint64   `json:"modulus,omitempty" yaml:"modulus,omitempty"`
+	TargetLabel  string   `json:"target_label,omitempty" yaml:"target_label,omitempty"`
+	Replacement  string   `json:"replacement,omitempty" yaml:"replacement,omitempty"`
+	Action       string   `json:"action,omitempty" yaml:"action,omitempty"`
+}
+
+// compile converts the config going through YAML, so the Prometheus defaults and validation apply.
+func (c RelabelConfig) compile() (*relabel.Config, error) {
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var cfg relabel.Config
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func (q *Query) relabelConfigs() ([]*relabel.Config, error) {
+	if len(q.RelabelConfigs) == 0 || q.relabel != nil {
+		return q.relabel, nil
+	}
+	cfgs := make([]*relabel.Config, len(q.RelabelConfigs))
+	for i, c := range q.RelabelConfigs {
+		cfg, err := c.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid relabel config %d: %w", i, err)
+		}
+		cfgs[i] = cfg
+	}
+	q.relabel = cfgs
+	return cfgs, nil
+}
+
+// relabelResult applies the relabel configs of the query to the series of a vector or matrix result,
+// dropping the ones they don't keep.
+func (q *Query) relabelResult(v model.Value) (model.Value, error) {
+	cfgs, err := q.relabelConfigs()
+	if err != nil || len(cfgs) == 0 {
+		return v, err
+	}
+	switch v := v.(type) {
+	case model.Vector:
+		vector := make(model.Vector, 0, len(v))
+		for _, s := range v {
+			if m, keep := relabelMetric(s.Metric, cfgs); keep {
+				s.Metric = m
+				vector = append(vector, s)
+			}
+		}
+		return vector, nil
+	case model.Matrix:
+		matrix := make(model.Matrix, 0, len(v))
+		for _, s := range v {
+			if m, keep := relabelMetric(s.Metric, cfgs); keep {
+				s.Metric = m
+				matrix = append(matrix, s)
+			}
+		}
+		return matrix, nil
+	}
+	return v, nil
+}
+
+func relabelMetric(m model.Metric, cfgs []*relabel.Config) (model.Metric, bool) {
+	lbls, keep := relabel.Process(promlabels.FromMap(labels(m)), cfgs...)
+	if !keep {
+		return nil, false
+	}
+	metric := make(model.Metric, lbls.Len())
+	lbls.Range(func(l promlabels.Label) {
+		metric[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	})
+	return metric, true
+}
@@ -0,0 +1,105 @@
+package prom2log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/common/model"
+)
+
+// apiResponse is the envelope of the Prometheus HTTP API responses.
+type apiResponse struct {
+	Status    string          `json:"status"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	ErrorType string          `json:"errorType,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	Warnings  []string        `json:"warnings,omitempty"`
+}
+
+// queryData is the data of the query and query_range responses.
+type queryData struct {
+	ResultType model.ValueType `json:"resultType"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// APIError is an error returned by the Prometheus API.
+type APIError struct {
+	Type    string
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Type == "" {
+		return e.Message
+	}
+	return e.Type + ": " + e.Message
+}
+
+// parseAPIResponse decodes the envelope of an API response, returning an error if its status isn't success.
+func parseAPIResponse(b []byte) (apiResponse, error) {
+	var resp apiResponse
+	if err := json.Unmarshal(b, &resp); err != nil {
+		if len(b) > 256 {
+			b = b[:256]
+		}
+		return resp, fmt.Errorf("invalid response %q: %w", b, err)
+	}
+	if resp.Status != "success" {
+		return resp, &APIError{Type: resp.ErrorType, Message: resp.Error}
+	}
+	return resp, nil
+}
+
+// ParseResult decodes the response of a query, returning the result and any warnings.
+// Responses with an error status are returned as an *APIError.
+func ParseResult(b []byte) (model.Value, []string, error) {
+	resp, err := parseAPIResponse(b)
+	if err != nil {
+		return nil, resp.Warnings, err
+	}
+	var data queryData
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return nil, resp.Warnings, fmt.Errorf("invalid result: %w", err)
+	}
+
+	var v model.Value
+	switch data.ResultType {
+	case model.ValVector:
+		v = &model.Vector{}
+	case model.ValMatrix:
+		v = &model.Matrix{}
+	case model.ValScalar:
+		v = &model.Scalar{}
+	case model.ValString:
+		v = &model.String{}
+	default:
+		return nil, resp.Warnings, fmt.Errorf("unsupported result type %q", data.ResultType)
+	}
+	if err := json.Unmarshal(data.Result, v); err != nil {
+		return nil, resp.Warnings, fmt.Errorf("invalid %s result: %w", data.ResultType, err)
+	}
+	switch r := v.(type) {
+	case *model.Vector:
+		v = *r
+	case *model.Matrix:
+		v = *r
+	}
+	return v, resp.Warnings, nil
+}
+
+// resultJSON renders the result as the Prometheus API would.
+func resultJSON(v model.Value, warnings []string) (json.RawMessage, error) {
+	if v == nil {
+		return nil, errors.New("empty result")
+	}
+	result, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(queryData{ResultType: v.Type(), Result: result})
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(apiResponse{Status: "success", Data: data, Warnings: warnings})
+}
@@ -0,0 +1,113 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RetryConfig configures how failed requests are retried using exponential backoff.
+type RetryConfig struct {
+	// MaxRetries is the number of retries after the first attempt, defaults to 3, negative values disable retries.
+	MaxRetries int `json:"max_retries"`
+	// MinBackoff is the delay before the first retry, defaults to 500ms.
+	MinBackoff metav1.Duration `json:"min_backoff"`
+	// MaxBackoff caps the delay between retries, defaults to 30s.
+	MaxBackoff metav1.Duration `json:"max_backoff"`
+}
+
+// retryableError marks an error as transient.
+type retryableError struct {
+	err error
+	// after is the delay requested by the server, if any.
+	after time.Duration
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryableError) Unwrap() error {
+	return e.err
+}
+
+// permanentError marks an error that should not be retried.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// statusError returns an error for unsuccessful HTTP responses,
+// 429 and 5xx responses are retryable, the remaining ones are permanent.
+func statusError(resp *http.Response, body []byte) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	err := fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, body)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		var after time.Duration
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			after = time.Duration(s) * time.Second
+		}
+		return &retryableError{err: err, after: after}
+	}
+	return &permanentError{err: err}
+}
+
+// Do calls fn until it succeeds, returns a permanent error, the retries are exhausted or ctx is done.
+// Errors not produced by statusError, like connection errors, are considered retryable.
+func (c RetryConfig) Do(ctx context.Context, fn func() error) error {
+	retries := c.MaxRetries
+	if retries == 0 {
+		retries = 3
+	}
+	backoff := c.MinBackoff.Duration
+	if backoff <= 0 {
+		backoff = 500 * time.Millisecond
+	}
+	maxBackoff := c.MaxBackoff.Duration
+	if maxBackoff <= 0 {
+		maxBackoff = 30 * time.Second
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || ctx.Err() != nil {
+			return err
+		}
+		var pe *permanentError
+		if errors.As(err, &pe) {
+			return err
+		}
+		delay := backoff
+		var re *retryableError
+		if errors.As(err, &re) && re.after > delay {
+			delay = re.after
+		}
+		if delay > maxBackoff {
+			delay = maxBackoff
+		}
+		log().Debug("retrying", "attempt", attempt+1, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
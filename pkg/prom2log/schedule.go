@@ -0,0 +1,107 @@
+package prom2log
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule determines when a query runs.
+type Schedule interface {
+	// Next returns the next activation time, later than the given time.
+	Next(time.Time) time.Time
+}
+
+// cronParser accepts standard cron expressions with an optional seconds field and descriptors like @hourly.
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+type intervalSchedule time.Duration
+
+func (s intervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
+
+// alignedSchedule activates at multiples of the interval.
+type alignedSchedule time.Duration
+
+func (s alignedSchedule) Next(t time.Time) time.Time {
+	return t.Truncate(time.Duration(s)).Add(time.Duration(s))
+}
+
+// offsetSchedule shifts every activation of a schedule by a fixed offset.
+type offsetSchedule struct {
+	Schedule
+	offset time.Duration
+}
+
+func (s offsetSchedule) Next(t time.Time) time.Time {
+	return s.Schedule.Next(t.Add(-s.offset)).Add(s.offset)
+}
+
+// firstRun returns the first activation of a schedule started at the given time.
+// Plain intervals start right away, everything else waits for its next activation.
+func firstRun(s Schedule, now time.Time) time.Time {
+	switch s := s.(type) {
+	case intervalSchedule:
+		return now
+	case offsetSchedule:
+		return firstRun(s.Schedule, now).Add(s.offset)
+	}
+	return s.Next(now)
+}
+
+// ValidateSchedule checks the options that determine when the query runs, they're only required to schedule it.
+func (q *Query) ValidateSchedule() error {
+	_, err := q.schedule()
+	return err
+}
+
+// schedule returns when the query runs.
+func (q *Query) schedule() (Schedule, error) {
+	s, err := q.baseSchedule()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case q.Jitter.Duration < 0:
+		return nil, errors.New("jitter can't be negative")
+	case q.Jitter.Duration > 0:
+		s = offsetSchedule{Schedule: s, offset: time.Duration(rand.Int63n(int64(q.Jitter.Duration)))}
+	}
+	return s, nil
+}
+
+func (q *Query) baseSchedule() (Schedule, error) {
+	switch {
+	case q.Schedule != "" && q.Interval.Duration > 0:
+		return nil, errors.New("interval and schedule are mutually exclusive")
+	case q.Schedule != "" && q.Align:
+		return nil, errors.New("align can't be used with a schedule")
+	case q.Schedule != "":
+		spec := q.Schedule
+		if q.Timezone != "" {
+			if _, err := time.LoadLocation(q.Timezone); err != nil {
+				return nil, fmt.Errorf("invalid timezone: %w", err)
+			}
+			spec = "CRON_TZ=" + q.Timezone + " " + spec
+		}
+		s, err := cronParser.Parse(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule: %w", err)
+		}
+		return s, nil
+	case q.Timezone != "":
+		return nil, errors.New("timezone requires a schedule")
+	case q.Align && q.Interval.Duration > 0:
+		return alignedSchedule(q.Interval.Duration), nil
+	case q.Align:
+		return nil, errors.New("align requires an interval")
+	case q.Interval.Duration > 0:
+		return intervalSchedule(q.Interval.Duration), nil
+	default:
+		return nil, errors.New("either interval or schedule must be set")
+	}
+}
@@ -0,0 +1,481 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Scheduler runs a set of queries on their configured intervals.
+type Scheduler struct {
+	Queries map[string]Query
+	// Servers are the named servers the queries can use, see BindServers.
+	Servers map[string]*Server
+	// Sinks are the named sinks the queries can send their records to.
+	Sinks map[string]Sink
+	// Output lists the sinks used by queries that don't set their own.
+	// When empty, records are written to Out using Formatter.
+	Output    []string
+	Formatter Formatter
+	// Out is where the records are written to when no sinks are configured, defaults to os.Stdout.
+	Out io.Writer
+	// Shard, when set, limits the scheduled queries to the ones belonging to it.
+	Shard Shard
+	// Limits bound the requests sent by all the scheduled queries, on top of the limits of each server.
+	// When MaxConcurrency is set, the queries are run by a pool of that many workers.
+	Limits Limits
+	// DrainTimeout is how long Run waits for the running queries to finish writing their results once its context
+	// is cancelled, before cancelling them. When zero they're cancelled right away.
+	DrainTimeout time.Duration
+	// State, when set, persists the time of the last successful run of each query, so after a restart the queries
+	// that already ran aren't run again right away and the missed runs are handled according to CatchUp.
+	State StateStore
+	// CatchUp is what to do with the runs missed while the scheduler wasn't running, CatchUpSkip, the default,
+	// or CatchUpBackfill. It requires a State.
+	CatchUp string
+
+	mu  sync.Mutex
+	ctx context.Context
+	// runCtx is the context of the query runs, it outlives ctx until the running queries are drained.
+	runCtx   context.Context
+	wg       sync.WaitGroup
+	jobs     map[string]*job
+	paused   map[string]bool
+	fallback Sink
+	limiter  *limiter
+	// queue holds the jobs waiting for their next run and ready the ones due, waiting for a worker, see pool.go.
+	queue     jobQueue
+	ready     []*job
+	readyCond *sync.Cond
+	// wake makes the dispatcher check the queue again.
+	wake chan struct{}
+	// closing is set once the scheduler stops running the queries.
+	closing bool
+	// saved is the last loaded or saved state.
+	saved map[string]time.Time
+	// standby skips the scheduled runs, see SetStandby.
+	standby atomic.Bool
+}
+
+// job is a scheduled query.
+type job struct {
+	name   string
+	query  Query
+	sink   Sink
+	sched  Schedule
+	ctx    context.Context
+	cancel context.CancelFunc
+	// last is the time of the last successful run before a restart, the runs missed since are caught up first.
+	last time.Time
+	// changes filters out unchanged results, it's only used by the job's runs, which never overlap.
+	changes *changeFilter
+
+	// The scheduling state is guarded by the scheduler's mutex: next is the next activation of the job, triggered
+	// is set when it must run right away, index is its position in the queue and idle is closed when its run,
+	// of the given kind, returns. caughtUp is the next activation returned by a catch up run.
+	state     jobState
+	kind      runKind
+	next      time.Time
+	triggered bool
+	index     int
+	idle      chan struct{}
+	caughtUp  time.Time
+
+	mu     sync.Mutex
+	status QueryStatus
+}
+
+// Run starts polling all the queries and blocks until ctx is cancelled, then it stops scheduling new runs and
+// waits up to DrainTimeout for the running ones to finish before returning.
+func (s *Scheduler) Run(ctx context.Context) error {
+	if err := s.Shard.validate(); err != nil {
+		return err
+	}
+	if err := s.Limits.Validate(); err != nil {
+		return err
+	}
+	if err := s.validateCatchUp(); err != nil {
+		return err
+	}
+	state, err := s.loadState(ctx)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	sinks, schedules, err := s.prepareRun()
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	runCtx, cancelRuns := context.WithCancel(withoutCancel{ctx})
+	defer cancelRuns()
+	s.ctx, s.runCtx = ctx, runCtx
+	s.limiter = newLimiter(s.Limits)
+	s.jobs = make(map[string]*job, len(s.Queries))
+	s.paused = make(map[string]bool)
+	s.saved = state
+	s.startWorkers()
+	for name, q := range s.Queries {
+		if s.Shard.Owns(name) {
+			s.start(name, q, schedules[name], sinks[name], state[name])
+		}
+	}
+	if s.Shard.Count > 1 {
+		log().Info("running a shard of the queries", "shard", s.Shard.Index, "shards", s.Shard.Count, "queries", len(s.jobs))
+	}
+	s.mu.Unlock()
+
+	drained := make(chan struct{})
+	persisted := make(chan struct{})
+	if s.State != nil {
+		go func() {
+			defer close(persisted)
+			s.persist(drained)
+		}()
+	} else {
+		close(persisted)
+	}
+
+	<-ctx.Done()
+	log().Info("stopping, waiting for the running queries to finish", "timeout", s.DrainTimeout)
+	s.mu.Lock()
+	s.stopWorkers()
+	s.mu.Unlock()
+	s.drain(cancelRuns)
+	close(drained)
+	<-persisted
+	return nil
+}
+
+// drain waits up to DrainTimeout for the running queries to finish, cancelling them after it.
+func (s *Scheduler) drain(cancelRuns context.CancelFunc) {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	if s.DrainTimeout > 0 {
+		timer := time.NewTimer(s.DrainTimeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+			log().Warn("timed out waiting for the running queries to finish, cancelling them", "timeout", s.DrainTimeout)
+		}
+	}
+	cancelRuns()
+	<-done
+}
+
+// withoutCancel keeps the values of a context, but not its cancellation nor deadline.
+type withoutCancel struct {
+	context.Context
+}
+
+func (withoutCancel) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (withoutCancel) Done() <-chan struct{}       { return nil }
+func (withoutCancel) Err() error                  { return nil }
+
+// Update replaces the queries, servers, sinks and output of a running scheduler.
+// Only the queries whose configuration, server or sinks changed are restarted, so servers and sinks
+// that didn't change must be the same instances, closing the ones no longer used is up to the caller.
+// If the new configuration is invalid, an error is returned and the scheduler keeps running the previous one.
+func (s *Scheduler) Update(queries map[string]Query, servers map[string]*Server, sinks map[string]Sink, output []string) error {
+	s.mu.Lock()
+	stopped, err := s.update(queries, servers, sinks, output)
+	s.mu.Unlock()
+	waitIdle(stopped)
+	return err
+}
+
+// update implements Update, s.mu must be held. It returns the channels of the stopped jobs that are still running,
+// to be waited for with waitIdle once s.mu is released.
+func (s *Scheduler) update(queries map[string]Query, servers map[string]*Server, sinks map[string]Sink, output []string) ([]<-chan struct{}, error) {
+	if s.jobs == nil {
+		return nil, ErrNotRunning
+	}
+
+	prevQueries, prevServers, prevSinks, prevOutput := s.Queries, s.Servers, s.Sinks, s.Output
+	s.Queries, s.Servers, s.Sinks, s.Output = queries, servers, sinks, output
+	querySinks, schedules, err := s.prepareRun()
+	if err != nil {
+		s.Queries, s.Servers, s.Sinks, s.Output = prevQueries, prevServers, prevSinks, prevOutput
+		return nil, err
+	}
+
+	var stopped []<-chan struct{}
+	for name, j := range s.jobs {
+		if q, ok := s.Queries[name]; ok && j.query.equal(q) && j.query.sameServers(&q) && sameSink(j.sink, querySinks[name]) {
+			continue
+		}
+		if idle := s.stop(j); idle != nil {
+			stopped = append(stopped, idle)
+		}
+		delete(s.jobs, name)
+		if _, ok := s.Queries[name]; !ok {
+			delete(s.paused, name)
+			deleteMetrics(name)
+		}
+	}
+	for name, q := range s.Queries {
+		if _, ok := s.jobs[name]; !ok && s.Shard.Owns(name) {
+			s.start(name, q, schedules[name], querySinks[name], time.Time{})
+		}
+	}
+	return stopped, nil
+}
+
+// prepareRun prepares the queries to be scheduled, returning their sinks and schedules.
+func (s *Scheduler) prepareRun() (map[string]Sink, map[string]Schedule, error) {
+	sinks, err := s.prepare()
+	if err != nil {
+		return nil, nil, err
+	}
+	schedules := make(map[string]Schedule, len(s.Queries))
+	for name, q := range s.Queries {
+		sched, err := q.schedule()
+		if err != nil {
+			return nil, nil, fmt.Errorf("query %s: %w", name, err)
+		}
+		schedules[name] = sched
+	}
+	return sinks, schedules, nil
+}
+
+// start schedules a query until it's stopped or the scheduler's context is cancelled, s.mu must be held.
+// When last, the time of its last successful run before a restart, is set the missed runs are caught up.
+func (s *Scheduler) start(name string, q Query, sched Schedule, sink Sink, last time.Time) {
+	ctx, cancel := context.WithCancel(withLimiter(s.runCtx, s.limiter))
+	j := &job{
+		name:    name,
+		query:   q,
+		sink:    sink,
+		sched:   sched,
+		ctx:     ctx,
+		cancel:  cancel,
+		last:    last,
+		changes: q.newChangeFilter(),
+		index:   -1,
+		status: QueryStatus{
+			Name:     name,
+			Server:   strings.Join(q.serverNames(), ","),
+			Schedule: q.DescribeSchedule(),
+			Paused:   s.paused[name],
+		},
+	}
+	s.jobs[name] = j
+	if !last.IsZero() && !s.standby.Load() {
+		s.enqueue(j, runCatchUp)
+		return
+	}
+	j.next = firstRun(sched, time.Now())
+	log().Debug("query scheduled", "query", name, "next", j.next)
+	s.requeue(j)
+}
+
+// sameSink reports whether a and b are the same sink instances.
+func sameSink(a, b Sink) bool {
+	ma, okA := a.(MultiSink)
+	mb, okB := b.(MultiSink)
+	if okA != okB {
+		return false
+	}
+	if !okA {
+		return a == b
+	}
+	if len(ma) != len(mb) {
+		return false
+	}
+	for i := range ma {
+		if !sameSink(ma[i], mb[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Backfill replays all the queries between start and end, sending one record per evaluation to the sinks.
+func (s *Scheduler) Backfill(ctx context.Context, start, end time.Time, step time.Duration) error {
+	if err := s.Limits.Validate(); err != nil {
+		return err
+	}
+	sinks, err := s.prepare()
+	if err != nil {
+		return err
+	}
+	ctx = withLimiter(ctx, newLimiter(s.Limits))
+	names := make([]string, 0, len(s.Queries))
+	for name := range s.Queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		q := s.Queries[name]
+		if !q.IsPromQL() {
+			log().Info("only PromQL queries can be backfilled, skipping it", "query", name, "type", q.Type)
+			continue
+		}
+		if err := s.backfill(ctx, name, q, q.newChangeFilter(), sinks[name], start, end, step); err != nil {
+			return fmt.Errorf("query %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// backfill replays a query between start and end, writing the records to the sink,
+// fanout queries are replayed on each server in turn.
+func (s *Scheduler) backfill(ctx context.Context, name string, q Query, changes *changeFilter, sink Sink, start, end time.Time, step time.Duration) error {
+	for _, t := range q.targets() {
+		var source string
+		if q.Strategy == StrategyFanout {
+			source = t.Server
+		}
+		err := t.Backfill(ctx, name, start, end, step, func(r Record) error {
+			r.Source = source
+			if !changes.emit(r) {
+				return nil
+			}
+			for _, r := range q.Process(r) {
+				if err := sink.Write(ctx, r); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			if source != "" {
+				err = fmt.Errorf("server %s: %w", source, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare validates the queries, binds them to their servers and returns the sink of each one.
+func (s *Scheduler) prepare() (map[string]Sink, error) {
+	if s.Out == nil {
+		s.Out = os.Stdout
+	}
+	if s.fallback == nil {
+		s.fallback = NewWriterSink(s.Out, s.Formatter)
+	}
+	if s.Servers == nil {
+		s.Servers = make(map[string]*Server)
+	}
+	if err := BindServers(s.Queries, s.Servers); err != nil {
+		return nil, err
+	}
+
+	sinks := make(map[string]Sink, len(s.Queries))
+	for name, q := range s.Queries {
+		if err := q.Validate(); err != nil {
+			return nil, fmt.Errorf("query %s: %w", name, err)
+		}
+		sink, err := s.sinkFor(q, s.fallback)
+		if err != nil {
+			return nil, fmt.Errorf("query %s: %w", name, err)
+		}
+		sinks[name] = sink
+	}
+	return sinks, nil
+}
+
+func (s *Scheduler) sinkFor(q Query, fallback Sink) (Sink, error) {
+	names := q.Sinks
+	if len(names) == 0 {
+		names = s.Output
+	}
+	if len(names) == 0 {
+		return fallback, nil
+	}
+	var sinks MultiSink
+	for _, n := range names {
+		sink, ok := s.Sinks[n]
+		if !ok {
+			return nil, fmt.Errorf("unknown sink %q", n)
+		}
+		sinks = append(sinks, sink)
+	}
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return sinks, nil
+}
+
+func (s *Scheduler) log(ctx context.Context, j *job) {
+	if j.query.Stream {
+		s.stream(ctx, j)
+		return
+	}
+	start := time.Now()
+	records := j.query.RunAll(ctx, j.name)
+	if ctx.Err() != nil {
+		return
+	}
+	j.record(records, 0, time.Since(start))
+	for _, r := range records {
+		if errors.Is(r.Err, ErrCircuitOpen) {
+			// the breaker already logged the server being down
+			continue
+		}
+		if !j.changes.emit(r) {
+			log().Debug("query result unchanged, skipping it", "query", j.name, "source", r.Source)
+			continue
+		}
+		s.write(ctx, j, r)
+	}
+}
+
+// stream runs a streaming query, writing the records of each series to the sinks as soon as it's decoded.
+func (s *Scheduler) stream(ctx context.Context, j *job) {
+	start := time.Now()
+	var (
+		records []Record
+		samples int
+	)
+	for _, t := range j.query.targets() {
+		r := Record{Name: j.name}
+		if j.query.Strategy == StrategyFanout {
+			r.Source = t.Server
+		}
+		n, warnings, err := t.RunStream(ctx, j.name, func(sr Record) error {
+			sr.Source = r.Source
+			s.write(ctx, j, sr)
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		r.Time, r.Warnings, r.Err = time.Now(), warnings, err
+		records = append(records, r)
+		samples += n
+	}
+	j.record(records, samples, time.Since(start))
+	for _, r := range records {
+		if len(r.Warnings) > 0 {
+			log().Warn("query returned warnings", "query", j.name, "source", r.Source, "warnings", r.Warnings)
+		}
+		if r.Err != nil && !errors.Is(r.Err, ErrCircuitOpen) {
+			s.write(ctx, j, r)
+		}
+	}
+}
+
+// write processes the record and writes the resulting records to the sinks of the job.
+func (s *Scheduler) write(ctx context.Context, j *job, r Record) {
+	for _, r := range j.query.Process(r) {
+		if err := j.sink.Write(ctx, r); err != nil {
+			sinkErrors.WithLabelValues(j.name).Inc()
+			log().Error("failed to write the result", "query", j.name, "error", err)
+		}
+	}
+}
@@ -0,0 +1,322 @@
+package prom2log
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServerConfig configures how a Prometheus server is queried.
+type ServerConfig struct {
+	// URL of the Prometheus server.
+	URL string `json:"url"`
+	// Discovery resolves the URL of the server dynamically, instead of setting it.
+	Discovery *DiscoveryConfig `json:"discovery,omitempty"`
+	AuthConfig
+	// OAuth2 authenticates the requests with tokens obtained using the client credentials flow,
+	// it can't be combined with the other authentication methods and takes precedence over the queries' credentials.
+	OAuth2 *OAuth2Config `json:"oauth2,omitempty"`
+	// Headers are added to every request, e.g. X-Scope-OrgID to select the tenant of multi-tenant backends.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Method is the HTTP method of the requests, GET or POST, defaults to GET. POST sends the parameters
+	// form-encoded in the body, for expressions exceeding the URL length limits of proxies.
+	Method string `json:"method,omitempty"`
+	// ProxyURL is the URL of the HTTP, HTTPS or SOCKS5 proxy used to reach the server,
+	// by default the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are honoured.
+	ProxyURL string `json:"proxy_url,omitempty"`
+	// TLS configures the connection to HTTPS servers, e.g. to use a private CA or client certificates.
+	TLS *TLSConfig `json:"tls,omitempty"`
+	// CircuitBreaker stops querying the server for a while after consecutive failures.
+	CircuitBreaker *BreakerConfig `json:"circuit_breaker,omitempty"`
+	// MaxIdleConns is the number of idle connections kept open to the server, defaults to 10.
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+	// IdleConnTimeout is how long idle connections are kept open, defaults to 90s.
+	IdleConnTimeout metav1.Duration `json:"idle_conn_timeout,omitempty"`
+	// DisableHTTP2 stops HTTP/2 from being negotiated with TLS servers.
+	DisableHTTP2 bool `json:"disable_http2,omitempty"`
+	// DisableCompression stops requesting gzip compressed responses.
+	DisableCompression bool `json:"disable_compression,omitempty"`
+	// Limits bound the requests sent to the server by all the queries using it.
+	Limits
+}
+
+// transport returns the HTTP transport used to connect to the server.
+func (c ServerConfig) transport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = 10
+	if c.MaxIdleConns > 0 {
+		t.MaxIdleConns = c.MaxIdleConns
+	}
+	// all the connections go to the same host
+	t.MaxIdleConnsPerHost = t.MaxIdleConns
+	if c.IdleConnTimeout.Duration > 0 {
+		t.IdleConnTimeout = c.IdleConnTimeout.Duration
+	}
+	if c.DisableHTTP2 {
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+	t.DisableCompression = c.DisableCompression
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy_url: %w", err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if c.TLS != nil {
+		tlsConfig, err := c.TLS.Build()
+		if err != nil {
+			return nil, err
+		}
+		t.TLSClientConfig = tlsConfig
+	}
+	return t, nil
+}
+
+// Server sends requests to a Prometheus server, it's safe for concurrent use
+// and meant to be shared by all the queries using the same server, reusing its connections.
+type Server struct {
+	url      string
+	resolver *resolver
+	auth     AuthConfig
+	headers  map[string]string
+	method   string
+	client   *http.Client
+	breaker  *breaker
+	limiter  *limiter
+}
+
+// NewServer returns a server with the given configuration.
+func NewServer(cfg ServerConfig) (*Server, error) {
+	var (
+		r   *resolver
+		err error
+	)
+	switch {
+	case cfg.Discovery != nil && cfg.URL != "":
+		return nil, errors.New("url and discovery are mutually exclusive")
+	case cfg.Discovery != nil:
+		if r, err = newResolver(*cfg.Discovery); err != nil {
+			return nil, err
+		}
+	case cfg.URL == "":
+		return nil, errors.New("url is required")
+	}
+	if _, err := url.Parse(cfg.URL); err != nil {
+		return nil, err
+	}
+	if err := cfg.AuthConfig.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Limits.Validate(); err != nil {
+		return nil, err
+	}
+	if err := validateMethod(cfg.Method); err != nil {
+		return nil, err
+	}
+	if cfg.OAuth2 != nil && cfg.AuthConfig.IsSet() {
+		return nil, errors.New("oauth2 can't be combined with other authentication methods")
+	}
+	transport, err := cfg.transport()
+	if err != nil {
+		return nil, err
+	}
+	var rt http.RoundTripper = transport
+	if cfg.OAuth2 != nil {
+		if rt, err = cfg.OAuth2.transport(transport); err != nil {
+			return nil, err
+		}
+	}
+	s := &Server{
+		url:      strings.TrimSuffix(cfg.URL, "/"),
+		resolver: r,
+		auth:     cfg.AuthConfig,
+		headers:  cfg.Headers,
+		method:   cfg.Method,
+		client:   &http.Client{Transport: rt},
+		limiter:  newLimiter(cfg.Limits),
+	}
+	if cfg.CircuitBreaker != nil {
+		name := s.url
+		if r != nil {
+			name = r.name
+		}
+		s.breaker = newBreaker(name, *cfg.CircuitBreaker)
+	}
+	return s, nil
+}
+
+// NewServers returns the servers with the given configurations, indexed by name.
+func NewServers(configs map[string]ServerConfig) (map[string]*Server, error) {
+	servers := make(map[string]*Server, len(configs))
+	for name, cfg := range configs {
+		s, err := NewServer(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("server %s: %w", name, err)
+		}
+		servers[name] = s
+	}
+	return servers, nil
+}
+
+// BindServers sets the servers used by each query, either one of the named servers or,
+// for queries setting a URL, a server shared by all the queries with the same URL which is added to servers.
+func BindServers(queries map[string]Query, servers map[string]*Server) error {
+	for name, q := range queries {
+		bound := make([]*Server, 0, len(q.serverNames()))
+		for _, server := range q.serverNames() {
+			s, ok := servers[server]
+			if !ok {
+				var err error
+				if s, err = NewServer(ServerConfig{URL: server}); err != nil {
+					return fmt.Errorf("query %s: %w", name, err)
+				}
+				servers[server] = s
+			}
+			bound = append(bound, s)
+		}
+		if len(q.Servers) > 0 {
+			q.servers = bound
+		} else {
+			q.server = bound[0]
+		}
+		queries[name] = q
+	}
+	return nil
+}
+
+// postPaths are the API endpoints accepting POST requests, the requests to the others are always sent with GET.
+var postPaths = map[string]bool{queryPath: true, queryRangePath: true, exemplarsPath: true, labelsPath: true, seriesPath: true}
+
+// validateMethod checks the HTTP method of the requests, empty defaults to GET.
+func validateMethod(method string) error {
+	switch method {
+	case "", http.MethodGet, http.MethodPost:
+		return nil
+	}
+	return fmt.Errorf("unsupported method %q, must be GET or POST", method)
+}
+
+// Probe checks that the server can be queried by running a trivial query.
+func (s *Server) Probe(ctx context.Context) error {
+	b, err := s.do(ctx, &Query{}, queryPath, url.Values{"query": []string{"vector(1)"}})
+	if err != nil {
+		return err
+	}
+	_, _, err = ParseResult(b)
+	return err
+}
+
+// do sends a single request for the query to the server and returns the response body.
+func (s *Server) do(ctx context.Context, q *Query, path string, params url.Values) ([]byte, error) {
+	var b []byte
+	err := s.stream(ctx, q, path, params, func(r io.Reader) error {
+		var err error
+		b, err = io.ReadAll(r)
+		return err
+	})
+	return b, err
+}
+
+// stream sends a single request for the query to the server and calls read with the response body,
+// which is still being received, errors returned by read count as failures of the request.
+func (s *Server) stream(ctx context.Context, q *Query, path string, params url.Values, read func(io.Reader) error) (err error) {
+	release, err := waitLimits(ctx, s.limiter)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if s.breaker != nil {
+		if !s.breaker.allow() {
+			return &permanentError{err: ErrCircuitOpen}
+		}
+		defer func() {
+			// errors caused by the caller giving up don't say anything about the server
+			if ctx.Err() == nil || err == nil {
+				s.breaker.record(err)
+			} else {
+				s.breaker.cancel()
+			}
+		}()
+	}
+	reqCtx := ctx
+	if q.Timeout.Duration > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, q.Timeout.Duration)
+		defer cancel()
+	}
+
+	base := s.url
+	if s.resolver != nil {
+		if base, err = s.resolver.url(reqCtx); err != nil {
+			return err
+		}
+	}
+	method := s.method
+	if q.Method != "" {
+		method = q.Method
+	}
+	var req *http.Request
+	if method == http.MethodPost && postPaths[path] {
+		req, err = http.NewRequestWithContext(reqCtx, method, base+path, strings.NewReader(params.Encode()))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	} else {
+		req, err = http.NewRequestWithContext(reqCtx, http.MethodGet, base+path+"?"+params.Encode(), nil)
+	}
+	if err != nil {
+		return err
+	}
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range q.Headers {
+		req.Header.Set(k, v)
+	}
+	auth := s.auth
+	if q.AuthConfig.IsSet() {
+		auth = q.AuthConfig
+	}
+	if err := auth.apply(req); err != nil {
+		return err
+	}
+	response, err := s.client.Do(req)
+	if err != nil {
+		if s.resolver != nil && ctx.Err() == nil {
+			s.resolver.failed(base)
+		}
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode < 300 {
+		return read(response.Body)
+	}
+	b, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	// API errors have a JSON body, anything else comes from a proxy or the server is misbehaving
+	if !json.Valid(b) {
+		if len(b) > 256 {
+			b = b[:256]
+		}
+		return statusError(response, b)
+	}
+	return read(bytes.NewReader(b))
+}
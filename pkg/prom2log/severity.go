@@ -0,0 +1,123 @@
+package prom2log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Severities of the samples of queries with thresholds, from lowest to highest.
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Thresholds set the severity of the samples of a query, each one is a comparison against the sample value,
+// e.g. "> 0.8", using one of the >, >=, <, <=, == or != operators.
+// Samples matching neither have the info severity.
+type Thresholds struct {
+	// Warn is the condition for a sample to have the warning severity.
+	Warn string `json:"warn,omitempty"`
+	// Crit is the condition for a sample to have the critical severity, it takes precedence over Warn.
+	Crit string `json:"crit,omitempty"`
+}
+
+// IsSet reports whether any threshold is set.
+func (t Thresholds) IsSet() bool {
+	return t.Warn != "" || t.Crit != ""
+}
+
+func (t Thresholds) validate() error {
+	for name, c := range map[string]string{"warn": t.Warn, "crit": t.Crit} {
+		if c == "" {
+			continue
+		}
+		if _, err := parseCondition(c); err != nil {
+			return fmt.Errorf("invalid %s threshold: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Severity returns the severity of a sample value.
+func (t Thresholds) Severity(v float64) string {
+	if c, err := parseCondition(t.Crit); err == nil && c.match(v) {
+		return SeverityCritical
+	}
+	if c, err := parseCondition(t.Warn); err == nil && c.match(v) {
+		return SeverityWarning
+	}
+	return SeverityInfo
+}
+
+type condition struct {
+	op    string
+	value float64
+}
+
+// operators is ordered so two character operators are matched before their one character prefixes.
+var operators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+func parseCondition(s string) (condition, error) {
+	s = strings.TrimSpace(s)
+	for _, op := range operators {
+		if !strings.HasPrefix(s, op) {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(s[len(op):]), 64)
+		if err != nil {
+			return condition{}, fmt.Errorf("%q: %w", s, err)
+		}
+		return condition{op: op, value: v}, nil
+	}
+	return condition{}, fmt.Errorf("%q: expected a comparison like \"> 0.8\"", s)
+}
+
+func (c condition) match(v float64) bool {
+	switch c.op {
+	case ">":
+		return v > c.value
+	case ">=":
+		return v >= c.value
+	case "<":
+		return v < c.value
+	case "<=":
+		return v <= c.value
+	case "==":
+		return v == c.value
+	case "!=":
+		return v != c.value
+	}
+	return false
+}
+
+var severityRank = map[string]int{SeverityInfo: 1, SeverityWarning: 2, SeverityCritical: 3}
+
+// Severity returns the highest severity of the samples in the record, or an empty string
+// if the query has no thresholds or failed.
+func (r Record) Severity() string {
+	if r.Thresholds == nil || r.Err != nil {
+		return ""
+	}
+	samples, err := r.Samples()
+	if err != nil {
+		return ""
+	}
+	severity := SeverityInfo
+	for _, s := range samples {
+		if sev := r.Thresholds.Severity(s.Value); severityRank[sev] > severityRank[severity] {
+			severity = sev
+		}
+	}
+	return severity
+}
+
+// sampleSeverity returns the severity of one of the samples of the record,
+// or an empty string if the query has no thresholds.
+func (r Record) sampleSeverity(s Sample) string {
+	if r.Thresholds == nil {
+		return ""
+	}
+	return r.Thresholds.Severity(s.Value)
+}
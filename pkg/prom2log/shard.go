@@ -0,0 +1,66 @@
+package prom2log
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Shard selects the subset of the queries run by one of several replicas, each query belongs to exactly one shard.
+// Queries are assigned by consistent hashing of their names, so changing the number of shards moves as few queries
+// as possible between replicas.
+type Shard struct {
+	// Index of the shard, from 0 to Count-1.
+	Index int
+	// Count is the number of shards, with 0 or 1 all the queries belong to the single shard.
+	Count int
+}
+
+func (s Shard) validate() error {
+	if s.Count < 0 {
+		return errors.New("the shard count can't be negative")
+	}
+	if s.Count > 1 && (s.Index < 0 || s.Index >= s.Count) {
+		return fmt.Errorf("the shard index must be between 0 and %d", s.Count-1)
+	}
+	return nil
+}
+
+// Owns reports whether the query with the given name belongs to the shard.
+func (s Shard) Owns(name string) bool {
+	if s.Count <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return jumpHash(h.Sum64(), s.Count) == s.Index
+}
+
+// jumpHash maps the key to one of n buckets using the jump consistent hash by Lamping and Veach.
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// PodOrdinal returns the ordinal of the StatefulSet pod prom2log runs in, taken from the end of its hostname,
+// e.g. 2 for prom2log-2.
+func PodOrdinal() (int, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return 0, err
+	}
+	i := strings.LastIndexByte(hostname, '-')
+	ordinal, err := strconv.Atoi(hostname[i+1:])
+	if i < 0 || err != nil || ordinal < 0 {
+		return 0, fmt.Errorf("hostname %q doesn't end with a StatefulSet ordinal", hostname)
+	}
+	return ordinal, nil
+}
@@ -0,0 +1,72 @@
+package prom2log
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestJumpHash(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 10, 100} {
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			counts := make([]int, n)
+			for key := uint64(0); key < 10000; key++ {
+				b := jumpHash(key*0x9e3779b97f4a7c15, n)
+				if b < 0 || b >= n {
+					t.Fatalf("jumpHash(%d, %d) = %d, out of range", key, n, b)
+				}
+				counts[b]++
+				// growing the number of buckets only moves keys to the new one
+				if moved := jumpHash(key*0x9e3779b97f4a7c15, n+1); moved != b && moved != n {
+					t.Fatalf("key %d moved from %d to %d with %d buckets", key, b, moved, n+1)
+				}
+			}
+			for b, c := range counts {
+				if c < 10000/n/2 {
+					t.Errorf("bucket %d got %d keys out of 10000", b, c)
+				}
+			}
+		})
+	}
+}
+
+func TestShardOwns(t *testing.T) {
+	names := []string{"up", "cpu", "memory", "disk", "network", "errors", "latency", "requests"}
+	for _, count := range []int{0, 1, 2, 3, 5} {
+		t.Run(fmt.Sprint(count), func(t *testing.T) {
+			for _, name := range names {
+				owners := 0
+				shards := count
+				if shards < 1 {
+					shards = 1
+				}
+				for i := 0; i < shards; i++ {
+					if (Shard{Index: i, Count: count}).Owns(name) {
+						owners++
+					}
+				}
+				if owners != 1 {
+					t.Errorf("query %s belongs to %d shards", name, owners)
+				}
+			}
+		})
+	}
+}
+
+func TestShardValidate(t *testing.T) {
+	tests := []struct {
+		shard   Shard
+		wantErr bool
+	}{
+		{Shard{}, false},
+		{Shard{Index: 0, Count: 1}, false},
+		{Shard{Index: 2, Count: 3}, false},
+		{Shard{Index: 3, Count: 3}, true},
+		{Shard{Index: -1, Count: 3}, true},
+		{Shard{Count: -1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.shard.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v: validate() = %v, want error %v", tt.shard, err, tt.wantErr)
+		}
+	}
+}
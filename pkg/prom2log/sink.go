@@ -0,0 +1,209 @@
+package prom2log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Sink is a destination for records.
+type Sink interface {
+	Write(ctx context.Context, r Record) error
+	Close() error
+}
+
+// BufferedSink is a sink that buffers records before sending them.
+type BufferedSink interface {
+	Sink
+	// Pending returns the number of buffered records.
+	Pending() int
+}
+
+// SinkFactory builds a Sink from its configuration.
+type SinkFactory func(cfg SinkConfig) (Sink, error)
+
+var (
+	sinkFactoriesMu sync.RWMutex
+	sinkFactories   = map[string]SinkFactory{}
+)
+
+// RegisterSink makes a sink type available to be used in the configuration.
+func RegisterSink(kind string, factory SinkFactory) {
+	sinkFactoriesMu.Lock()
+	defer sinkFactoriesMu.Unlock()
+	sinkFactories[kind] = factory
+}
+
+// SinkTypes returns the names of the registered sink types.
+func SinkTypes() []string {
+	sinkFactoriesMu.RLock()
+	defer sinkFactoriesMu.RUnlock()
+	types := make([]string, 0, len(sinkFactories))
+	for k := range sinkFactories {
+		types = append(types, k)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// SinkConfig holds the configuration of a sink.
+// Type selects the implementation and the remaining fields are passed on to it.
+type SinkConfig struct {
+	Type string `json:"type"`
+
+	raw json.RawMessage
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *SinkConfig) UnmarshalJSON(b []byte) error {
+	var t struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return err
+	}
+	c.Type = t.Type
+	c.raw = append(c.raw[:0], b...)
+	return nil
+}
+
+// Decode decodes the sink specific options into v.
+func (c SinkConfig) Decode(v interface{}) error {
+	if len(c.raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(c.raw, v)
+}
+
+// NewSink builds a sink from its configuration.
+func NewSink(cfg SinkConfig) (Sink, error) {
+	sinkFactoriesMu.RLock()
+	factory, ok := sinkFactories[cfg.Type]
+	sinkFactoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown sink type %q", cfg.Type)
+	}
+	return factory(cfg)
+}
+
+// NewSinks builds all the given sinks, closing the ones already created if any of them fails.
+func NewSinks(cfgs map[string]SinkConfig) (map[string]Sink, error) {
+	sinks := make(map[string]Sink, len(cfgs))
+	for name, cfg := range cfgs {
+		s, err := NewSink(cfg)
+		if err != nil {
+			_ = CloseSinks(sinks)
+			return nil, fmt.Errorf("sink %s: %w", name, err)
+		}
+		sinks[name] = s
+	}
+	return sinks, nil
+}
+
+// CloseSinks closes all the given sinks.
+func CloseSinks(sinks map[string]Sink) error {
+	var errs []error
+	for name, s := range sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// MultiSink fans out records to multiple sinks.
+type MultiSink []Sink
+
+// Write writes the record to all the sinks.
+func (m MultiSink) Write(ctx context.Context, r Record) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Write(ctx, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes all the sinks.
+func (m MultiSink) Close() error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WriterSink writes formatted records to an io.Writer.
+type WriterSink struct {
+	Formatter Formatter
+
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterSink returns a sink that writes to w using the given formatter.
+func NewWriterSink(w io.Writer, f Formatter) *WriterSink {
+	return &WriterSink{
+		Formatter: f,
+		w:         w,
+	}
+}
+
+// Write formats and writes the record.
+func (s *WriterSink) Write(_ context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Formatter.Format(s.w, r)
+}
+
+// Close closes the underlying writer if it's an io.Closer other than stdout or stderr.
+func (s *WriterSink) Close() error {
+	if s.w == os.Stdout || s.w == os.Stderr {
+		return nil
+	}
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// WriterSinkConfig holds the formatting options of the sinks that write to an io.Writer.
+type WriterSinkConfig struct {
+	Pretty bool `json:"pretty"`
+	Colour bool `json:"colour"`
+	// Theme is the style of the coloured output, see Themes.
+	Theme string `json:"theme,omitempty"`
+}
+
+func (c WriterSinkConfig) formatter() Formatter {
+	return Formatter{
+		NoPrettyJSON: !c.Pretty,
+		NoColour:     !c.Colour,
+		Theme:        c.Theme,
+	}
+}
+
+func init() {
+	RegisterSink("stdout", func(cfg SinkConfig) (Sink, error) {
+		var c WriterSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewWriterSink(os.Stdout, c.formatter()), nil
+	})
+	RegisterSink("stderr", func(cfg SinkConfig) (Sink, error) {
+		var c WriterSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewWriterSink(os.Stderr, c.formatter()), nil
+	})
+}
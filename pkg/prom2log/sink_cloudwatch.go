@@ -0,0 +1,240 @@
+package prom2log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// CloudWatchSinkConfig configures a sink that sends records to AWS CloudWatch Logs.
+// Credentials are resolved using the default AWS credential chain
+// (environment, shared config, ECS task role, EC2 instance profile...).
+type CloudWatchSinkConfig struct {
+	Region string `json:"region"`
+	// Profile selects a profile from the shared config files.
+	Profile string `json:"profile"`
+	// RoleARN is an IAM role to assume.
+	RoleARN string `json:"role_arn"`
+	// Endpoint overrides the CloudWatch Logs endpoint.
+	Endpoint string `json:"endpoint"`
+	// LogGroup and LogStream set where the events are sent, {name} is replaced by the query name.
+	// They default to prom2log and {name}.
+	LogGroup  string `json:"log_group"`
+	LogStream string `json:"log_stream"`
+	// CreateLogGroup creates the log groups when they don't exist, log streams are always created.
+	CreateLogGroup bool `json:"create_log_group"`
+
+	BatchConfig
+	RetryConfig
+}
+
+// CloudWatchSink sends records to AWS CloudWatch Logs.
+type CloudWatchSink struct {
+	cfg       CloudWatchSinkConfig
+	client    *cloudwatchlogs.Client
+	formatter Formatter
+	batcher   *batcher
+
+	mu sync.Mutex
+	// tokens holds the next sequence token of each known log stream
+	tokens map[cloudWatchStream]*string
+}
+
+type cloudWatchStream struct {
+	group  string
+	stream string
+}
+
+// NewCloudWatchSink creates a new CloudWatchSink.
+func NewCloudWatchSink(ctx context.Context, cfg CloudWatchSinkConfig) (*CloudWatchSink, error) {
+	if cfg.LogGroup == "" {
+		cfg.LogGroup = "prom2log"
+	}
+	if cfg.LogStream == "" {
+		cfg.LogStream = "{name}"
+	}
+
+	opts := []func(*awsconfig.LoadOptions) error{}
+	if cfg.Region != "" {
+		opts = append(opts, awsconfig.WithRegion(cfg.Region))
+	}
+	if cfg.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(cfg.Profile))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RoleARN != "" {
+		awsCfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), cfg.RoleARN))
+	}
+
+	s := &CloudWatchSink{
+		cfg: cfg,
+		client: cloudwatchlogs.NewFromConfig(awsCfg, func(o *cloudwatchlogs.Options) {
+			// the requests are retried with the RetryConfig, like in the other sinks, rather than by the SDK as well
+			o.RetryMaxAttempts = 1
+			if cfg.Endpoint != "" {
+				o.BaseEndpoint = aws.String(cfg.Endpoint)
+			}
+		}),
+		formatter: sinkFormatter(),
+		tokens:    map[cloudWatchStream]*string{},
+	}
+	batcher, err := newBatcher(cfg.BatchConfig, s.put)
+	if err != nil {
+		return nil, err
+	}
+	s.batcher = batcher
+	return s, nil
+}
+
+// Write queues the record to be sent with the next batch.
+func (s *CloudWatchSink) Write(ctx context.Context, r Record) error {
+	return s.batcher.Add(ctx, r)
+}
+
+// Pending returns the number of records waiting to be put to CloudWatch.
+func (s *CloudWatchSink) Pending() int {
+	return s.batcher.Pending()
+}
+
+// Close sends the pending records.
+func (s *CloudWatchSink) Close() error {
+	return s.batcher.Close()
+}
+
+// put sends the records to their streams, if some of them fail the error is a batchError with their records.
+func (s *CloudWatchSink) put(ctx context.Context, records []Record) error {
+	events := map[cloudWatchStream][]types.InputLogEvent{}
+	streamRecords := map[cloudWatchStream][]Record{}
+	for _, r := range records {
+		var msg bytes.Buffer
+		if err := s.formatter.Format(&msg, r); err != nil {
+			return err
+		}
+		name := strings.NewReplacer("{name}", r.Name)
+		key := cloudWatchStream{
+			group:  name.Replace(s.cfg.LogGroup),
+			stream: name.Replace(s.cfg.LogStream),
+		}
+		events[key] = append(events[key], types.InputLogEvent{
+			Message:   aws.String(strings.TrimRight(msg.String(), "\n")),
+			Timestamp: aws.Int64(r.Time.UnixMilli()),
+		})
+		streamRecords[key] = append(streamRecords[key], r)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var (
+		errs []error
+		be   batchError
+	)
+	for key, evs := range events {
+		// events in a batch must be in chronological order
+		sort.SliceStable(evs, func(i, j int) bool { return *evs[i].Timestamp < *evs[j].Timestamp })
+		err := s.putStream(ctx, key, evs)
+		if err == nil {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("%s/%s: %w", key.group, key.stream, err))
+		// the streams are independent, only the records of the failed ones are sent again or dead lettered
+		var pe *permanentError
+		if errors.As(err, &pe) {
+			be.rejected = append(be.rejected, streamRecords[key]...)
+		} else {
+			be.retry = append(be.retry, streamRecords[key]...)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	be.err = errors.Join(errs...)
+	return &be
+}
+
+func (s *CloudWatchSink) putStream(ctx context.Context, key cloudWatchStream, events []types.InputLogEvent) error {
+	return s.cfg.RetryConfig.Do(ctx, func() error {
+		if _, ok := s.tokens[key]; !ok {
+			if err := s.createStream(ctx, key); err != nil {
+				return err
+			}
+			s.tokens[key] = nil
+		}
+		out, err := s.client.PutLogEvents(ctx, &cloudwatchlogs.PutLogEventsInput{
+			LogGroupName:  aws.String(key.group),
+			LogStreamName: aws.String(key.stream),
+			LogEvents:     events,
+			SequenceToken: s.tokens[key],
+		})
+		if err == nil {
+			s.tokens[key] = out.NextSequenceToken
+			return nil
+		}
+
+		var invalidToken *types.InvalidSequenceTokenException
+		if errors.As(err, &invalidToken) {
+			s.tokens[key] = invalidToken.ExpectedSequenceToken
+			return err
+		}
+		var accepted *types.DataAlreadyAcceptedException
+		if errors.As(err, &accepted) {
+			s.tokens[key] = accepted.ExpectedSequenceToken
+			return nil
+		}
+		var notFound *types.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			// the stream was deleted, create it again on the next attempt
+			delete(s.tokens, key)
+			return err
+		}
+		if retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary {
+			// throttling, server and connection errors
+			return err
+		}
+		return &permanentError{err: err}
+	})
+}
+
+func (s *CloudWatchSink) createStream(ctx context.Context, key cloudWatchStream) error {
+	var exists *types.ResourceAlreadyExistsException
+	if s.cfg.CreateLogGroup {
+		_, err := s.client.CreateLogGroup(ctx, &cloudwatchlogs.CreateLogGroupInput{
+			LogGroupName: aws.String(key.group),
+		})
+		if err != nil && !errors.As(err, &exists) {
+			return err
+		}
+	}
+	_, err := s.client.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(key.group),
+		LogStreamName: aws.String(key.stream),
+	})
+	if err != nil && !errors.As(err, &exists) {
+		return err
+	}
+	return nil
+}
+
+func init() {
+	RegisterSink("cloudwatch", func(cfg SinkConfig) (Sink, error) {
+		var c CloudWatchSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewCloudWatchSink(context.Background(), c)
+	})
+}
@@ -0,0 +1,130 @@
+package prom2log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeCloudWatch answers the CloudWatch Logs API, failing the events put to the streams named after an error type.
+type fakeCloudWatch struct {
+	mu   sync.Mutex
+	puts map[string]int
+}
+
+func (f *fakeCloudWatch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		LogStreamName string
+	}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	switch strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Logs_20140328.") {
+	case "CreateLogStream":
+		fmt.Fprint(w, `{}`)
+	case "PutLogEvents":
+		f.mu.Lock()
+		f.puts[body.LogStreamName]++
+		f.mu.Unlock()
+		if strings.HasSuffix(body.LogStreamName, "Exception") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"__type": %q, "message": "failed"}`, body.LogStreamName)
+			return
+		}
+		fmt.Fprint(w, `{"nextSequenceToken": "1"}`)
+	default:
+		http.Error(w, "unexpected request", http.StatusNotFound)
+	}
+}
+
+func TestCloudWatchSinkPut(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_CONFIG_FILE", "/dev/null")
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", "/dev/null")
+
+	tests := []struct {
+		name         string
+		records      []string
+		wantRetry    []string
+		wantRejected []string
+	}{
+		{name: "sent", records: []string{"a", "b"}},
+		{
+			name:         "rejected stream",
+			records:      []string{"a", "InvalidParameterException", "b"},
+			wantRejected: []string{"InvalidParameterException"},
+		},
+		{
+			name:      "throttled stream",
+			records:   []string{"a", "ThrottlingException"},
+			wantRetry: []string{"ThrottlingException"},
+		},
+		{
+			name:         "both",
+			records:      []string{"ThrottlingException", "a", "InvalidParameterException"},
+			wantRetry:    []string{"ThrottlingException"},
+			wantRejected: []string{"InvalidParameterException"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeCloudWatch{puts: map[string]int{}}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+			s, err := NewCloudWatchSink(context.Background(), CloudWatchSinkConfig{
+				Region:      "us-east-1",
+				Endpoint:    srv.URL,
+				RetryConfig: RetryConfig{MaxRetries: -1},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			records := make([]Record, len(tt.records))
+			for i, name := range tt.records {
+				records[i] = Record{Name: name, Time: time.Unix(1700000000, 0)}
+			}
+			err = s.put(context.Background(), records)
+			if (err != nil) != (len(tt.wantRetry)+len(tt.wantRejected) > 0) {
+				t.Fatalf("put() = %v", err)
+			}
+			retry, rejected := failedRecords(records, err)
+			if err == nil {
+				retry, rejected = nil, nil
+			}
+			if got := recordNames(retry); !equalNames(got, tt.wantRetry) {
+				t.Errorf("got records to retry %v, want %v", got, tt.wantRetry)
+			}
+			if got := recordNames(rejected); !equalNames(got, tt.wantRejected) {
+				t.Errorf("got rejected records %v, want %v", got, tt.wantRejected)
+			}
+			// the SDK doesn't retry on its own
+			for stream, n := range fake.puts {
+				if n != 1 {
+					t.Errorf("got %d requests to %s, want 1", n, stream)
+				}
+			}
+		})
+	}
+}
+
+func recordNames(records []Record) []string {
+	names := make([]string, len(records))
+	for i, r := range records {
+		names[i] = r.Name
+	}
+	sort.Strings(names)
+	return names
+}
+
+func equalNames(got, want []string) bool {
+	return strings.Join(got, ",") == strings.Join(want, ",")
+}
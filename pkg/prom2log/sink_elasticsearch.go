@@ -0,0 +1,228 @@
+package prom2log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ElasticsearchSinkConfig configures a sink that indexes records in Elasticsearch or OpenSearch using the bulk API.
+type ElasticsearchSinkConfig struct {
+	// URL is the base URL of the cluster.
+	URL string `json:"url"`
+	// Index is the name of the index to write to, {name} is replaced by the query name
+	// and {date} by the record date, defaults to prom2log-{name}-{date}.
+	Index string `json:"index"`
+	// DateFormat is the Go time layout used to render {date}, defaults to 2006.01.02.
+	DateFormat string            `json:"date_format"`
+	Username   string            `json:"username"`
+	Password   string            `json:"password"`
+	APIKey     string            `json:"api_key"`
+	Headers    map[string]string `json:"headers"`
+	// Timeout of each bulk request, defaults to 30s.
+	Timeout metav1.Duration `json:"timeout"`
+
+	BatchConfig
+	RetryConfig
+}
+
+// ElasticsearchSink indexes records in Elasticsearch or OpenSearch.
+type ElasticsearchSink struct {
+	cfg       ElasticsearchSinkConfig
+	client    *http.Client
+	formatter Formatter
+	batcher   *batcher
+}
+
+// NewElasticsearchSink creates a new ElasticsearchSink.
+func NewElasticsearchSink(cfg ElasticsearchSinkConfig) (*ElasticsearchSink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("missing url")
+	}
+	if cfg.Index == "" {
+		cfg.Index = "prom2log-{name}-{date}"
+	}
+	if cfg.DateFormat == "" {
+		cfg.DateFormat = "2006.01.02"
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 30 * time.Second
+	}
+	s := &ElasticsearchSink{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.Timeout.Duration},
+		formatter: sinkFormatter(),
+	}
+	batcher, err := newBatcher(cfg.BatchConfig, s.bulk)
+	if err != nil {
+		return nil, err
+	}
+	s.batcher = batcher
+	return s, nil
+}
+
+// Write queues the record to be indexed with the next batch.
+func (s *ElasticsearchSink) Write(ctx context.Context, r Record) error {
+	return s.batcher.Add(ctx, r)
+}
+
+// Pending returns the number of records waiting to be indexed.
+func (s *ElasticsearchSink) Pending() int {
+	return s.batcher.Pending()
+}
+
+// Close indexes the pending records.
+func (s *ElasticsearchSink) Close() error {
+	return s.batcher.Close()
+}
+
+func (s *ElasticsearchSink) index(r Record) string {
+	return strings.NewReplacer(
+		"{name}", strings.ToLower(r.Name),
+		"{date}", r.Time.UTC().Format(s.cfg.DateFormat),
+	).Replace(s.cfg.Index)
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// bulk indexes the records, retrying the documents rejected due to back pressure. If some documents still fail
+// the error is a batchError with their records.
+func (s *ElasticsearchSink) bulk(ctx context.Context, records []Record) error {
+	type doc struct {
+		record Record
+		action []byte
+		source []byte
+	}
+	pending := make([]doc, 0, len(records))
+	for _, r := range records {
+		var line bytes.Buffer
+		// the source of a document must be a JSON object
+		if err := s.formatter.Format(&line, asJSON(r)); err != nil {
+			return err
+		}
+		action, err := json.Marshal(map[string]map[string]string{"index": {"_index": s.index(r)}})
+		if err != nil {
+			return err
+		}
+		pending = append(pending, doc{record: r, action: action, source: bytes.TrimRight(line.Bytes(), "\n")})
+	}
+
+	var (
+		rejected []Record
+		// rejectErr is the error of the first rejected document
+		rejectErr error
+	)
+	err := s.cfg.RetryConfig.Do(ctx, func() error {
+		var body bytes.Buffer
+		for _, d := range pending {
+			body.Write(d.action)
+			body.WriteByte('\n')
+			body.Write(d.source)
+			body.WriteByte('\n')
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.cfg.URL, "/")+"/_bulk", &body)
+		if err != nil {
+			return &permanentError{err: err}
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		for k, v := range s.cfg.Headers {
+			req.Header.Set(k, v)
+		}
+		if s.cfg.APIKey != "" {
+			req.Header.Set("Authorization", "ApiKey "+s.cfg.APIKey)
+		} else if s.cfg.Username != "" {
+			req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if err := statusError(resp, b); err != nil {
+			return err
+		}
+
+		var res bulkResponse
+		if err := json.Unmarshal(b, &res); err != nil {
+			return &permanentError{err: fmt.Errorf("invalid bulk response: %w", err)}
+		}
+		if !res.Errors {
+			pending = nil
+			return nil
+		}
+		// keep only the documents rejected due to back pressure for the next attempt, the others failed for good
+		var retry []doc
+		for i, item := range res.Items {
+			if i >= len(pending) {
+				break
+			}
+			for _, status := range item {
+				switch {
+				case status.Status == http.StatusTooManyRequests:
+					retry = append(retry, pending[i])
+				case status.Status >= 300:
+					rejected = append(rejected, pending[i].record)
+					if rejectErr == nil {
+						rejectErr = fmt.Errorf("failed to index document: %s", status.Error)
+					}
+				}
+			}
+		}
+		pending = retry
+		if len(retry) > 0 {
+			return &retryableError{err: fmt.Errorf("%d documents rejected", len(retry))}
+		}
+		return nil
+	})
+	if err == nil && len(rejected) == 0 {
+		return nil
+	}
+
+	be := &batchError{rejected: rejected}
+	if len(rejected) > 0 {
+		rejectErr = fmt.Errorf("%d documents: %w", len(rejected), rejectErr)
+	}
+	if err != nil {
+		// the documents still pending are the ones the last attempt failed to send
+		var pe *permanentError
+		for _, d := range pending {
+			if errors.As(err, &pe) {
+				be.rejected = append(be.rejected, d.record)
+			} else {
+				be.retry = append(be.retry, d.record)
+			}
+		}
+	}
+	be.err = errors.Join(err, rejectErr)
+	return be
+}
+
+func init() {
+	factory := func(cfg SinkConfig) (Sink, error) {
+		var c ElasticsearchSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewElasticsearchSink(c)
+	}
+	RegisterSink("elasticsearch", factory)
+	RegisterSink("opensearch", factory)
+}
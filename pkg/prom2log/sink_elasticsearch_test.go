@@ -0,0 +1,134 @@
+package prom2log
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeBulk answers the bulk API, rejecting the documents of the records named reject and the ones named busy
+// the first busy times.
+type fakeBulk struct {
+	busy int
+
+	mu      sync.Mutex
+	indexed []string
+	seen    map[string]int
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var items []string
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		// skip the action
+		if !scanner.Scan() {
+			break
+		}
+		var source struct {
+			Name string `json:"name"`
+		}
+		_ = json.Unmarshal(scanner.Bytes(), &source)
+		f.seen[source.Name]++
+		status := http.StatusCreated
+		switch {
+		case source.Name == "reject":
+			status = http.StatusBadRequest
+		case source.Name == "busy" && f.seen[source.Name] <= f.busy:
+			status = http.StatusTooManyRequests
+		default:
+			f.indexed = append(f.indexed, source.Name)
+		}
+		items = append(items, fmt.Sprintf(`{"index": {"status": %d, "error": {"type": "test"}}}`, status))
+	}
+	fmt.Fprintf(w, `{"errors": true, "items": [%s]}`, strings.Join(items, ","))
+}
+
+func TestElasticsearchSinkBulk(t *testing.T) {
+	tests := []struct {
+		name         string
+		records      []string
+		busy         int
+		retries      int
+		wantIndexed  []string
+		wantRetry    []string
+		wantRejected []string
+	}{
+		{name: "indexed", records: []string{"a", "b"}, retries: -1, wantIndexed: []string{"a", "b"}},
+		{
+			name:        "back pressure retried",
+			records:     []string{"a", "busy"},
+			busy:        1,
+			retries:     1,
+			wantIndexed: []string{"a", "busy"},
+		},
+		{
+			name:         "rejected",
+			records:      []string{"a", "reject"},
+			retries:      1,
+			wantIndexed:  []string{"a"},
+			wantRejected: []string{"reject"},
+		},
+		{
+			name:         "rejected and back pressure",
+			records:      []string{"busy", "a", "reject"},
+			busy:         2,
+			retries:      1,
+			wantIndexed:  []string{"a"},
+			wantRetry:    []string{"busy"},
+			wantRejected: []string{"reject"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeBulk{busy: tt.busy, seen: map[string]int{}}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+			s, err := NewElasticsearchSink(ElasticsearchSinkConfig{
+				URL:         srv.URL,
+				RetryConfig: RetryConfig{MaxRetries: tt.retries, MinBackoff: metav1.Duration{Duration: time.Millisecond}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			records := make([]Record, len(tt.records))
+			for i, name := range tt.records {
+				records[i] = Record{Name: name, Time: time.Unix(1700000000, 0)}
+			}
+			err = s.bulk(context.Background(), records)
+			if (err != nil) != (len(tt.wantRetry)+len(tt.wantRejected) > 0) {
+				t.Fatalf("bulk() = %v", err)
+			}
+			var retry, rejected []Record
+			if err != nil {
+				retry, rejected = failedRecords(records, err)
+			}
+			if got := recordNames(retry); !equalNames(got, tt.wantRetry) {
+				t.Errorf("got records to retry %v, want %v", got, tt.wantRetry)
+			}
+			if got := recordNames(rejected); !equalNames(got, tt.wantRejected) {
+				t.Errorf("got rejected records %v, want %v", got, tt.wantRejected)
+			}
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if got := recordNames(namedRecords(fake.indexed...)); !equalNames(got, tt.wantIndexed) {
+				t.Errorf("got indexed documents %v, want %v", got, tt.wantIndexed)
+			}
+			if fake.seen["a"] != 1 {
+				t.Errorf("indexed a %d times, want once", fake.seen["a"])
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package prom2log
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FileSinkConfig configures a sink that writes records to a file with rotation.
+type FileSinkConfig struct {
+	Path string `json:"path"`
+	// MaxSize is the maximum size in megabytes of the file before it gets rotated, defaults to 100.
+	MaxSize int `json:"max_size"`
+	// MaxAge is the maximum number of days to retain old files, 0 keeps them forever.
+	MaxAge int `json:"max_age"`
+	// MaxBackups is the maximum number of old files to retain, 0 keeps all of them.
+	MaxBackups int `json:"max_backups"`
+	// Compress enables gzip compression of the rotated files.
+	Compress bool `json:"compress"`
+	// LocalTime uses the local time instead of UTC in the rotated files names.
+	LocalTime bool `json:"local_time"`
+	// RotateEvery rotates the file on a fixed interval, regardless of its size.
+	RotateEvery metav1.Duration `json:"rotate_every"`
+
+	WriterSinkConfig
+}
+
+// FileSink writes records to a file, rotating it based on its size and age.
+type FileSink struct {
+	*WriterSink
+
+	logger *lumberjack.Logger
+	done   chan struct{}
+	close  sync.Once
+}
+
+// NewFileSink creates a new FileSink.
+func NewFileSink(cfg FileSinkConfig) (*FileSink, error) {
+	if cfg.Path == "" {
+		return nil, errors.New("missing path")
+	}
+	logger := &lumberjack.Logger{
+		Filename:   cfg.Path,
+		MaxSize:    cfg.MaxSize,
+		MaxAge:     cfg.MaxAge,
+		MaxBackups: cfg.MaxBackups,
+		Compress:   cfg.Compress,
+		LocalTime:  cfg.LocalTime,
+	}
+	s := &FileSink{
+		WriterSink: NewWriterSink(logger, cfg.formatter()),
+		logger:     logger,
+		done:       make(chan struct{}),
+	}
+	if cfg.RotateEvery.Duration > 0 {
+		go s.rotate(cfg.RotateEvery.Duration)
+	}
+	return s, nil
+}
+
+func (s *FileSink) rotate(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			_ = s.logger.Rotate()
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Close stops the rotation and closes the file, it can be called more than once.
+func (s *FileSink) Close() error {
+	s.close.Do(func() { close(s.done) })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logger.Close()
+}
+
+func init() {
+	RegisterSink("file", func(cfg SinkConfig) (Sink, error) {
+		var c FileSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewFileSink(c)
+	})
+}
@@ -0,0 +1,265 @@
+package prom2log
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FluentSinkConfig configures a sink that sends records to fluentd or fluent-bit using the forward protocol.
+type FluentSinkConfig struct {
+	// Address is the host:port of the forward input, defaults to localhost:24224.
+	Address string `json:"address"`
+	// TagPrefix is prepended to the query name to build the event tag, defaults to prom2log.
+	TagPrefix string `json:"tag_prefix"`
+	// RequireAck waits for the server to acknowledge each chunk.
+	RequireAck bool       `json:"require_ack"`
+	TLS        *TLSConfig `json:"tls"`
+	// Timeout of the connection, writes and acknowledgements, defaults to 10s.
+	Timeout metav1.Duration `json:"timeout"`
+
+	BatchConfig
+	RetryConfig
+}
+
+// FluentSink sends records to fluentd or fluent-bit using the forward protocol.
+type FluentSink struct {
+	cfg       FluentSinkConfig
+	tlsConfig *tls.Config
+	formatter Formatter
+	batcher   *batcher
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewFluentSink creates a new FluentSink.
+func NewFluentSink(cfg FluentSinkConfig) (*FluentSink, error) {
+	if cfg.Address == "" {
+		cfg.Address = "localhost:24224"
+	}
+	if cfg.TagPrefix == "" {
+		cfg.TagPrefix = "prom2log"
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 10 * time.Second
+	}
+	s := &FluentSink{
+		cfg:       cfg,
+		formatter: sinkFormatter(),
+	}
+	if cfg.TLS != nil {
+		tlsConfig, err := cfg.TLS.Build()
+		if err != nil {
+			return nil, err
+		}
+		s.tlsConfig = tlsConfig
+	}
+	batcher, err := newBatcher(cfg.BatchConfig, s.forward)
+	if err != nil {
+		return nil, err
+	}
+	s.batcher = batcher
+	return s, nil
+}
+
+// Write queues the record to be sent with the next batch.
+func (s *FluentSink) Write(ctx context.Context, r Record) error {
+	return s.batcher.Add(ctx, r)
+}
+
+// Pending returns the number of records waiting to be forwarded.
+func (s *FluentSink) Pending() int {
+	return s.batcher.Pending()
+}
+
+// Close sends the pending records and closes the connection.
+func (s *FluentSink) Close() error {
+	err := s.batcher.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		err = errors.Join(err, s.conn.Close())
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *FluentSink) connect() error {
+	dialer := &net.Dialer{Timeout: s.cfg.Timeout.Duration}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if s.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.cfg.Address, s.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", s.cfg.Address)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// encodeEventTime writes t using the EventTime extension of the forward protocol.
+func encodeEventTime(enc *msgpack.Encoder, t time.Time) error {
+	if err := enc.EncodeExtHeader(0, 8); err != nil {
+		return err
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	_, err := enc.Writer().Write(b[:])
+	return err
+}
+
+// forward sends the records in Forward mode, one message per tag.
+func (s *FluentSink) forward(ctx context.Context, records []Record) error {
+	var tags []string
+	entries := map[string][]Record{}
+	for _, r := range records {
+		tag := s.cfg.TagPrefix + "." + r.Name
+		if _, ok := entries[tag]; !ok {
+			tags = append(tags, tag)
+		}
+		entries[tag] = append(entries[tag], r)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, tag := range tags {
+		msg, chunk, err := s.message(tag, entries[tag])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := s.cfg.RetryConfig.Do(ctx, func() error { return s.send(msg, chunk) }); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", tag, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *FluentSink) message(tag string, records []Record) ([]byte, string, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	optionLen := 1
+	if s.cfg.RequireAck {
+		optionLen = 2
+	}
+	if err := enc.EncodeArrayLen(3); err != nil {
+		return nil, "", err
+	}
+	if err := enc.EncodeString(tag); err != nil {
+		return nil, "", err
+	}
+	if err := enc.EncodeArrayLen(len(records)); err != nil {
+		return nil, "", err
+	}
+	for _, r := range records {
+		var line bytes.Buffer
+		if err := s.formatter.Format(&line, asJSON(r)); err != nil {
+			return nil, "", err
+		}
+		var record map[string]interface{}
+		if err := json.Unmarshal(line.Bytes(), &record); err != nil {
+			return nil, "", err
+		}
+		if err := enc.EncodeArrayLen(2); err != nil {
+			return nil, "", err
+		}
+		if err := encodeEventTime(enc, r.Time); err != nil {
+			return nil, "", err
+		}
+		if err := enc.Encode(record); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := enc.EncodeMapLen(optionLen); err != nil {
+		return nil, "", err
+	}
+	if err := enc.EncodeString("size"); err != nil {
+		return nil, "", err
+	}
+	if err := enc.EncodeInt(int64(len(records))); err != nil {
+		return nil, "", err
+	}
+	var chunk string
+	if s.cfg.RequireAck {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, "", err
+		}
+		chunk = base64.StdEncoding.EncodeToString(id)
+		if err := enc.EncodeString("chunk"); err != nil {
+			return nil, "", err
+		}
+		if err := enc.EncodeString(chunk); err != nil {
+			return nil, "", err
+		}
+	}
+	return buf.Bytes(), chunk, nil
+}
+
+func (s *FluentSink) send(msg []byte, chunk string) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+	err := s.write(msg, chunk)
+	if err != nil {
+		// drop the connection so the next attempt reconnects
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *FluentSink) write(msg []byte, chunk string) error {
+	if err := s.conn.SetDeadline(time.Now().Add(s.cfg.Timeout.Duration)); err != nil {
+		return err
+	}
+	if _, err := s.conn.Write(msg); err != nil {
+		return err
+	}
+	if chunk == "" {
+		return nil
+	}
+	var resp struct {
+		Ack string `msgpack:"ack"`
+	}
+	if err := msgpack.NewDecoder(s.conn).Decode(&resp); err != nil {
+		return fmt.Errorf("reading ack: %w", err)
+	}
+	if resp.Ack != chunk {
+		return fmt.Errorf("unexpected ack %q, expecting %q", resp.Ack, chunk)
+	}
+	return nil
+}
+
+func init() {
+	RegisterSink("fluent", func(cfg SinkConfig) (Sink, error) {
+		var c FluentSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewFluentSink(c)
+	})
+}
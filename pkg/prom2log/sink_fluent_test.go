@@ -0,0 +1,163 @@
+package prom2log
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeForward is a forward protocol input, dropping the connection instead of acknowledging the first drop messages.
+type fakeForward struct {
+	ln   net.Listener
+	drop int
+
+	mu     sync.Mutex
+	conns  int
+	events []string
+}
+
+func newFakeForward(t *testing.T, drop int) *fakeForward {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeForward{ln: ln, drop: drop}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			f.mu.Lock()
+			f.conns++
+			f.mu.Unlock()
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { _ = ln.Close() })
+	return f
+}
+
+func (f *fakeForward) serve(conn net.Conn) {
+	defer conn.Close()
+	dec := msgpack.NewDecoder(conn)
+	for {
+		events, chunk, err := decodeForward(dec)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		drop := f.drop > 0
+		if drop {
+			f.drop--
+		} else {
+			f.events = append(f.events, events...)
+		}
+		f.mu.Unlock()
+		if drop {
+			return
+		}
+		if chunk != "" {
+			if err := msgpack.NewEncoder(conn).Encode(map[string]string{"ack": chunk}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// decodeForward decodes a Forward mode message, returning its events as tag@unix seconds:name and its chunk id.
+func decodeForward(dec *msgpack.Decoder) ([]string, string, error) {
+	if _, err := dec.DecodeArrayLen(); err != nil {
+		return nil, "", err
+	}
+	tag, err := dec.DecodeString()
+	if err != nil {
+		return nil, "", err
+	}
+	n, err := dec.DecodeArrayLen()
+	if err != nil {
+		return nil, "", err
+	}
+	events := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		if _, err := dec.DecodeArrayLen(); err != nil {
+			return nil, "", err
+		}
+		id, size, err := dec.DecodeExtHeader()
+		if err != nil {
+			return nil, "", err
+		}
+		if id != 0 || size != 8 {
+			return nil, "", fmt.Errorf("unexpected ext %d of %d bytes", id, size)
+		}
+		b := make([]byte, size)
+		if err := dec.ReadFull(b); err != nil {
+			return nil, "", err
+		}
+		record, err := dec.DecodeMap()
+		if err != nil {
+			return nil, "", err
+		}
+		events = append(events, fmt.Sprintf("%s@%d:%v", tag, binary.BigEndian.Uint32(b[:4]), record["name"]))
+	}
+	options, err := dec.DecodeMap()
+	if err != nil {
+		return nil, "", err
+	}
+	chunk, _ := options["chunk"].(string)
+	return events, chunk, nil
+}
+
+func TestFluentSinkForward(t *testing.T) {
+	tests := []struct {
+		name      string
+		ack       bool
+		drop      int
+		wantConns int
+	}{
+		{name: "forward", wantConns: 1},
+		{name: "acknowledged", ack: true, wantConns: 1},
+		{name: "reconnect after a lost ack", ack: true, drop: 1, wantConns: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeForward(t, tt.drop)
+			s, err := NewFluentSink(FluentSinkConfig{
+				Address:     fake.ln.Addr().String(),
+				RequireAck:  tt.ack,
+				Timeout:     metav1.Duration{Duration: 5 * time.Second},
+				RetryConfig: RetryConfig{MaxRetries: 1, MinBackoff: metav1.Duration{Duration: time.Millisecond}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			ts := time.Unix(1700000000, 0)
+			records := []Record{{Name: "a", Time: ts}, {Name: "b", Time: ts}, {Name: "a", Time: ts.Add(time.Second)}}
+			if err := s.forward(context.Background(), records); err != nil {
+				t.Fatal(err)
+			}
+			// without acks the last events may not have been read yet
+			want := "[prom2log.a@1700000000:a prom2log.a@1700000001:a prom2log.b@1700000000:b]"
+			waitFor(t, "the events", func() bool {
+				fake.mu.Lock()
+				defer fake.mu.Unlock()
+				return fmt.Sprint(fake.events) == want
+			})
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if fake.conns != tt.wantConns {
+				t.Errorf("got %d connections, want %d", fake.conns, tt.wantConns)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package prom2log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/journal"
+)
+
+// JournaldSinkConfig configures a sink that sends records to systemd-journald.
+type JournaldSinkConfig struct {
+	// Priority is used for successful results, defaults to info, errors are always logged as err.
+	Priority string `json:"priority"`
+	// Identifier sets the SYSLOG_IDENTIFIER field, defaults to prom2log.
+	Identifier string `json:"identifier"`
+}
+
+// JournaldSink sends records to systemd-journald.
+type JournaldSink struct {
+	priority   journal.Priority
+	identifier string
+	formatter  Formatter
+}
+
+// NewJournaldSink creates a new JournaldSink.
+func NewJournaldSink(cfg JournaldSinkConfig) (*JournaldSink, error) {
+	if !journal.Enabled() {
+		return nil, errors.New("journald is not available")
+	}
+	if cfg.Priority == "" {
+		cfg.Priority = "info"
+	}
+	if cfg.Identifier == "" {
+		cfg.Identifier = "prom2log"
+	}
+	priority, ok := syslogSeverities[strings.ToLower(cfg.Priority)]
+	if !ok {
+		return nil, errors.New("unknown journald priority " + cfg.Priority)
+	}
+	return &JournaldSink{
+		priority:   journal.Priority(priority),
+		identifier: cfg.Identifier,
+		formatter:  sinkFormatter(),
+	}, nil
+}
+
+// Write sends the record to journald, the query name is set in the QUERY_NAME field.
+func (s *JournaldSink) Write(_ context.Context, r Record) error {
+	var buf bytes.Buffer
+	if err := s.formatter.Format(&buf, r); err != nil {
+		return err
+	}
+	priority := s.priority
+	switch {
+	case r.Err != nil:
+		priority = journal.PriErr
+	case r.Severity() == SeverityCritical:
+		priority = journal.PriCrit
+	case r.Severity() == SeverityWarning:
+		priority = journal.PriWarning
+	}
+	return journal.Send(strings.TrimRight(buf.String(), "\n"), priority, map[string]string{
+		"SYSLOG_IDENTIFIER": s.identifier,
+		"QUERY_NAME":        r.Name,
+	})
+}
+
+// Close is a no-op, journald messages are sent synchronously.
+func (s *JournaldSink) Close() error {
+	return nil
+}
+
+func init() {
+	RegisterSink("journald", func(cfg SinkConfig) (Sink, error) {
+		var c JournaldSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewJournaldSink(c)
+	})
+}
@@ -0,0 +1,175 @@
+package prom2log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// KafkaSinkConfig configures a sink that produces records to Kafka.
+type KafkaSinkConfig struct {
+	Brokers []string `json:"brokers"`
+	// Topic is the topic to produce to, {name} is replaced by the query name, defaults to prom2log-{name}.
+	Topic string `json:"topic"`
+	// Compression is one of gzip, snappy, lz4 or zstd, disabled by default.
+	Compression string `json:"compression"`
+	// RequiredAcks is the number of acknowledgements required for each write, -1 means all replicas, defaults to 1.
+	RequiredAcks           int  `json:"required_acks"`
+	AllowAutoTopicCreation bool `json:"allow_auto_topic_creation"`
+	SASL                   *struct {
+		// Mechanism is one of plain, scram-sha-256 or scram-sha-512.
+		Mechanism string `json:"mechanism"`
+		Username  string `json:"username"`
+		Password  string `json:"password"`
+	} `json:"sasl"`
+	TLS *TLSConfig `json:"tls"`
+
+	BatchConfig
+	RetryConfig
+}
+
+// KafkaSink produces records to Kafka using the query name as the message key.
+type KafkaSink struct {
+	topic     string
+	writer    *kafka.Writer
+	formatter Formatter
+	batcher   *batcher
+}
+
+// NewKafkaSink creates a new KafkaSink.
+func NewKafkaSink(cfg KafkaSinkConfig) (*KafkaSink, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("missing brokers")
+	}
+	if cfg.Topic == "" {
+		cfg.Topic = "prom2log-{name}"
+	}
+	if cfg.RequiredAcks == 0 {
+		cfg.RequiredAcks = 1
+	}
+
+	transport := &kafka.Transport{}
+	if cfg.TLS != nil {
+		tlsConfig, err := cfg.TLS.Build()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLS = tlsConfig
+	}
+	if cfg.SASL != nil {
+		var (
+			mechanism sasl.Mechanism
+			err       error
+		)
+		switch strings.ToLower(cfg.SASL.Mechanism) {
+		case "", "plain":
+			mechanism = plain.Mechanism{Username: cfg.SASL.Username, Password: cfg.SASL.Password}
+		case "scram-sha-256":
+			mechanism, err = scram.Mechanism(scram.SHA256, cfg.SASL.Username, cfg.SASL.Password)
+		case "scram-sha-512":
+			mechanism, err = scram.Mechanism(scram.SHA512, cfg.SASL.Username, cfg.SASL.Password)
+		default:
+			err = fmt.Errorf("unknown SASL mechanism %q", cfg.SASL.Mechanism)
+		}
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+
+	var compression kafka.Compression
+	switch strings.ToLower(cfg.Compression) {
+	case "", "none":
+	case "gzip":
+		compression = kafka.Gzip
+	case "snappy":
+		compression = kafka.Snappy
+	case "lz4":
+		compression = kafka.Lz4
+	case "zstd":
+		compression = kafka.Zstd
+	default:
+		return nil, fmt.Errorf("unknown compression %q", cfg.Compression)
+	}
+
+	maxAttempts := cfg.MaxRetries + 1
+	if cfg.MaxRetries == 0 {
+		maxAttempts = 4
+	} else if cfg.MaxRetries < 0 {
+		maxAttempts = 1
+	}
+
+	s := &KafkaSink{
+		topic: cfg.Topic,
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(cfg.Brokers...),
+			Balancer:               &kafka.Hash{},
+			Transport:              transport,
+			Compression:            compression,
+			RequiredAcks:           kafka.RequiredAcks(cfg.RequiredAcks),
+			AllowAutoTopicCreation: cfg.AllowAutoTopicCreation,
+			MaxAttempts:            maxAttempts,
+			WriteBackoffMin:        cfg.MinBackoff.Duration,
+			WriteBackoffMax:        cfg.MaxBackoff.Duration,
+			// batching is done by the sink, so don't wait for more messages
+			BatchTimeout: 10 * time.Millisecond,
+		},
+		formatter: sinkFormatter(),
+	}
+	batcher, err := newBatcher(cfg.BatchConfig, s.produce)
+	if err != nil {
+		return nil, err
+	}
+	s.batcher = batcher
+	return s, nil
+}
+
+// Write queues the record to be produced with the next batch.
+func (s *KafkaSink) Write(ctx context.Context, r Record) error {
+	return s.batcher.Add(ctx, r)
+}
+
+// Pending returns the number of records waiting to be produced.
+func (s *KafkaSink) Pending() int {
+	return s.batcher.Pending()
+}
+
+// Close produces the pending records and closes the producer.
+func (s *KafkaSink) Close() error {
+	return errors.Join(s.batcher.Close(), s.writer.Close())
+}
+
+func (s *KafkaSink) produce(ctx context.Context, records []Record) error {
+	msgs := make([]kafka.Message, 0, len(records))
+	for _, r := range records {
+		var value bytes.Buffer
+		if err := s.formatter.Format(&value, r); err != nil {
+			return err
+		}
+		msgs = append(msgs, kafka.Message{
+			Topic: strings.ReplaceAll(s.topic, "{name}", r.Name),
+			Key:   []byte(r.Name),
+			Value: bytes.TrimRight(value.Bytes(), "\n"),
+			Time:  r.Time,
+		})
+	}
+	return s.writer.WriteMessages(ctx, msgs...)
+}
+
+func init() {
+	RegisterSink("kafka", func(cfg SinkConfig) (Sink, error) {
+		var c KafkaSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewKafkaSink(c)
+	})
+}
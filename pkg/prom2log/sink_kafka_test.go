@@ -0,0 +1,49 @@
+package prom2log
+
+import (
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestNewKafkaSink(t *testing.T) {
+	tests := []struct {
+		name            string
+		cfg             KafkaSinkConfig
+		wantErr         bool
+		wantAttempts    int
+		wantCompression kafka.Compression
+	}{
+		{name: "defaults", cfg: KafkaSinkConfig{Brokers: []string{"localhost:9092"}}, wantAttempts: 4},
+		{name: "no retries", cfg: KafkaSinkConfig{Brokers: []string{"localhost:9092"}, RetryConfig: RetryConfig{MaxRetries: -1}}, wantAttempts: 1},
+		{
+			name:            "compression",
+			cfg:             KafkaSinkConfig{Brokers: []string{"localhost:9092"}, Compression: "ZSTD", RetryConfig: RetryConfig{MaxRetries: 2}},
+			wantAttempts:    3,
+			wantCompression: kafka.Zstd,
+		},
+		{name: "no brokers", wantErr: true},
+		{name: "unknown compression", cfg: KafkaSinkConfig{Brokers: []string{"localhost:9092"}, Compression: "brotli"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewKafkaSink(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewKafkaSink() = %v", err)
+			}
+			if err != nil {
+				return
+			}
+			defer s.Close()
+			if s.topic != "prom2log-{name}" {
+				t.Errorf("got topic %q, want the default one", s.topic)
+			}
+			if s.writer.MaxAttempts != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", s.writer.MaxAttempts, tt.wantAttempts)
+			}
+			if s.writer.Compression != tt.wantCompression {
+				t.Errorf("got compression %v, want %v", s.writer.Compression, tt.wantCompression)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package prom2log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const lokiPushPath = "/loki/api/v1/push"
+
+// LokiSinkConfig configures a sink that pushes records to Grafana Loki.
+type LokiSinkConfig struct {
+	// URL is the base URL of the Loki server.
+	URL      string            `json:"url"`
+	TenantID string            `json:"tenant_id"`
+	Username string            `json:"username"`
+	Password string            `json:"password"`
+	Headers  map[string]string `json:"headers"`
+	// Labels are static labels added to all the streams.
+	Labels map[string]string `json:"labels"`
+	// ResultLabels are labels copied from the query result into the stream labels,
+	// a label is only copied when it has the same value in all the series of the result.
+	ResultLabels []string `json:"result_labels"`
+	// Timeout of each push request, defaults to 10s.
+	Timeout metav1.Duration `json:"timeout"`
+
+	BatchConfig
+	RetryConfig
+}
+
+// LokiSink pushes records to Grafana Loki.
+type LokiSink struct {
+	cfg       LokiSinkConfig
+	client    *http.Client
+	formatter Formatter
+	batcher   *batcher
+}
+
+// NewLokiSink creates a new LokiSink.
+func NewLokiSink(cfg LokiSinkConfig) (*LokiSink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("missing url")
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 10 * time.Second
+	}
+	s := &LokiSink{
+		cfg:       cfg,
+		client:    &http.Client{Timeout: cfg.Timeout.Duration},
+		formatter: sinkFormatter(),
+	}
+	batcher, err := newBatcher(cfg.BatchConfig, s.push)
+	if err != nil {
+		return nil, err
+	}
+	s.batcher = batcher
+	return s, nil
+}
+
+// Write queues the record to be pushed with the next batch.
+func (s *LokiSink) Write(ctx context.Context, r Record) error {
+	return s.batcher.Add(ctx, r)
+}
+
+// Pending returns the number of records waiting to be pushed.
+func (s *LokiSink) Pending() int {
+	return s.batcher.Pending()
+}
+
+// Close pushes the pending records.
+func (s *LokiSink) Close() error {
+	return s.batcher.Close()
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func (s *LokiSink) streamLabels(r Record) map[string]string {
+	labels := map[string]string{"query": r.Name}
+	for k, v := range r.commonLabels(s.cfg.ResultLabels) {
+		labels[k] = v
+	}
+	for k, v := range s.cfg.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+func streamKey(labels map[string]string) string {
+	var sb strings.Builder
+	for _, k := range sortedKeys(labels) {
+		sb.WriteString(k)
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(labels[k]))
+		sb.WriteByte(',')
+	}
+	return sb.String()
+}
+
+func (s *LokiSink) push(ctx context.Context, records []Record) error {
+	streams := map[string]*lokiStream{}
+	var keys []string
+	for _, r := range records {
+		var line bytes.Buffer
+		if err := s.formatter.Format(&line, r); err != nil {
+			return err
+		}
+		labels := s.streamLabels(r)
+		key := streamKey(labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			keys = append(keys, key)
+		}
+		stream.Values = append(stream.Values, [2]string{
+			strconv.FormatInt(r.Time.UnixNano(), 10),
+			strings.TrimRight(line.String(), "\n"),
+		})
+	}
+
+	payload := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, k := range keys {
+		payload.Streams = append(payload.Streams, streams[k])
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return s.cfg.RetryConfig.Do(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.cfg.URL, "/")+lokiPushPath, bytes.NewReader(body))
+		if err != nil {
+			return &permanentError{err: err}
+		}
+		req.Header.Set("Content-Type", "application/json")
+		for k, v := range s.cfg.Headers {
+			req.Header.Set(k, v)
+		}
+		if s.cfg.TenantID != "" {
+			req.Header.Set("X-Scope-OrgID", s.cfg.TenantID)
+		}
+		if s.cfg.Username != "" {
+			req.SetBasicAuth(s.cfg.Username, s.cfg.Password)
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return statusError(resp, b)
+	})
+}
+
+func init() {
+	RegisterSink("loki", func(cfg SinkConfig) (Sink, error) {
+		var c LokiSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewLokiSink(c)
+	})
+}
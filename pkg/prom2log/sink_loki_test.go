@@ -0,0 +1,107 @@
+package prom2log
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeLoki answers the push API with the given statuses, then with 204, keeping the pushed streams.
+type fakeLoki struct {
+	statuses []int
+
+	mu       sync.Mutex
+	requests int
+	tenant   string
+	streams  []lokiStream
+}
+
+func (f *fakeLoki) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if len(f.statuses) > 0 {
+		status := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	if r.URL.Path != lokiPushPath {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	f.tenant = r.Header.Get("X-Scope-OrgID")
+	var payload struct {
+		Streams []lokiStream `json:"streams"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.streams = append(f.streams, payload.Streams...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestLokiSinkPush(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "pushed", wantRequests: 1},
+		{name: "server error retried", statuses: []int{http.StatusServiceUnavailable}, wantRequests: 2},
+		{name: "bad request not retried", statuses: []int{http.StatusBadRequest}, wantErr: true, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeLoki{statuses: tt.statuses}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+			s, err := NewLokiSink(LokiSinkConfig{
+				URL:         srv.URL + "/",
+				TenantID:    "team",
+				Labels:      map[string]string{"app": "prom2log"},
+				RetryConfig: RetryConfig{MaxRetries: 1, MinBackoff: metav1.Duration{Duration: time.Millisecond}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			ts := time.Unix(1700000000, 0)
+			err = s.push(context.Background(), []Record{{Name: "a", Time: ts}, {Name: "b", Time: ts}, {Name: "a", Time: ts.Add(time.Second)}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("push() = %v", err)
+			}
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if fake.requests != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", fake.requests, tt.wantRequests)
+			}
+			if tt.wantErr {
+				return
+			}
+			if fake.tenant != "team" {
+				t.Errorf("got tenant %q, want team", fake.tenant)
+			}
+			// the records of the same query go to the same stream, in order
+			if len(fake.streams) != 2 {
+				t.Fatalf("got %d streams, want 2", len(fake.streams))
+			}
+			a := fake.streams[0]
+			if a.Stream["query"] != "a" || a.Stream["app"] != "prom2log" {
+				t.Errorf("got stream labels %v, want the query and the static labels", a.Stream)
+			}
+			if len(a.Values) != 2 || a.Values[0][0] != "1700000000000000000" || a.Values[1][0] != "1700000001000000000" {
+				t.Errorf("got values %v, want the records of a in order", a.Values)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package prom2log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the gzip compressor
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OTLPSinkConfig configures a sink that exports records as OpenTelemetry logs.
+type OTLPSinkConfig struct {
+	// Protocol is either grpc or http (OTLP/HTTP with protobuf encoding), defaults to grpc.
+	Protocol string `json:"protocol"`
+	// Endpoint is the host:port of the collector for grpc or its base URL for http,
+	// defaults to localhost:4317 and http://localhost:4318 respectively.
+	Endpoint string `json:"endpoint"`
+	// Insecure disables TLS for grpc.
+	Insecure bool              `json:"insecure"`
+	TLS      *TLSConfig        `json:"tls"`
+	Headers  map[string]string `json:"headers"`
+	// Compression can be set to gzip.
+	Compression string `json:"compression"`
+	// ServiceName sets the service.name resource attribute, defaults to prom2log.
+	ServiceName        string            `json:"service_name"`
+	ResourceAttributes map[string]string `json:"resource_attributes"`
+	// Timeout of each export request, defaults to 10s.
+	Timeout metav1.Duration `json:"timeout"`
+
+	BatchConfig
+	RetryConfig
+}
+
+// OTLPSink exports records as OpenTelemetry logs.
+// Each record becomes a log record with the query name in the prom2log.query attribute,
+// the labels shared by all the series of the result as metric.label.* attributes and,
+// for results with a single sample, its value in metric.value.
+type OTLPSink struct {
+	cfg        OTLPSinkConfig
+	resource   *resourcepb.Resource
+	formatter  Formatter
+	batcher    *batcher
+	conn       *grpc.ClientConn
+	grpcClient collogspb.LogsServiceClient
+	httpClient *http.Client
+}
+
+// NewOTLPSink creates a new OTLPSink.
+func NewOTLPSink(cfg OTLPSinkConfig) (*OTLPSink, error) {
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "prom2log"
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 10 * time.Second
+	}
+	if cfg.Compression != "" && cfg.Compression != "gzip" {
+		return nil, fmt.Errorf("unknown compression %q", cfg.Compression)
+	}
+
+	s := &OTLPSink{
+		cfg:       cfg,
+		formatter: sinkFormatter(),
+		resource: &resourcepb.Resource{
+			Attributes: []*commonpb.KeyValue{stringAttr("service.name", cfg.ServiceName)},
+		},
+	}
+	if host, err := os.Hostname(); err == nil {
+		s.resource.Attributes = append(s.resource.Attributes, stringAttr("host.name", host))
+	}
+	for k, v := range cfg.ResourceAttributes {
+		s.resource.Attributes = append(s.resource.Attributes, stringAttr(k, v))
+	}
+
+	switch strings.ToLower(cfg.Protocol) {
+	case "", "grpc":
+		if s.cfg.Endpoint == "" {
+			s.cfg.Endpoint = "localhost:4317"
+		}
+		creds := insecure.NewCredentials()
+		if !cfg.Insecure {
+			tlsConfig, err := cfg.tlsConfig().Build()
+			if err != nil {
+				return nil, err
+			}
+			creds = credentials.NewTLS(tlsConfig)
+		}
+		opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+		if cfg.Compression == "gzip" {
+			opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor("gzip")))
+		}
+		conn, err := grpc.Dial(s.cfg.Endpoint, opts...)
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+		s.grpcClient = collogspb.NewLogsServiceClient(conn)
+	case "http":
+		if s.cfg.Endpoint == "" {
+			s.cfg.Endpoint = "http://localhost:4318"
+		}
+		s.httpClient = &http.Client{Timeout: cfg.Timeout.Duration}
+		if cfg.TLS != nil {
+			tlsConfig, err := cfg.TLS.Build()
+			if err != nil {
+				return nil, err
+			}
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.TLSClientConfig = tlsConfig
+			s.httpClient.Transport = transport
+		}
+	default:
+		return nil, fmt.Errorf("unknown protocol %q", cfg.Protocol)
+	}
+
+	batcher, err := newBatcher(cfg.BatchConfig, s.export)
+	if err != nil {
+		return nil, err
+	}
+	s.batcher = batcher
+	return s, nil
+}
+
+func (c OTLPSinkConfig) tlsConfig() TLSConfig {
+	if c.TLS == nil {
+		return TLSConfig{}
+	}
+	return *c.TLS
+}
+
+// Write queues the record to be exported with the next batch.
+func (s *OTLPSink) Write(ctx context.Context, r Record) error {
+	return s.batcher.Add(ctx, r)
+}
+
+// Pending returns the number of records waiting to be exported.
+func (s *OTLPSink) Pending() int {
+	return s.batcher.Pending()
+}
+
+// Close exports the pending records and closes the connection to the collector.
+func (s *OTLPSink) Close() error {
+	err := s.batcher.Close()
+	if s.conn != nil {
+		err = errors.Join(err, s.conn.Close())
+	}
+	return err
+}
+
+func stringAttr(k, v string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{
+		Key:   k,
+		Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: v}},
+	}
+}
+
+func (s *OTLPSink) logRecord(r Record) (*logspb.LogRecord, error) {
+	var body bytes.Buffer
+	if err := s.formatter.Format(&body, r); err != nil {
+		return nil, err
+	}
+	lr := &logspb.LogRecord{
+		TimeUnixNano:         uint64(r.Time.UnixNano()),
+		ObservedTimeUnixNano: uint64(time.Now().UnixNano()),
+		SeverityNumber:       logspb.SeverityNumber_SEVERITY_NUMBER_INFO,
+		SeverityText:         "INFO",
+		Body:                 &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: strings.TrimRight(body.String(), "\n")}},
+		Attributes:           []*commonpb.KeyValue{stringAttr("prom2log.query", r.Name)},
+	}
+	for _, k := range sortedKeys(r.Fields) {
+		lr.Attributes = append(lr.Attributes, stringAttr(k, r.Fields[k]))
+	}
+	if r.Err != nil {
+		lr.SeverityNumber = logspb.SeverityNumber_SEVERITY_NUMBER_ERROR
+		lr.SeverityText = "ERROR"
+		lr.Attributes = append(lr.Attributes, stringAttr("error.message", r.Err.Error()))
+		return lr, nil
+	}
+	switch r.Severity() {
+	case SeverityCritical:
+		lr.SeverityNumber = logspb.SeverityNumber_SEVERITY_NUMBER_ERROR
+		lr.SeverityText = "CRITICAL"
+	case SeverityWarning:
+		lr.SeverityNumber = logspb.SeverityNumber_SEVERITY_NUMBER_WARN
+		lr.SeverityText = "WARNING"
+	}
+	for k, v := range r.sharedLabels() {
+		lr.Attributes = append(lr.Attributes, stringAttr("metric.label."+k, v))
+	}
+	if samples, err := r.Samples(); err == nil && len(samples) == 1 {
+		lr.Attributes = append(lr.Attributes, &commonpb.KeyValue{
+			Key:   "metric.value",
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: samples[0].Value}},
+		})
+	}
+	return lr, nil
+}
+
+func (s *OTLPSink) export(ctx context.Context, records []Record) error {
+	scope := &logspb.ScopeLogs{
+		Scope: &commonpb.InstrumentationScope{Name: "github.com/luisdavim/prom2log"},
+	}
+	for _, r := range records {
+		lr, err := s.logRecord(r)
+		if err != nil {
+			return err
+		}
+		scope.LogRecords = append(scope.LogRecords, lr)
+	}
+	req := &collogspb.ExportLogsServiceRequest{
+		ResourceLogs: []*logspb.ResourceLogs{{
+			Resource:  s.resource,
+			ScopeLogs: []*logspb.ScopeLogs{scope},
+		}},
+	}
+
+	if s.grpcClient != nil {
+		return s.cfg.RetryConfig.Do(ctx, func() error {
+			ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout.Duration)
+			defer cancel()
+			if len(s.cfg.Headers) > 0 {
+				ctx = metadata.NewOutgoingContext(ctx, metadata.New(s.cfg.Headers))
+			}
+			_, err := s.grpcClient.Export(ctx, req)
+			switch status.Code(err) {
+			case codes.OK:
+				return nil
+			case codes.Unavailable, codes.ResourceExhausted, codes.DeadlineExceeded, codes.Aborted:
+				return err
+			default:
+				return &permanentError{err: err}
+			}
+		})
+	}
+
+	body, err := proto.Marshal(req)
+	if err != nil {
+		return err
+	}
+	if s.cfg.Compression == "gzip" {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+	return s.cfg.RetryConfig.Do(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.cfg.Endpoint, "/")+"/v1/logs", bytes.NewReader(body))
+		if err != nil {
+			return &permanentError{err: err}
+		}
+		req.Header.Set("Content-Type", "application/x-protobuf")
+		if s.cfg.Compression == "gzip" {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		for k, v := range s.cfg.Headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return statusError(resp, b)
+	})
+}
+
+func init() {
+	RegisterSink("otlp", func(cfg SinkConfig) (Sink, error) {
+		var c OTLPSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewOTLPSink(c)
+	})
+}
@@ -0,0 +1,170 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeCollector answers the gRPC export requests with the given codes, then with OK, keeping the exported log records.
+type fakeCollector struct {
+	collogspb.UnimplementedLogsServiceServer
+	codes []codes.Code
+
+	mu       sync.Mutex
+	requests int
+	records  []*logspb.LogRecord
+}
+
+func (f *fakeCollector) Export(_ context.Context, req *collogspb.ExportLogsServiceRequest) (*collogspb.ExportLogsServiceResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests++
+	if len(f.codes) > 0 {
+		code := f.codes[0]
+		f.codes = f.codes[1:]
+		return nil, status.Error(code, "failed")
+	}
+	for _, rl := range req.ResourceLogs {
+		for _, sl := range rl.ScopeLogs {
+			f.records = append(f.records, sl.LogRecords...)
+		}
+	}
+	return &collogspb.ExportLogsServiceResponse{}, nil
+}
+
+func testOTLPRecords() []Record {
+	ts := time.Unix(1700000000, 0)
+	return []Record{{Name: "a", Time: ts}, {Name: "b", Time: ts, Err: errors.New("failed")}}
+}
+
+// checkOTLPRecords checks the log records exported for testOTLPRecords.
+func checkOTLPRecords(t *testing.T, records []*logspb.LogRecord) {
+	t.Helper()
+	if len(records) != 2 {
+		t.Fatalf("got %d log records, want 2", len(records))
+	}
+	want := []struct {
+		query    string
+		severity logspb.SeverityNumber
+	}{
+		{"a", logspb.SeverityNumber_SEVERITY_NUMBER_INFO},
+		{"b", logspb.SeverityNumber_SEVERITY_NUMBER_ERROR},
+	}
+	for i, lr := range records {
+		if lr.TimeUnixNano != 1700000000*uint64(time.Second) {
+			t.Errorf("got time %d, want the time of the record", lr.TimeUnixNano)
+		}
+		if query := lr.Attributes[0].GetValue().GetStringValue(); query != want[i].query {
+			t.Errorf("got query %q, want %q", query, want[i].query)
+		}
+		if lr.SeverityNumber != want[i].severity {
+			t.Errorf("got severity %v for %s, want %v", lr.SeverityNumber, want[i].query, want[i].severity)
+		}
+	}
+}
+
+func TestOTLPSinkGRPC(t *testing.T) {
+	tests := []struct {
+		name         string
+		codes        []codes.Code
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "exported", wantRequests: 1},
+		{name: "unavailable retried", codes: []codes.Code{codes.Unavailable}, wantRequests: 2},
+		{name: "invalid argument not retried", codes: []codes.Code{codes.InvalidArgument}, wantErr: true, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fake := &fakeCollector{codes: tt.codes}
+			srv := grpc.NewServer()
+			collogspb.RegisterLogsServiceServer(srv, fake)
+			go func() { _ = srv.Serve(ln) }()
+			defer srv.Stop()
+			s, err := NewOTLPSink(OTLPSinkConfig{
+				Endpoint:    ln.Addr().String(),
+				Insecure:    true,
+				RetryConfig: RetryConfig{MaxRetries: 1, MinBackoff: metav1.Duration{Duration: time.Millisecond}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			if err := s.export(context.Background(), testOTLPRecords()); (err != nil) != tt.wantErr {
+				t.Fatalf("export() = %v", err)
+			}
+			fake.mu.Lock()
+			defer fake.mu.Unlock()
+			if fake.requests != tt.wantRequests {
+				t.Errorf("got %d requests, want %d", fake.requests, tt.wantRequests)
+			}
+			if !tt.wantErr {
+				checkOTLPRecords(t, fake.records)
+			}
+		})
+	}
+}
+
+func TestOTLPSinkHTTP(t *testing.T) {
+	var (
+		header  string
+		records []*logspb.LogRecord
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		header = r.Header.Get("X-Tenant")
+		b, _ := io.ReadAll(r.Body)
+		var req collogspb.ExportLogsServiceRequest
+		if err := proto.Unmarshal(b, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, rl := range req.ResourceLogs {
+			for _, sl := range rl.ScopeLogs {
+				records = append(records, sl.LogRecords...)
+			}
+		}
+	}))
+	defer srv.Close()
+	s, err := NewOTLPSink(OTLPSinkConfig{
+		Protocol:    "http",
+		Endpoint:    srv.URL,
+		Headers:     map[string]string{"X-Tenant": "team"},
+		RetryConfig: RetryConfig{MaxRetries: -1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if err := s.export(context.Background(), testOTLPRecords()); err != nil {
+		t.Fatal(err)
+	}
+	if header != "team" {
+		t.Errorf("got header %q, want team", header)
+	}
+	checkOTLPRecords(t, records)
+}
@@ -0,0 +1,183 @@
+package prom2log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const splunkEventPath = "/services/collector/event"
+
+// SplunkSinkConfig configures a sink that sends records to a Splunk HTTP Event Collector.
+type SplunkSinkConfig struct {
+	// URL is the base URL of the HTTP Event Collector.
+	URL   string `json:"url"`
+	Token string `json:"token"`
+	// Index, SourceType and Source set the event metadata, {name} is replaced by the query name.
+	// When not set, the defaults of the token are used, Source defaults to prom2log.
+	Index      string `json:"index"`
+	SourceType string `json:"sourcetype"`
+	Source     string `json:"source"`
+	Host       string `json:"host"`
+	// Gzip compresses the requests.
+	Gzip bool       `json:"gzip"`
+	TLS  *TLSConfig `json:"tls"`
+	// Timeout of each request, defaults to 30s.
+	Timeout metav1.Duration `json:"timeout"`
+
+	BatchConfig
+	RetryConfig
+}
+
+// SplunkSink sends records to a Splunk HTTP Event Collector.
+type SplunkSink struct {
+	cfg       SplunkSinkConfig
+	client    *http.Client
+	formatter Formatter
+	batcher   *batcher
+}
+
+// NewSplunkSink creates a new SplunkSink.
+func NewSplunkSink(cfg SplunkSinkConfig) (*SplunkSink, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("missing url")
+	}
+	if cfg.Token == "" {
+		return nil, errors.New("missing token")
+	}
+	if cfg.Source == "" {
+		cfg.Source = "prom2log"
+	}
+	if cfg.Host == "" {
+		cfg.Host, _ = os.Hostname()
+	}
+	if cfg.Timeout.Duration <= 0 {
+		cfg.Timeout.Duration = 30 * time.Second
+	}
+	client := &http.Client{Timeout: cfg.Timeout.Duration}
+	if cfg.TLS != nil {
+		tlsConfig, err := cfg.TLS.Build()
+		if err != nil {
+			return nil, err
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
+	s := &SplunkSink{
+		cfg:       cfg,
+		client:    client,
+		formatter: sinkFormatter(),
+	}
+	batcher, err := newBatcher(cfg.BatchConfig, s.send)
+	if err != nil {
+		return nil, err
+	}
+	s.batcher = batcher
+	return s, nil
+}
+
+// Write queues the record to be sent with the next batch.
+func (s *SplunkSink) Write(ctx context.Context, r Record) error {
+	return s.batcher.Add(ctx, r)
+}
+
+// Pending returns the number of records waiting to be sent to the HEC.
+func (s *SplunkSink) Pending() int {
+	return s.batcher.Pending()
+}
+
+// Close sends the pending records.
+func (s *SplunkSink) Close() error {
+	return s.batcher.Close()
+}
+
+type splunkEvent struct {
+	Time       float64         `json:"time"`
+	Host       string          `json:"host,omitempty"`
+	Source     string          `json:"source,omitempty"`
+	SourceType string          `json:"sourcetype,omitempty"`
+	Index      string          `json:"index,omitempty"`
+	Event      json.RawMessage `json:"event"`
+}
+
+func (s *SplunkSink) send(ctx context.Context, records []Record) error {
+	var payload bytes.Buffer
+	enc := json.NewEncoder(&payload)
+	for _, r := range records {
+		var buf bytes.Buffer
+		if err := s.formatter.Format(&buf, r); err != nil {
+			return err
+		}
+		// the records rendered as JSON are sent as objects, the other formats as strings
+		event := json.RawMessage(bytes.TrimRight(buf.Bytes(), "\n"))
+		if !s.formatter.rendersJSON(r) {
+			var err error
+			if event, err = json.Marshal(string(event)); err != nil {
+				return err
+			}
+		}
+		name := strings.NewReplacer("{name}", r.Name)
+		if err := enc.Encode(splunkEvent{
+			Time:       float64(r.Time.UnixNano()) / float64(time.Second),
+			Host:       s.cfg.Host,
+			Source:     name.Replace(s.cfg.Source),
+			SourceType: name.Replace(s.cfg.SourceType),
+			Index:      name.Replace(s.cfg.Index),
+			Event:      event,
+		}); err != nil {
+			return err
+		}
+	}
+
+	body := payload.Bytes()
+	if s.cfg.Gzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	return s.cfg.RetryConfig.Do(ctx, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.cfg.URL, "/")+splunkEventPath, bytes.NewReader(body))
+		if err != nil {
+			return &permanentError{err: err}
+		}
+		req.Header.Set("Authorization", "Splunk "+s.cfg.Token)
+		req.Header.Set("Content-Type", "application/json")
+		if s.cfg.Gzip {
+			req.Header.Set("Content-Encoding", "gzip")
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return statusError(resp, b)
+	})
+}
+
+func init() {
+	RegisterSink("splunk", func(cfg SinkConfig) (Sink, error) {
+		var c SplunkSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewSplunkSink(c)
+	})
+}
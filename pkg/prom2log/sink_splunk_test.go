@@ -0,0 +1,76 @@
+package prom2log
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSplunkSinkSend(t *testing.T) {
+	for _, gz := range []bool{false, true} {
+		name := "plain"
+		if gz {
+			name = "gzip"
+		}
+		t.Run(name, func(t *testing.T) {
+			var events []splunkEvent
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != splunkEventPath || r.Header.Get("Authorization") != "Splunk token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				var body io.Reader = r.Body
+				if r.Header.Get("Content-Encoding") == "gzip" {
+					zr, err := gzip.NewReader(r.Body)
+					if err != nil {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					body = zr
+				}
+				// the events are concatenated, not in an array
+				dec := json.NewDecoder(body)
+				for dec.More() {
+					var e splunkEvent
+					if err := dec.Decode(&e); err != nil {
+						w.WriteHeader(http.StatusBadRequest)
+						return
+					}
+					events = append(events, e)
+				}
+			}))
+			defer srv.Close()
+			s, err := NewSplunkSink(SplunkSinkConfig{
+				URL:         srv.URL,
+				Token:       "token",
+				Source:      "prom2log:{name}",
+				Index:       "metrics",
+				Gzip:        gz,
+				RetryConfig: RetryConfig{MaxRetries: -1},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			ts := time.Unix(1700000000, 500000000)
+			if err := s.send(context.Background(), []Record{{Name: "a", Time: ts}, {Name: "b", Time: ts}}); err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 2 {
+				t.Fatalf("got %d events, want 2", len(events))
+			}
+			for i, want := range []string{"prom2log:a", "prom2log:b"} {
+				e := events[i]
+				if e.Source != want || e.Index != "metrics" || e.Time != 1700000000.5 || len(e.Event) == 0 {
+					t.Errorf("got event %+v, want source %s, index metrics and time 1700000000.5", e, want)
+				}
+			}
+		})
+	}
+}
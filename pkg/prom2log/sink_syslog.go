@@ -0,0 +1,235 @@
+package prom2log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg":   0,
+	"alert":   1,
+	"crit":    2,
+	"err":     3,
+	"warning": 4,
+	"notice":  5,
+	"info":    6,
+	"debug":   7,
+}
+
+// SyslogSinkConfig configures a sink that sends records to syslog using the RFC5424 format.
+type SyslogSinkConfig struct {
+	// Network is one of udp, tcp, unix or unixgram, defaults to the local syslog socket.
+	Network string `json:"network"`
+	Address string `json:"address"`
+	// Facility defaults to user.
+	Facility string `json:"facility"`
+	// Severity is used for successful results, defaults to info, errors are always logged as err.
+	Severity string `json:"severity"`
+	// Tag is the APP-NAME of the messages, defaults to prom2log.
+	Tag      string `json:"tag"`
+	Hostname string `json:"hostname"`
+}
+
+var errSyslogClosed = errors.New("the syslog sink is closed")
+
+// SyslogSink sends records to syslog.
+type SyslogSink struct {
+	cfg       SyslogSinkConfig
+	facility  int
+	severity  int
+	formatter Formatter
+
+	mu   sync.Mutex
+	conn net.Conn
+	// network is the network of conn.
+	network string
+	closed  bool
+}
+
+// NewSyslogSink creates a new SyslogSink.
+func NewSyslogSink(cfg SyslogSinkConfig) (*SyslogSink, error) {
+	if cfg.Facility == "" {
+		cfg.Facility = "user"
+	}
+	if cfg.Severity == "" {
+		cfg.Severity = "info"
+	}
+	if cfg.Tag == "" {
+		cfg.Tag = "prom2log"
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	facility, ok := syslogFacilities[strings.ToLower(cfg.Facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility %q", cfg.Facility)
+	}
+	severity, ok := syslogSeverities[strings.ToLower(cfg.Severity)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog severity %q", cfg.Severity)
+	}
+	s := &SyslogSink{
+		cfg:       cfg,
+		facility:  facility,
+		severity:  severity,
+		formatter: sinkFormatter(),
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SyslogSink) connect() error {
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+	if s.cfg.Network != "" {
+		conn, err := net.Dial(s.cfg.Network, s.cfg.Address)
+		if err != nil {
+			return err
+		}
+		s.conn, s.network = conn, s.cfg.Network
+		return nil
+	}
+	var err error
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			var conn net.Conn
+			conn, err = net.Dial(network, path)
+			if err == nil {
+				s.conn, s.network = conn, network
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("unable to connect to the local syslog: %w", err)
+}
+
+// Write sends the record to syslog.
+func (s *SyslogSink) Write(_ context.Context, r Record) error {
+	var buf bytes.Buffer
+	if err := s.formatter.Format(&buf, r); err != nil {
+		return err
+	}
+	severity := s.severity
+	switch {
+	case r.Err != nil:
+		severity = syslogSeverities["err"]
+	case r.Severity() == SeverityCritical:
+		severity = syslogSeverities["crit"]
+	case r.Severity() == SeverityWarning:
+		severity = syslogSeverities["warning"]
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s - %s",
+		s.facility*8+severity,
+		r.Time.Format(time.RFC3339Nano),
+		headerField(s.cfg.Hostname, 255),
+		headerField(s.cfg.Tag, 48),
+		os.Getpid(),
+		headerField(r.Name, 32),
+		bytes.TrimRight(buf.Bytes(), "\n"),
+	)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return &permanentError{err: errSyslogClosed}
+	}
+	if s.conn != nil {
+		if _, err := s.conn.Write(s.frame(msg)); err == nil {
+			return nil
+		}
+	}
+	// try to reconnect once
+	if err := s.connect(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write(s.frame(msg))
+	return err
+}
+
+// frame delimits the message for the transport: TCP uses octet counting, see RFC6587, and the messages sent to
+// the local stream sockets end with a new line, the datagram transports don't need any framing.
+func (s *SyslogSink) frame(msg string) []byte {
+	switch {
+	case strings.HasPrefix(s.network, "tcp"):
+		return []byte(fmt.Sprintf("%d %s", len(msg), msg))
+	case s.network == "unix":
+		return []byte(msg + "\n")
+	}
+	return []byte(msg)
+}
+
+// Close closes the connection to syslog.
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// headerField returns s as a field of the RFC5424 header, up to max printable ASCII characters,
+// others are replaced with _, or the nil value if it's empty.
+func headerField(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if len(b) == max {
+			break
+		}
+		if r < 33 || r > 126 {
+			r = '_'
+		}
+		b = append(b, byte(r))
+	}
+	return string(b)
+}
+
+func init() {
+	RegisterSink("syslog", func(cfg SinkConfig) (Sink, error) {
+		var c SyslogSinkConfig
+		if err := cfg.Decode(&c); err != nil {
+			return nil, err
+		}
+		return NewSyslogSink(c)
+	})
+}
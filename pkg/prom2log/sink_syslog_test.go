@@ -0,0 +1,124 @@
+package prom2log
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestSyslogSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+
+	s, err := NewSyslogSink(SyslogSinkConfig{Network: "tcp", Address: ln.Addr().String(), Hostname: "host", Tag: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := <-conns
+	defer conn.Close()
+
+	ts := time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)
+	if err := s.Write(context.Background(), Record{Name: "up", Time: ts, Sample: &Sample{Value: 1, Timestamp: ts}}); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var n int
+	r := bufio.NewReader(conn)
+	if _, err := fmt.Fscanf(r, "%d ", &n); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+	// the header carries the time of the record
+	if want := "<14>1 2023-11-14T22:13:20Z host test "; !strings.HasPrefix(string(msg), want) {
+		t.Errorf("got message %q, want the header %q", msg, want)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err = s.Write(context.Background(), Record{Name: "up", Time: ts})
+	if !errors.Is(err, errSyslogClosed) {
+		t.Errorf("Write() after Close() = %v, want %v", err, errSyslogClosed)
+	}
+	select {
+	case <-conns:
+		t.Error("Write() after Close() reconnected")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSyslogSinkPriority(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	s, err := NewSyslogSink(SyslogSinkConfig{Network: "udp", Address: conn.LocalAddr().String(), Facility: "local0", Severity: "notice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		name   string
+		record Record
+		want   string
+	}{
+		{name: "result", record: Record{Name: "up", Result: model.Vector{}}, want: "<133>1 "},
+		{name: "error", record: Record{Name: "up", Err: errors.New("failed")}, want: "<131>1 "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.record.Time = time.Unix(1700000000, 0)
+			if err := s.Write(context.Background(), tt.record); err != nil {
+				t.Fatal(err)
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			b := make([]byte, 64*1024)
+			n, _, err := conn.ReadFrom(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// datagrams aren't framed
+			msg := string(b[:n])
+			if !strings.HasPrefix(msg, tt.want) || strings.HasSuffix(msg, "\n") {
+				t.Errorf("got message %q, want it to start with %q", msg, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSyslogSinkInvalid(t *testing.T) {
+	for _, cfg := range []SyslogSinkConfig{
+		{Network: "udp", Address: "127.0.0.1:514", Facility: "nope"},
+		{Network: "udp", Address: "127.0.0.1:514", Severity: "nope"},
+	} {
+		if _, err := NewSyslogSink(cfg); err == nil {
+			t.Errorf("NewSyslogSink(%+v) succeeded", cfg)
+		}
+	}
+}
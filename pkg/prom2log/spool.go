@@ -0,0 +1,411 @@
+package prom2log
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BufferConfig configures an on-disk buffer for the batches a sink fails to send, so they're sent once it recovers,
+// also after a restart. Batches failing with permanent errors, like invalid requests, are written to the dead letter
+// file instead, if set, or dropped.
+type BufferConfig struct {
+	// Dir is where the batches are stored, one file each, every sink needs its own.
+	Dir string `json:"dir"`
+	// MaxSize is the maximum size of the stored batches in bytes, defaults to 100MiB.
+	// The batches failing while the buffer is full are dropped.
+	MaxSize int64 `json:"max_size,omitempty"`
+	// RetryInterval is how often sending the stored batches is retried, defaults to 10s.
+	RetryInterval metav1.Duration `json:"retry_interval,omitempty"`
+	// DeadLetter is the path of the file the records failing with permanent errors are appended to, as JSON lines.
+	DeadLetter string `json:"dead_letter,omitempty"`
+}
+
+const (
+	defaultBufferSize    = 100 << 20
+	defaultRetryInterval = 10 * time.Second
+	spoolExt             = ".jsonl"
+	// corruptExt is added to the buffer files that can't be decoded, so they're kept aside.
+	corruptExt = ".corrupt"
+)
+
+// ErrBufferFull is returned for batches that failed to be sent and don't fit in the sink's buffer.
+var ErrBufferFull = errors.New("the sink buffer is full")
+
+// spool stores failed batches on disk and sends them again, oldest first, until they succeed.
+type spool struct {
+	cfg  BufferConfig
+	send func(ctx context.Context, records []Record) error
+
+	mu      sync.Mutex
+	files   []string
+	size    int64
+	records int
+	seq     int
+	// sending serializes sending the stored batches.
+	sending sync.Mutex
+}
+
+func newSpool(cfg BufferConfig, send func(ctx context.Context, records []Record) error) (*spool, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("the buffer dir is required")
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = defaultBufferSize
+	}
+	if cfg.RetryInterval.Duration <= 0 {
+		cfg.RetryInterval.Duration = defaultRetryInterval
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating the buffer dir: %w", err)
+	}
+	s := &spool{cfg: cfg, send: send}
+	// batches left by a previous run are sent first
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), spoolExt) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(cfg.Dir, e.Name())
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		s.files = append(s.files, path)
+		s.size += info.Size()
+		s.records += bytes.Count(b, []byte("\n"))
+	}
+	sort.Strings(s.files)
+	if len(s.files) > 0 {
+		log().Info("found buffered records", "dir", cfg.Dir, "records", s.records)
+	}
+	return s, nil
+}
+
+// Pending returns the number of stored records.
+func (s *spool) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records
+}
+
+// flush sends the records unless batches are already stored, storing them when that fails.
+// Permanent errors send the records to the dead letter file instead.
+func (s *spool) flush(ctx context.Context, records []Record) error {
+	if s.Pending() == 0 {
+		err := s.send(ctx, records)
+		if err == nil {
+			return nil
+		}
+		retry, rejected := failedRecords(records, err)
+		if len(rejected) > 0 {
+			if err := s.deadLetter(rejected, err); err != nil {
+				return err
+			}
+		}
+		if len(retry) == 0 {
+			return nil
+		}
+		log().Warn("failed to send the batch, buffering it", "dir", s.cfg.Dir, "records", len(retry), "error", err)
+		records = retry
+	}
+	return s.store(records)
+}
+
+// store writes the records to a new file in the buffer dir.
+func (s *spool) store(records []Record) error {
+	b, err := encodeRecords(records)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+int64(len(b)) > s.cfg.MaxSize {
+		return fmt.Errorf("dropping %d records: %w", len(records), ErrBufferFull)
+	}
+	s.seq++
+	// the names sort in the order the batches were stored
+	path := filepath.Join(s.cfg.Dir, fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, spoolExt))
+	if err := writeFileAtomic(path, b, 0o600); err != nil {
+		return fmt.Errorf("buffering %d records: %w", len(records), err)
+	}
+	s.files = append(s.files, path)
+	s.size += int64(len(b))
+	s.records += len(records)
+	return nil
+}
+
+// retry sends the stored batches, oldest first, stopping at the first one that fails with a transient error.
+func (s *spool) retry(ctx context.Context) {
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	for {
+		s.mu.Lock()
+		if len(s.files) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		path := s.files[0]
+		s.mu.Unlock()
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			log().Error("failed to read buffered records", "file", path, "error", err)
+			return
+		}
+		records, err := decodeRecords(b)
+		if err != nil {
+			// the file would fail again on every retry, blocking the ones behind it
+			if err := os.Rename(path, path+corruptExt); err != nil {
+				log().Error("failed to move aside corrupt buffered records", "file", path, "error", err)
+				return
+			}
+			log().Error("failed to decode buffered records, moved them aside", "file", path+corruptExt, "error", err)
+			s.mu.Lock()
+			s.files = s.files[1:]
+			s.size -= int64(len(b))
+			s.records -= bytes.Count(b, []byte("\n"))
+			s.mu.Unlock()
+			continue
+		}
+		if err := s.send(ctx, records); err != nil {
+			retry, rejected := failedRecords(records, err)
+			if len(rejected) > 0 {
+				if err := s.deadLetter(rejected, err); err != nil {
+					log().Error("failed to write to the dead letter file", "file", s.cfg.DeadLetter, "error", err)
+					return
+				}
+			}
+			if len(retry) > 0 {
+				log().Debug("failed to send buffered records", "dir", s.cfg.Dir, "records", len(retry), "error", err)
+				if len(retry) < len(records) {
+					// the other records were sent or dead lettered, only the failed ones are sent again
+					s.keep(path, b, records, retry)
+				}
+				return
+			}
+		} else {
+			log().Info("sent buffered records", "dir", s.cfg.Dir, "records", len(records))
+		}
+		if err := os.Remove(path); err != nil {
+			log().Error("failed to remove buffered records", "file", path, "error", err)
+			return
+		}
+		s.mu.Lock()
+		s.files = s.files[1:]
+		s.size -= int64(len(b))
+		s.records -= len(records)
+		s.mu.Unlock()
+	}
+}
+
+// keep replaces the stored batch in path, holding records and encoded as b, with the records to send again.
+func (s *spool) keep(path string, b []byte, records, retry []Record) {
+	kept, err := encodeRecords(retry)
+	if err == nil {
+		err = writeFileAtomic(path, kept, 0o600)
+	}
+	if err != nil {
+		// the whole batch is sent again
+		log().Error("failed to update buffered records", "file", path, "error", err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.size += int64(len(kept) - len(b))
+	s.records += len(retry) - len(records)
+}
+
+// deadLetter appends the records that can't be sent to the dead letter file, or drops them.
+func (s *spool) deadLetter(records []Record, cause error) error {
+	if s.cfg.DeadLetter == "" {
+		log().Error("dropping records that can't be sent", "records", len(records), "error", cause)
+		return nil
+	}
+	b, err := encodeRecords(records)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.cfg.DeadLetter, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	log().Error("records can't be sent, moved them to the dead letter file", "file", s.cfg.DeadLetter, "records", len(records), "error", cause)
+	return f.Close()
+}
+
+// storedRecord is the representation of a Record in the buffer files.
+type storedRecord struct {
+	Time       time.Time         `json:"time"`
+	Name       string            `json:"name"`
+	ResultType model.ValueType   `json:"result_type,omitempty"`
+	Result     json.RawMessage   `json:"result,omitempty"`
+	Warnings   []string          `json:"warnings,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Sample     *storedSample     `json:"sample,omitempty"`
+	Format     string            `json:"format,omitempty"`
+	Template   string            `json:"template,omitempty"`
+	Extract    string            `json:"extract,omitempty"`
+	Thresholds *Thresholds       `json:"thresholds,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	Fields     map[string]string `json:"fields,omitempty"`
+	Timestamp  *TimestampConfig  `json:"timestamp,omitempty"`
+}
+
+// storedSample is the representation of a Sample in the buffer files, the value is a string like in the Prometheus API
+// because JSON can't represent NaN and infinite values.
+type storedSample struct {
+	Metric    map[string]string `json:"metric"`
+	Value     string            `json:"value"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// encodeRecords encodes the records as JSON lines.
+func encodeRecords(records []Record) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range records {
+		sr := storedRecord{
+			Time:       r.Time,
+			Name:       r.Name,
+			Warnings:   r.Warnings,
+			Format:     r.Format,
+			Thresholds: r.Thresholds,
+			Source:     r.Source,
+			Fields:     r.Fields,
+			Timestamp:  r.Timestamp,
+		}
+		if r.Err != nil {
+			sr.Error = r.Err.Error()
+		}
+		if r.Sample != nil {
+			sr.Sample = &storedSample{
+				Metric:    r.Sample.Metric,
+				Value:     strconv.FormatFloat(r.Sample.Value, 'g', -1, 64),
+				Timestamp: r.Sample.Timestamp,
+			}
+		}
+		if r.Template != nil && r.Template.Tree != nil {
+			sr.Template = r.Template.Tree.Root.String()
+		}
+		if r.Extract != nil {
+			sr.Extract = r.Extract.String()
+		}
+		if r.Result != nil {
+			b, err := json.Marshal(r.Result)
+			if err != nil {
+				return nil, err
+			}
+			sr.ResultType, sr.Result = r.Result.Type(), b
+		}
+		if err := enc.Encode(sr); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeRecords decodes the records encoded by encodeRecords.
+func decodeRecords(b []byte) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(nil, len(b)+1)
+	for scanner.Scan() {
+		var sr storedRecord
+		if err := json.Unmarshal(scanner.Bytes(), &sr); err != nil {
+			return nil, err
+		}
+		r := Record{
+			Time:       sr.Time,
+			Name:       sr.Name,
+			Warnings:   sr.Warnings,
+			Format:     sr.Format,
+			Thresholds: sr.Thresholds,
+			Source:     sr.Source,
+			Fields:     sr.Fields,
+			Timestamp:  sr.Timestamp,
+		}
+		if sr.Error != "" {
+			r.Err = errors.New(sr.Error)
+		}
+		if sr.Sample != nil {
+			v, err := strconv.ParseFloat(sr.Sample.Value, 64)
+			if err != nil {
+				return nil, err
+			}
+			r.Sample = &Sample{Metric: sr.Sample.Metric, Value: v, Timestamp: sr.Sample.Timestamp}
+		}
+		if sr.Template != "" {
+			t, err := ParseTemplate(sr.Template)
+			if err != nil {
+				return nil, err
+			}
+			r.Template = t
+		}
+		if sr.Extract != "" {
+			e, err := ParseExtract(sr.Extract)
+			if err != nil {
+				return nil, err
+			}
+			r.Extract = e
+		}
+		if len(sr.Result) > 0 {
+			var err error
+			if r.Result, err = decodeValue(sr.ResultType, sr.Result); err != nil {
+				return nil, err
+			}
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+func decodeValue(t model.ValueType, b []byte) (model.Value, error) {
+	var v model.Value
+	switch t {
+	case model.ValVector:
+		v = &model.Vector{}
+	case model.ValMatrix:
+		v = &model.Matrix{}
+	case model.ValScalar:
+		v = &model.Scalar{}
+	case model.ValString:
+		v = &model.String{}
+	default:
+		return nil, fmt.Errorf("unknown result type %q", t)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case *model.Vector:
+		return *v, nil
+	case *model.Matrix:
+		return *v, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,265 @@
+package prom2log
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// namedRecords returns a record with each of the names.
+func namedRecords(names ...string) []Record {
+	rs := make([]Record, len(names))
+	for i, n := range names {
+		rs[i] = Record{Name: n, Time: time.Unix(1700000000, 0)}
+	}
+	return rs
+}
+
+func TestSpoolFlush(t *testing.T) {
+	errDown := errors.New("down")
+	errInvalid := &permanentError{err: errors.New("invalid")}
+	tests := []struct {
+		name       string
+		sendErr    error
+		deadLetter bool
+		maxSize    int64
+		wantErr    error
+		// pending is the number of records stored in the buffer
+		pending int
+		// dead is the number of records written to the dead letter file
+		dead int
+	}{
+		{name: "sent"},
+		{name: "transient error", sendErr: errDown, pending: 2},
+		{name: "permanent error", sendErr: errInvalid, deadLetter: true, dead: 2},
+		{name: "permanent error without dead letter", sendErr: errInvalid},
+		{name: "buffer full", sendErr: errDown, maxSize: 10, wantErr: ErrBufferFull},
+		{
+			name:       "some records failed",
+			sendErr:    &batchError{retry: namedRecords("a"), rejected: namedRecords("b"), err: errDown},
+			deadLetter: true,
+			pending:    1,
+			dead:       1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := BufferConfig{Dir: filepath.Join(dir, "buffer"), MaxSize: tt.maxSize}
+			if tt.deadLetter {
+				cfg.DeadLetter = filepath.Join(dir, "dead.jsonl")
+			}
+			s, err := newSpool(cfg, func(context.Context, []Record) error { return tt.sendErr })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := s.flush(context.Background(), namedRecords("a", "b")); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("flush() = %v, want %v", err, tt.wantErr)
+			}
+			if got := s.Pending(); got != tt.pending {
+				t.Errorf("Pending() = %d, want %d", got, tt.pending)
+			}
+			if cfg.DeadLetter != "" {
+				b, err := os.ReadFile(cfg.DeadLetter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := bytes.Count(b, []byte("\n")); got != tt.dead {
+					t.Errorf("%d records in the dead letter file, want %d", got, tt.dead)
+				}
+			}
+		})
+	}
+}
+
+func TestSpoolRetry(t *testing.T) {
+	var (
+		sendErr error
+		sent    []string
+	)
+	send := func(_ context.Context, rs []Record) error {
+		if sendErr != nil {
+			return sendErr
+		}
+		for _, r := range rs {
+			sent = append(sent, r.Name)
+		}
+		return nil
+	}
+	cfg := BufferConfig{Dir: t.TempDir()}
+	s, err := newSpool(cfg, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendErr = errors.New("down")
+	for _, batch := range [][]Record{namedRecords("a", "b"), namedRecords("c")} {
+		if err := s.flush(context.Background(), batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.retry(context.Background())
+	if got := s.Pending(); got != 3 {
+		t.Fatalf("Pending() = %d after a failed retry, want 3", got)
+	}
+
+	// the batches stored by a previous run are found again
+	s, err = newSpool(cfg, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Pending(); got != 3 {
+		t.Fatalf("Pending() = %d after reopening, want 3", got)
+	}
+
+	// new batches are stored behind the pending ones, to keep the order
+	sendErr = nil
+	if err := s.flush(context.Background(), namedRecords("d")); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 0 {
+		t.Fatalf("sent %v before the stored batches", sent)
+	}
+	s.retry(context.Background())
+	if got, want := strings.Join(sent, ","), "a,b,c,d"; got != want {
+		t.Errorf("sent %s, want %s", got, want)
+	}
+	if got := s.Pending(); got != 0 {
+		t.Errorf("Pending() = %d after retrying, want 0", got)
+	}
+	if entries, _ := os.ReadDir(cfg.Dir); len(entries) != 0 {
+		t.Errorf("%d files left in the buffer", len(entries))
+	}
+}
+
+func TestSpoolRetryPartial(t *testing.T) {
+	var (
+		sendErr error
+		sent    []string
+	)
+	send := func(_ context.Context, rs []Record) error {
+		if sendErr != nil {
+			return sendErr
+		}
+		for _, r := range rs {
+			sent = append(sent, r.Name)
+		}
+		return nil
+	}
+	cfg := BufferConfig{Dir: t.TempDir()}
+	s, err := newSpool(cfg, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendErr = errors.New("down")
+	if err := s.flush(context.Background(), namedRecords("a", "b", "c")); err != nil {
+		t.Fatal(err)
+	}
+
+	// only c failed, the stored batch keeps it alone
+	sendErr = &batchError{retry: namedRecords("c"), err: errors.New("throttled")}
+	s.retry(context.Background())
+	if got := s.Pending(); got != 1 {
+		t.Fatalf("Pending() = %d after a partly failed retry, want 1", got)
+	}
+	if s, err = newSpool(cfg, send); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Pending(); got != 1 {
+		t.Fatalf("Pending() = %d after reopening, want 1", got)
+	}
+
+	sendErr = nil
+	s.retry(context.Background())
+	if got, want := strings.Join(sent, ","), "c"; got != want {
+		t.Errorf("sent %s, want %s", got, want)
+	}
+	if got := s.Pending(); got != 0 {
+		t.Errorf("Pending() = %d after retrying, want 0", got)
+	}
+}
+
+func TestSpoolRetryCorrupt(t *testing.T) {
+	var sent []string
+	send := func(_ context.Context, rs []Record) error {
+		for _, r := range rs {
+			sent = append(sent, r.Name)
+		}
+		return nil
+	}
+	cfg := BufferConfig{Dir: t.TempDir()}
+	b, err := encodeRecords(namedRecords("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a truncated file, stored before a valid one
+	corrupt := filepath.Join(cfg.Dir, "1"+spoolExt)
+	if err := os.WriteFile(corrupt, b[:len(b)/2], 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cfg.Dir, "2"+spoolExt), b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := newSpool(cfg, send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.retry(context.Background())
+	if got, want := strings.Join(sent, ","), "a"; got != want {
+		t.Errorf("sent %s, want %s", got, want)
+	}
+	if got := s.Pending(); got != 0 {
+		t.Errorf("Pending() = %d after retrying, want 0", got)
+	}
+	if _, err := os.Stat(corrupt + corruptExt); err != nil {
+		t.Errorf("the corrupt file wasn't moved aside: %v", err)
+	}
+
+	// the corrupt file isn't picked up again
+	if s, err = newSpool(cfg, send); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Pending(); got != 0 {
+		t.Errorf("Pending() = %d after reopening, want 0", got)
+	}
+}
+
+func TestEncodeRecords(t *testing.T) {
+	e, err := ParseExtract(".metric.job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := []Record{
+		{Name: "a", Time: time.Unix(1700000000, 0).UTC(), Format: FormatLogfmt, Source: "x", Fields: map[string]string{"env": "prod"}},
+		{Name: "b", Err: errors.New("failed")},
+		{Name: "c", Sample: &Sample{Metric: map[string]string{"job": "node"}, Value: math.NaN()}, Extract: e},
+	}
+	b, err := encodeRecords(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := decodeRecords(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != len(in) {
+		t.Fatalf("decoded %d records, want %d", len(out), len(in))
+	}
+	switch {
+	case out[0].Name != "a" || !out[0].Time.Equal(in[0].Time) || out[0].Format != FormatLogfmt || out[0].Source != "x" || out[0].Fields["env"] != "prod":
+		t.Errorf("decoded %+v, want %+v", out[0], in[0])
+	case out[1].Err == nil || out[1].Err.Error() != "failed":
+		t.Errorf("decoded error %v, want failed", out[1].Err)
+	case out[2].Sample == nil || !math.IsNaN(out[2].Sample.Value) || out[2].Sample.Metric["job"] != "node":
+		t.Errorf("decoded sample %+v, want %+v", out[2].Sample, in[2].Sample)
+	case out[2].Extract == nil || out[2].Extract.String() != ".metric.job":
+		t.Errorf("decoded extract %v, want .metric.job", out[2].Extract)
+	}
+}
@@ -0,0 +1,311 @@
+package prom2log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// What the scheduler does with the runs missed while it wasn't running, see Scheduler.CatchUp.
+const (
+	// CatchUpSkip runs the queries whose activations were missed right away, once, and waits for the next activation
+	// of the others.
+	CatchUpSkip = "skip"
+	// CatchUpBackfill replays the missed activations with range queries before resuming the schedule.
+	CatchUpBackfill = "backfill"
+)
+
+// stateSaveInterval is how often the scheduler saves its state when it changed.
+const stateSaveInterval = 10 * time.Second
+
+// StateStore persists the time of the last successful run of each query, by query name,
+// so the scheduler resumes where it left off after a restart.
+type StateStore interface {
+	// Load returns the saved state, it's empty if none was saved yet.
+	Load(ctx context.Context) (map[string]time.Time, error)
+	// Save replaces the saved state.
+	Save(ctx context.Context, state map[string]time.Time) error
+}
+
+// FileState stores the state as JSON in the file at the given path.
+type FileState string
+
+// Load reads the state file, it's empty if the file doesn't exist.
+func (f FileState) Load(context.Context) (map[string]time.Time, error) {
+	b, err := os.ReadFile(string(f))
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]time.Time{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := map[string]time.Time{}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", f, err)
+	}
+	return state, nil
+}
+
+// Save writes the state to a temporary file and renames it, so the state file is never left half written.
+func (f FileState) Save(_ context.Context, state map[string]time.Time) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(string(f), b, 0o600)
+}
+
+// writeFileAtomic writes b to a temporary file in the same dir and renames it to path, so the file is never left half
+// written.
+func writeFileAtomic(path string, b []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// configMapStateKey is the key of the ConfigMap data holding the state.
+const configMapStateKey = "state.json"
+
+// ConfigMapConfig configures a Kubernetes ConfigMap storing the state of the scheduler.
+type ConfigMapConfig struct {
+	// Name of the ConfigMap, it's created if it doesn't exist.
+	Name string
+	// Namespace of the ConfigMap, defaults to the namespace of the pod.
+	Namespace string
+	// APIServer is the URL of the Kubernetes API, defaults to the one of the cluster prom2log runs in.
+	APIServer string
+}
+
+// ConfigMapState stores the state as JSON in a Kubernetes ConfigMap.
+type ConfigMapState struct {
+	cfg    ConfigMapConfig
+	client *kubeClient
+}
+
+// configMap is the part of the Kubernetes ConfigMap resource used to store the state.
+type configMap struct {
+	APIVersion        string `json:"apiVersion"`
+	Kind              string `json:"kind"`
+	metav1.ObjectMeta `json:"metadata"`
+	Data              map[string]string `json:"data,omitempty"`
+}
+
+// NewConfigMapState returns a store for the ConfigMap with the given configuration.
+func NewConfigMapState(cfg ConfigMapConfig) (*ConfigMapState, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("the ConfigMap name is required")
+	}
+	client, err := newKubeClient(cfg.APIServer)
+	if err != nil {
+		return nil, fmt.Errorf("state ConfigMap: %w", err)
+	}
+	if cfg.Namespace == "" {
+		if cfg.Namespace, err = podNamespace(); err != nil {
+			return nil, fmt.Errorf("state ConfigMap: %w", err)
+		}
+	}
+	return &ConfigMapState{cfg: cfg, client: client}, nil
+}
+
+func (c *ConfigMapState) path() string {
+	return "/api/v1/namespaces/" + url.PathEscape(c.cfg.Namespace) + "/configmaps"
+}
+
+// get returns the ConfigMap, or nil if it doesn't exist.
+func (c *ConfigMapState) get(ctx context.Context) (*configMap, error) {
+	status, b, err := c.client.do(ctx, http.MethodGet, c.path()+"/"+url.PathEscape(c.cfg.Name), nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if status != http.StatusOK {
+		return nil, kubeError(status, b)
+	}
+	var cm configMap
+	if err := json.Unmarshal(b, &cm); err != nil {
+		return nil, err
+	}
+	return &cm, nil
+}
+
+// Load reads the state from the ConfigMap, it's empty if the ConfigMap doesn't exist.
+func (c *ConfigMapState) Load(ctx context.Context) (map[string]time.Time, error) {
+	cm, err := c.get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("state ConfigMap: %w", err)
+	}
+	state := map[string]time.Time{}
+	if cm == nil || cm.Data[configMapStateKey] == "" {
+		return state, nil
+	}
+	if err := json.Unmarshal([]byte(cm.Data[configMapStateKey]), &state); err != nil {
+		return nil, fmt.Errorf("invalid state in ConfigMap %s/%s: %w", c.cfg.Namespace, c.cfg.Name, err)
+	}
+	return state, nil
+}
+
+// Save creates or updates the ConfigMap with the state.
+func (c *ConfigMapState) Save(ctx context.Context, state map[string]time.Time) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	cm, err := c.get(ctx)
+	if err != nil {
+		return fmt.Errorf("state ConfigMap: %w", err)
+	}
+	method, path, expected := http.MethodPut, c.path()+"/"+url.PathEscape(c.cfg.Name), http.StatusOK
+	if cm == nil {
+		method, path, expected = http.MethodPost, c.path(), http.StatusCreated
+		cm = &configMap{
+			APIVersion: "v1",
+			Kind:       "ConfigMap",
+			ObjectMeta: metav1.ObjectMeta{Name: c.cfg.Name, Namespace: c.cfg.Namespace},
+		}
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[configMapStateKey] = string(b)
+	// the update fails with a conflict if the ConfigMap changed since it was read, it's saved again later
+	status, b, err := c.client.do(ctx, method, path, cm)
+	if err != nil {
+		return fmt.Errorf("state ConfigMap: %w", err)
+	}
+	if status != expected {
+		return fmt.Errorf("state ConfigMap: %w", kubeError(status, b))
+	}
+	return nil
+}
+
+// validateCatchUp checks the catch up mode.
+func (s *Scheduler) validateCatchUp() error {
+	switch s.CatchUp {
+	case "", CatchUpSkip, CatchUpBackfill:
+		return nil
+	}
+	return fmt.Errorf("invalid catch up mode %q, must be %s or %s", s.CatchUp, CatchUpSkip, CatchUpBackfill)
+}
+
+// loadState returns the state saved by a previous run of the scheduler, none if it doesn't have a store.
+func (s *Scheduler) loadState(ctx context.Context) (map[string]time.Time, error) {
+	if s.State == nil {
+		return nil, nil
+	}
+	state, err := s.State.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("loading the state: %w", err)
+	}
+	log().Debug("loaded the state", "queries", len(state))
+	return state, nil
+}
+
+// state returns the time of the last successful run of each of the configured queries, keeping the saved one
+// of the queries that didn't succeed since the scheduler started, s.mu must be held.
+func (s *Scheduler) state() map[string]time.Time {
+	state := make(map[string]time.Time, len(s.Queries))
+	for name := range s.Queries {
+		if t, ok := s.saved[name]; ok {
+			state[name] = t
+		}
+	}
+	for name, j := range s.jobs {
+		j.mu.Lock()
+		if t := j.status.LastSuccess; !t.IsZero() {
+			state[name] = t.UTC()
+		}
+		j.mu.Unlock()
+	}
+	return state
+}
+
+// saveState saves the state if it changed since it was last saved.
+func (s *Scheduler) saveState(ctx context.Context) {
+	s.mu.Lock()
+	state := s.state()
+	s.mu.Unlock()
+	if reflect.DeepEqual(state, s.saved) {
+		return
+	}
+	if err := s.State.Save(ctx, state); err != nil {
+		log().Warn("failed to save the state", "error", err)
+		return
+	}
+	s.mu.Lock()
+	s.saved = state
+	s.mu.Unlock()
+}
+
+// persist saves the state every stateSaveInterval until done is closed, and once more after it.
+func (s *Scheduler) persist(done <-chan struct{}) {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			s.saveState(ctx)
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			s.saveState(ctx)
+			cancel()
+		}
+	}
+}
+
+// catchUp handles the activations of a query missed since its last successful run, before a restart,
+// returning when it should run next.
+func (s *Scheduler) catchUp(ctx context.Context, j *job, sched Schedule, last time.Time) time.Time {
+	now := time.Now()
+	next := sched.Next(last)
+	if !next.Before(now) {
+		log().Debug("query ran before the restart, waiting for its next activation", "query", j.name, "last_success", last)
+		return next
+	}
+	if s.CatchUp != CatchUpBackfill {
+		return now
+	}
+	step := j.query.Interval.Duration
+	switch {
+	case !j.query.IsPromQL():
+		log().Info("only PromQL queries can be backfilled, running it instead", "query", j.name, "type", j.query.Type)
+		return now
+	case step <= 0:
+		log().Info("only queries with an interval can be backfilled, running it instead", "query", j.name)
+		return now
+	}
+	// the evaluations before now are backfilled, the one at now is the next run
+	end := now.Add(-time.Millisecond)
+	log().Info("backfilling the missed runs", "query", j.name, "from", next, "to", end, "step", step)
+	if err := s.backfill(ctx, j.name, j.query, j.changes, j.sink, next, end, step); err != nil && ctx.Err() == nil {
+		log().Warn("failed to backfill the missed runs", "query", j.name, "error", err)
+	}
+	return firstRun(sched, time.Now())
+}
@@ -0,0 +1,126 @@
+package prom2log
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// QueryStatus describes a scheduled query and the outcome of its last run.
+type QueryStatus struct {
+	Name     string `json:"name"`
+	Server   string `json:"server"`
+	Schedule string `json:"schedule"`
+	// LastRun is the time the last run finished, it's zero if the query didn't run yet.
+	LastRun time.Time `json:"last_run"`
+	// LastSuccess is the time the last successful run finished.
+	LastSuccess time.Time `json:"last_success"`
+	// Duration of the last run, in seconds.
+	Duration float64 `json:"duration_seconds"`
+	// Samples is the number of samples returned by the last run.
+	Samples int `json:"samples"`
+	// Error of the last run, if it failed.
+	Error string `json:"error,omitempty"`
+	Runs  int    `json:"runs"`
+	// Paused is set for queries that only run when triggered.
+	Paused bool `json:"paused,omitempty"`
+}
+
+// DescribeSchedule returns a human readable description of when the query runs.
+func (q *Query) DescribeSchedule() string {
+	var parts []string
+	switch {
+	case q.Schedule != "":
+		parts = append(parts, q.Schedule)
+		if q.Timezone != "" {
+			parts = append(parts, q.Timezone)
+		}
+	case q.Interval.Duration > 0:
+		parts = append(parts, "every "+q.Interval.Duration.String())
+		if q.Align {
+			parts = append(parts, "aligned")
+		}
+	default:
+		return ""
+	}
+	if q.Jitter.Duration > 0 {
+		parts = append(parts, "jitter "+q.Jitter.Duration.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Running reports whether the scheduler started running the queries.
+func (s *Scheduler) Running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs != nil
+}
+
+// Status returns the status of the running queries sorted by name.
+func (s *Scheduler) Status() []QueryStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := make([]QueryStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		j.mu.Lock()
+		status = append(status, j.status)
+		j.mu.Unlock()
+	}
+	sort.Slice(status, func(i, k int) bool {
+		return status[i].Name < status[k].Name
+	})
+	return status
+}
+
+// record updates the status and metrics of the job with the outcome of a run, made of one record per server
+// for fanout queries. The run failed if any of its records has an error. The records of streamed runs don't
+// hold their results, streamed is the number of samples they emitted.
+func (j *job) record(records []Record, streamed int, duration time.Duration) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Runs++
+	j.status.LastRun = records[len(records)-1].Time
+	j.status.Duration = duration.Seconds()
+	var (
+		errs    []string
+		samples = streamed
+		err     error
+	)
+	for _, r := range records {
+		s, rerr := r.Samples()
+		samples += len(s)
+		if rerr == nil {
+			continue
+		}
+		if err == nil {
+			err = rerr
+		}
+		if r.Source != "" {
+			errs = append(errs, r.Source+": "+rerr.Error())
+		} else {
+			errs = append(errs, rerr.Error())
+		}
+	}
+	j.status.Error = strings.Join(errs, "; ")
+	j.status.Samples = samples
+	if err == nil {
+		j.status.LastSuccess = j.status.LastRun
+		log().Debug("query succeeded", "query", j.name, "duration", duration, "samples", samples)
+	} else {
+		log().Warn("query failed", "query", j.name, "duration", duration, "error", j.status.Error)
+	}
+	observeRun(j.name, duration, samples, err)
+}
+
+// Pending returns the number of records buffered by each of the sinks that buffer them.
+func (s *Scheduler) Pending() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := make(map[string]int)
+	for name, sink := range s.Sinks {
+		if b, ok := sink.(BufferedSink); ok {
+			pending[name] = b.Pending()
+		}
+	}
+	return pending
+}
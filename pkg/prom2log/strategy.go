@@ -0,0 +1,124 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// StrategyFailover sends the query to the first of its servers, trying the next one when a request fails.
+	StrategyFailover = "failover"
+	// StrategyFanout sends the query to all its servers, emitting one record per server tagged with its source.
+	StrategyFanout = "fanout"
+
+	// FieldSource is the field set to the server the records of fanout queries come from.
+	FieldSource = "source"
+)
+
+// Strategies lists the supported ways of using the servers of queries with several of them.
+var Strategies = []string{StrategyFailover, StrategyFanout}
+
+func (q *Query) validateServers() error {
+	if len(q.Servers) == 0 {
+		if q.Strategy != "" {
+			return errors.New("strategy requires servers")
+		}
+		return nil
+	}
+	if q.Server != "" {
+		return errors.New("server and servers are mutually exclusive")
+	}
+	if q.Strategy != "" && !contains(Strategies, q.Strategy) {
+		return fmt.Errorf("unknown strategy %q", q.Strategy)
+	}
+	return nil
+}
+
+// serverNames returns the servers of the query, as configured.
+func (q *Query) serverNames() []string {
+	if len(q.Servers) > 0 {
+		return q.Servers
+	}
+	return []string{q.Server}
+}
+
+// backends returns the servers the query is sent to, in order, creating them from their URLs
+// if the query wasn't bound to them, see BindServers.
+func (q *Query) backends() ([]*Server, error) {
+	if len(q.Servers) == 0 {
+		if q.server == nil {
+			s, err := NewServer(ServerConfig{URL: q.Server})
+			if err != nil {
+				return nil, err
+			}
+			q.server = s
+		}
+		return []*Server{q.server}, nil
+	}
+	if len(q.servers) != len(q.Servers) {
+		servers := make([]*Server, len(q.Servers))
+		for i, u := range q.Servers {
+			s, err := NewServer(ServerConfig{URL: u})
+			if err != nil {
+				return nil, fmt.Errorf("server %s: %w", u, err)
+			}
+			servers[i] = s
+		}
+		q.servers = servers
+	}
+	return q.servers, nil
+}
+
+// sameServers reports whether both queries are bound to the same servers.
+func (q *Query) sameServers(o *Query) bool {
+	if q.server != o.server || len(q.servers) != len(o.servers) {
+		return false
+	}
+	for i := range q.servers {
+		if q.servers[i] != o.servers[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// targets returns the queries to run, one per server for fanout queries, otherwise the query itself.
+func (q *Query) targets() []Query {
+	if q.Strategy != StrategyFanout {
+		return []Query{*q}
+	}
+	targets := make([]Query, len(q.Servers))
+	for i, name := range q.Servers {
+		t := *q
+		t.Server, t.Servers, t.Strategy = name, nil, ""
+		t.server, t.servers = nil, nil
+		if len(q.servers) == len(q.Servers) {
+			t.server = q.servers[i]
+		}
+		targets[i] = t
+	}
+	return targets
+}
+
+// RunAll runs the query on its servers, concurrently, returning one record per server, with its Source set,
+// for fanout queries and the single record returned by Run otherwise.
+func (q *Query) RunAll(ctx context.Context, name string) []Record {
+	if q.Strategy != StrategyFanout {
+		return []Record{q.Run(ctx, name)}
+	}
+	targets := q.targets()
+	records := make([]Record, len(targets))
+	var wg sync.WaitGroup
+	for i := range targets {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			records[i] = targets[i].Run(ctx, name)
+			records[i].Source = targets[i].Server
+		}(i)
+	}
+	wg.Wait()
+	return records
+}
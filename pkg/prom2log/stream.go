@@ -0,0 +1,226 @@
+package prom2log
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// partialError is returned by streamed requests that failed after some of their records were emitted,
+// they aren't retried, on the same server or the next one, so the records aren't emitted twice.
+type partialError struct {
+	err error
+}
+
+func (e *partialError) Error() string {
+	return "partial result: " + e.err.Error()
+}
+
+func (e *partialError) Unwrap() error {
+	return e.err
+}
+
+// StreamResult decodes the response of a query while it's read, calling fn with each series of the result,
+// as a model.Vector or model.Matrix holding only that series, or with the whole result for scalars and strings.
+// It returns the warnings of the response, responses with an error status are returned as an *APIError.
+func StreamResult(r io.Reader, fn func(model.Value) error) ([]string, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	var resp apiResponse
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return resp.Warnings, err
+		}
+		switch key {
+		case "status":
+			err = dec.Decode(&resp.Status)
+		case "errorType":
+			err = dec.Decode(&resp.ErrorType)
+		case "error":
+			err = dec.Decode(&resp.Error)
+		case "warnings":
+			err = dec.Decode(&resp.Warnings)
+		case "data":
+			if resp.Status != "" && resp.Status != "success" {
+				err = dec.Decode(&resp.Data)
+				break
+			}
+			if err = streamData(dec, fn); err != nil {
+				return resp.Warnings, err
+			}
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return resp.Warnings, fmt.Errorf("invalid response: %w", err)
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return resp.Warnings, err
+	}
+	if resp.Status != "success" {
+		return resp.Warnings, &APIError{Type: resp.ErrorType, Message: resp.Error}
+	}
+	return resp.Warnings, nil
+}
+
+// streamData decodes the data of a query response, calling fn with each series of the result.
+func streamData(dec *json.Decoder, fn func(model.Value) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	var (
+		resultType model.ValueType
+		// result holds the result when it comes before its type, which Prometheus doesn't do
+		result json.RawMessage
+	)
+	for dec.More() {
+		key, err := objectKey(dec)
+		if err != nil {
+			return err
+		}
+		switch {
+		case key == "resultType":
+			err = dec.Decode(&resultType)
+		case key == "result" && resultType == model.ValNone:
+			err = dec.Decode(&result)
+		case key == "result":
+			if err := streamSeries(dec, resultType, fn); err != nil {
+				return err
+			}
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid result: %w", err)
+		}
+	}
+	if result != nil {
+		v, err := decodeValue(resultType, result)
+		if err != nil {
+			return fmt.Errorf("invalid %s result: %w", resultType, err)
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// streamSeries decodes a result of the given type, calling fn with each of its series.
+func streamSeries(dec *json.Decoder, resultType model.ValueType, fn func(model.Value) error) error {
+	switch resultType {
+	case model.ValVector, model.ValMatrix:
+	case model.ValScalar, model.ValString:
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("invalid %s result: %w", resultType, err)
+		}
+		v, err := decodeValue(resultType, raw)
+		if err != nil {
+			return fmt.Errorf("invalid %s result: %w", resultType, err)
+		}
+		return fn(v)
+	default:
+		return fmt.Errorf("unsupported result type %q", resultType)
+	}
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		var v model.Value
+		if resultType == model.ValVector {
+			var s model.Sample
+			if err := dec.Decode(&s); err != nil {
+				return fmt.Errorf("invalid %s result: %w", resultType, err)
+			}
+			v = model.Vector{&s}
+		} else {
+			var s model.SampleStream
+			if err := dec.Decode(&s); err != nil {
+				return fmt.Errorf("invalid %s result: %w", resultType, err)
+			}
+			v = model.Matrix{&s}
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if t != d {
+		return fmt.Errorf("invalid response: expected %q, got %v", d, t)
+	}
+	return nil
+}
+
+func objectKey(dec *json.Decoder) (string, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return "", fmt.Errorf("invalid response: %w", err)
+	}
+	key, ok := t.(string)
+	if !ok {
+		return "", fmt.Errorf("invalid response: expected a key, got %v", t)
+	}
+	return key, nil
+}
+
+// RunStream runs the query like Run, but decodes the response while it's received and calls fn with a record per
+// series of the result, so the memory used doesn't depend on the size of the result. It returns the number of
+// samples and the warnings of the response. Requests failing once fn was called aren't retried, nor sent to the
+// next server, so the records aren't emitted twice, their error is a partial result one.
+func (q *Query) RunStream(ctx context.Context, name string, fn func(Record) error) (int, []string, error) {
+	path, params, err := q.params(time.Now())
+	if err != nil {
+		return 0, nil, err
+	}
+	expr, err := q.expr(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	params.Set("query", expr)
+	var (
+		samples  int
+		warnings []string
+	)
+	err = q.fetch(ctx, path, params, func(body io.Reader) error {
+		now := time.Now()
+		emitted := false
+		var err error
+		warnings, err = StreamResult(body, func(v model.Value) error {
+			v, err := q.relabelResult(v)
+			if err != nil {
+				return err
+			}
+			r := Record{Time: now, Name: name, Result: v}
+			s, err := r.Samples()
+			if err == nil && len(s) == 0 {
+				return nil
+			}
+			samples += len(s)
+			emitted = true
+			return fn(r)
+		})
+		if err != nil && emitted {
+			return &permanentError{err: &partialError{err: err}}
+		}
+		return err
+	})
+	return samples, warnings, err
+}
@@ -0,0 +1,107 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestStreamResult(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		want         []string
+		wantWarnings int
+		wantErr      bool
+	}{
+		{
+			name: "vector",
+			body: `{"status": "success", "warnings": ["w"], "data": {"resultType": "vector", "result": [{"metric": {"n": "a"}, "value": [1, "1"]}, {"metric": {"n": "b"}, "value": [1, "2"]}]}}`,
+			want: []string{"vector", "vector"}, wantWarnings: 1,
+		},
+		{
+			name: "matrix",
+			body: `{"status": "success", "data": {"resultType": "matrix", "result": [{"metric": {}, "values": [[1, "1"], [2, "2"]]}]}}`,
+			want: []string{"matrix"},
+		},
+		{
+			name: "scalar",
+			body: `{"status": "success", "data": {"resultType": "scalar", "result": [1, "1"]}}`,
+			want: []string{"scalar"},
+		},
+		{
+			name: "result before its type",
+			body: `{"status": "success", "data": {"result": [{"metric": {}, "value": [1, "1"]}], "resultType": "vector"}}`,
+			want: []string{"vector"},
+		},
+		{
+			name:    "error status",
+			body:    `{"status": "error", "errorType": "bad_data", "error": "parse error", "data": {}}`,
+			wantErr: true,
+		},
+		{
+			name:    "truncated",
+			body:    `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1, "1"]}, {"metric"`,
+			want:    []string{"vector"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			warnings, err := StreamResult(strings.NewReader(tt.body), func(v model.Value) error {
+				got = append(got, v.Type().String())
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("StreamResult() = %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got values %v, want %v", got, tt.want)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("got %d warnings, want %d", len(warnings), tt.wantWarnings)
+			}
+		})
+	}
+}
+
+// TestRunStreamPartial checks that a response failing after some records were emitted isn't sent to the next server.
+func TestRunStreamPartial(t *testing.T) {
+	truncated := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1, "1"]}, {"metric"`)
+	}))
+	defer truncated.Close()
+	var fallbacks atomic.Int32
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fallbacks.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": []}}`)
+	}))
+	defer fallback.Close()
+
+	q := Query{Servers: []string{truncated.URL, fallback.URL}, PromQL: "up"}
+	records := 0
+	samples, _, err := q.RunStream(context.Background(), "up", func(Record) error {
+		records++
+		return nil
+	})
+	var partial *partialError
+	if !errors.As(err, &partial) {
+		t.Fatalf("RunStream() = %v, want a partial result error", err)
+	}
+	if records != 1 || samples != 1 {
+		t.Errorf("got %d records and %d samples, want 1", records, samples)
+	}
+	if n := fallbacks.Load(); n != 0 {
+		t.Errorf("sent %d requests to the next server, want none", n)
+	}
+}
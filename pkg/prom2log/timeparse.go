@@ -0,0 +1,50 @@
+package prom2log
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// ParseTime parses an absolute or relative time.
+// Absolute times are either RFC3339 or Unix timestamps in seconds,
+// relative times are "now" optionally followed by an offset, e.g. now-1h or now+30m.
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(s, "now") {
+		offset := s[len("now"):]
+		sign := time.Duration(1)
+		switch offset[0] {
+		case '-':
+			sign = -1
+		case '+':
+		default:
+			return time.Time{}, fmt.Errorf("invalid relative time %q", s)
+		}
+		d, err := model.ParseDuration(offset[1:])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid relative time %q: %w", s, err)
+		}
+		return now.Add(sign * time.Duration(d)), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// formatTime formats t as expected by the Prometheus API.
+func formatTime(t time.Time) string {
+	return strconv.FormatFloat(float64(t.UnixNano())/1e9, 'f', -1, 64)
+}
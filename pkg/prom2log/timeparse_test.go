@@ -0,0 +1,43 @@
+package prom2log
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "", want: now},
+		{in: "now", want: now},
+		{in: " now ", want: now},
+		{in: "now-1h", want: now.Add(-time.Hour)},
+		{in: "now+30m", want: now.Add(30 * time.Minute)},
+		{in: "now-1d", want: now.Add(-24 * time.Hour)},
+		{in: "now-1h30m", want: now.Add(-90 * time.Minute)},
+		{in: "2024-01-01T00:00:00Z", want: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{in: "2024-01-01T00:00:00.5+01:00", want: time.Date(2023, 12, 31, 23, 0, 0, 5e8, time.UTC)},
+		{in: "1700000000", want: time.Unix(1700000000, 0)},
+		{in: "1700000000.25", want: time.Unix(1700000000, 25e7)},
+		{in: "now1h", wantErr: true},
+		{in: "now-", wantErr: true},
+		{in: "now-1x", wantErr: true},
+		{in: "yesterday", wantErr: true},
+		{in: "2024-01-01", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseTime(tt.in, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTime(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Errorf("ParseTime(%q) = %s, want %s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package prom2log
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layouts of the time field of the records, besides Go time layouts.
+const (
+	TimeRFC3339     = "rfc3339"
+	TimeRFC3339Nano = "rfc3339nano"
+	TimeUnix        = "unix"
+	TimeUnixMilli   = "unix_ms"
+)
+
+// TimestampConfig configures the time field of the records.
+type TimestampConfig struct {
+	// Format is the layout of the time, one of rfc3339, rfc3339nano, unix (seconds with millisecond precision),
+	// unix_ms or a Go time layout, e.g. "2006-01-02 15:04:05". Defaults to the Go time.Time string representation.
+	Format string `json:"format,omitempty"`
+	// Timezone is the IANA name of the timezone the time is rendered in, e.g. UTC, defaults to the local timezone.
+	Timezone string `json:"timezone,omitempty"`
+	// Sample sets the time to the timestamp of the samples instead of the time the query ran.
+	Sample bool `json:"sample,omitempty"`
+}
+
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+func (c *TimestampConfig) validate() error {
+	if c == nil || c.Timezone == "" {
+		return nil
+	}
+	_, err := loadLocation(c.Timezone)
+	return err
+}
+
+// isNumber reports whether the time is rendered as a number.
+func (c *TimestampConfig) isNumber() bool {
+	return c != nil && (c.Format == TimeUnix || c.Format == TimeUnixMilli)
+}
+
+func (c *TimestampConfig) format(t time.Time) string {
+	if c == nil {
+		return t.String()
+	}
+	if c.Timezone != "" {
+		if loc, err := loadLocation(c.Timezone); err == nil {
+			t = t.In(loc)
+		}
+	}
+	switch strings.ToLower(c.Format) {
+	case "":
+		return t.String()
+	case TimeRFC3339:
+		return t.Format(time.RFC3339)
+	case TimeRFC3339Nano:
+		return t.Format(time.RFC3339Nano)
+	case TimeUnix:
+		return unixSeconds(t)
+	case TimeUnixMilli:
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	default:
+		return t.Format(c.Format)
+	}
+}
+
+// timeString renders the time of the record.
+func (r Record) timeString() string {
+	return r.Timestamp.format(r.Time)
+}
+
+// timeJSON renders the time of the record as a JSON value.
+func (r Record) timeJSON() string {
+	if r.Timestamp.isNumber() {
+		return r.timeString()
+	}
+	return jsonString(r.timeString())
+}
+
+// sampleTime returns the timestamp of the samples of the record, or its time if it has none.
+func (r Record) sampleTime() time.Time {
+	if r.Err != nil {
+		return r.Time
+	}
+	samples, err := r.Samples()
+	if err != nil || len(samples) == 0 || samples[0].Timestamp.IsZero() {
+		return r.Time
+	}
+	return samples[0].Timestamp
+}
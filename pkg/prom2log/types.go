@@ -0,0 +1,228 @@
+package prom2log
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// TypePromQL queries evaluate their PromQL expression, it's the default type.
+	TypePromQL = "promql"
+	// TypeAlerts queries poll the active alerts, emitting a series per alert with its labels and an alertstate
+	// label, firing or pending, like the ALERTS metric. The value is the one of the alert expression and the
+	// timestamp when it became active, the annotations are added as labels prefixed with annotation_.
+	TypeAlerts = "alerts"
+	// TypeRules queries poll the recording and alerting rules, emitting a series per rule with its labels and the
+	// rule_group, rule_file, rule, rule_type, health, last_error and, for alerting rules, state labels.
+	// The value is the duration of the last evaluation in seconds and the timestamp when it happened.
+	TypeRules = "rules"
+	// TypeExemplars queries poll the exemplars of the series selected by their PromQL between Start and End, which
+	// are required. They emit a record per exemplar, with the labels of its series and its own, like trace_id.
+	TypeExemplars = "exemplars"
+
+	alertsPath    = "/api/v1/alerts"
+	rulesPath     = "/api/v1/rules"
+	exemplarsPath = "/api/v1/query_exemplars"
+)
+
+// Types lists the supported query types.
+var Types = []string{TypePromQL, TypeAlerts, TypeRules, TypeExemplars}
+
+// IsPromQL reports whether the query evaluates a PromQL expression.
+func (q *Query) IsPromQL() bool {
+	return q.Type == "" || q.Type == TypePromQL
+}
+
+func (q *Query) validateType() error {
+	if q.Type != "" && !contains(Types, q.Type) {
+		return fmt.Errorf("unknown type %q", q.Type)
+	}
+	if q.IsPromQL() {
+		return nil
+	}
+	if q.Type == TypeExemplars {
+		switch {
+		case q.PromQL == "":
+			return errors.New("promQL is required on exemplars queries")
+		case !q.IsRange():
+			return errors.New("start is required on exemplars queries")
+		case q.Time != "" || q.Step.Duration > 0:
+			return errors.New("time and step can't be set on exemplars queries")
+		case q.Stream:
+			return errors.New("stream can't be set on exemplars queries")
+		}
+		return nil
+	}
+	switch {
+	case q.PromQL != "":
+		return fmt.Errorf("promQL can't be set on %s queries", q.Type)
+	case q.IsRange() || q.Time != "":
+		return fmt.Errorf("start and time can't be set on %s queries", q.Type)
+	case q.Stream:
+		return fmt.Errorf("stream can't be set on %s queries", q.Type)
+	}
+	return nil
+}
+
+// alert is an entry of the alerts API response.
+type alert struct {
+	Labels      model.LabelSet `json:"labels"`
+	Annotations model.LabelSet `json:"annotations"`
+	State       string         `json:"state"`
+	ActiveAt    *time.Time     `json:"activeAt,omitempty"`
+	Value       string         `json:"value"`
+}
+
+// ruleGroup is an entry of the rules API response.
+type ruleGroup struct {
+	Name  string `json:"name"`
+	File  string `json:"file"`
+	Rules []rule `json:"rules"`
+}
+
+type rule struct {
+	Name           string         `json:"name"`
+	Labels         model.LabelSet `json:"labels,omitempty"`
+	State          string         `json:"state,omitempty"`
+	Health         string         `json:"health"`
+	LastError      string         `json:"lastError,omitempty"`
+	Type           string         `json:"type"`
+	EvaluationTime float64        `json:"evaluationTime"`
+	LastEvaluation time.Time      `json:"lastEvaluation"`
+}
+
+// parseResponse decodes the response of the query, according to its type.
+func (q *Query) parseResponse(b []byte) (model.Value, []string, error) {
+	switch q.Type {
+	case TypeAlerts:
+		return parseAlerts(b)
+	case TypeRules:
+		return parseRules(b)
+	case TypeExemplars:
+		return parseExemplars(b)
+	}
+	return ParseResult(b)
+}
+
+// parseAlerts decodes the response of the alerts API into a vector with a sample per alert.
+func parseAlerts(b []byte) (model.Value, []string, error) {
+	resp, err := parseAPIResponse(b)
+	if err != nil {
+		return nil, resp.Warnings, err
+	}
+	var data struct {
+		Alerts []alert `json:"alerts"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return nil, resp.Warnings, fmt.Errorf("invalid alerts: %w", err)
+	}
+	v := make(model.Vector, 0, len(data.Alerts))
+	for _, a := range data.Alerts {
+		m := make(model.Metric, len(a.Labels)+len(a.Annotations)+1)
+		for k, v := range a.Annotations {
+			m["annotation_"+k] = v
+		}
+		for k, v := range a.Labels {
+			m[k] = v
+		}
+		m["alertstate"] = model.LabelValue(a.State)
+		s := &model.Sample{Metric: m}
+		if a.Value != "" {
+			f, err := strconv.ParseFloat(a.Value, 64)
+			if err != nil {
+				return nil, resp.Warnings, fmt.Errorf("invalid value of alert %s: %w", a.Labels[model.AlertNameLabel], err)
+			}
+			s.Value = model.SampleValue(f)
+		}
+		if a.ActiveAt != nil && !a.ActiveAt.IsZero() {
+			s.Timestamp = model.TimeFromUnixNano(a.ActiveAt.UnixNano())
+		}
+		v = append(v, s)
+	}
+	return v, resp.Warnings, nil
+}
+
+// parseRules decodes the response of the rules API into a vector with a sample per rule.
+func parseRules(b []byte) (model.Value, []string, error) {
+	resp, err := parseAPIResponse(b)
+	if err != nil {
+		return nil, resp.Warnings, err
+	}
+	var data struct {
+		Groups []ruleGroup `json:"groups"`
+	}
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return nil, resp.Warnings, fmt.Errorf("invalid rules: %w", err)
+	}
+	var v model.Vector
+	for _, g := range data.Groups {
+		for _, r := range g.Rules {
+			m := make(model.Metric, len(r.Labels)+7)
+			for k, v := range r.Labels {
+				m[k] = v
+			}
+			m["rule_group"] = model.LabelValue(g.Name)
+			m["rule_file"] = model.LabelValue(g.File)
+			m["rule"] = model.LabelValue(r.Name)
+			m["rule_type"] = model.LabelValue(r.Type)
+			m["health"] = model.LabelValue(r.Health)
+			if r.LastError != "" {
+				m["last_error"] = model.LabelValue(r.LastError)
+			}
+			if r.State != "" {
+				m["state"] = model.LabelValue(r.State)
+			}
+			s := &model.Sample{Metric: m, Value: model.SampleValue(r.EvaluationTime)}
+			// rules that weren't evaluated yet have a zero time
+			if !r.LastEvaluation.IsZero() {
+				s.Timestamp = model.TimeFromUnixNano(r.LastEvaluation.UnixNano())
+			}
+			v = append(v, s)
+		}
+	}
+	if v == nil {
+		v = model.Vector{}
+	}
+	return v, resp.Warnings, nil
+}
+
+// exemplarSeries is an entry of the query_exemplars API response.
+type exemplarSeries struct {
+	SeriesLabels model.LabelSet `json:"seriesLabels"`
+	Exemplars    []struct {
+		Labels    model.LabelSet    `json:"labels"`
+		Value     model.SampleValue `json:"value"`
+		Timestamp model.Time        `json:"timestamp"`
+	} `json:"exemplars"`
+}
+
+// parseExemplars decodes the response of the query_exemplars API into a vector with a sample per exemplar.
+func parseExemplars(b []byte) (model.Value, []string, error) {
+	resp, err := parseAPIResponse(b)
+	if err != nil {
+		return nil, resp.Warnings, err
+	}
+	var data []exemplarSeries
+	if err := json.Unmarshal(resp.Data, &data); err != nil {
+		return nil, resp.Warnings, fmt.Errorf("invalid exemplars: %w", err)
+	}
+	v := model.Vector{}
+	for _, series := range data {
+		for _, e := range series.Exemplars {
+			m := make(model.Metric, len(series.SeriesLabels)+len(e.Labels))
+			for k, v := range series.SeriesLabels {
+				m[k] = v
+			}
+			for k, v := range e.Labels {
+				m[k] = v
+			}
+			v = append(v, &model.Sample{Metric: m, Value: e.Value, Timestamp: e.Timestamp})
+		}
+	}
+	return v, resp.Warnings, nil
+}
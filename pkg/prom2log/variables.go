@@ -0,0 +1,238 @@
+package prom2log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Variable is a value that can be used in the PromQL of the queries with {{ var "name" }}.
+// One of Value, Env or Query must be set, a plain string is decoded as a Value.
+type Variable struct {
+	// Value is a static value.
+	Value string `json:"value,omitempty"`
+	// Env is the name of the environment variable holding the value.
+	Env string `json:"env,omitempty"`
+	// Query is a PromQL expression evaluated when a query using the variable runs, unless it was within Refresh.
+	// Its value is the one of the scalar or single sample the query returns, or with Label, the distinct values
+	// of that label in the result, sorted and joined with "|" to be used in regular expression matchers,
+	// see labelRegexp.
+	Query string `json:"query,omitempty"`
+	// Server is the URL or the name of the server Query is sent to, defaults to the server of the query using it.
+	Server string `json:"server,omitempty"`
+	// Label is the label the values are taken from.
+	Label string `json:"label,omitempty"`
+	// Refresh is how long the value of Query is reused by the queries using the variable on the same server,
+	// defaults to 1m.
+	Refresh metav1.Duration `json:"refresh,omitempty"`
+}
+
+// defaultVariableRefresh is how long the value of a variable query is reused by default.
+const defaultVariableRefresh = time.Minute
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *Variable) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*v = Variable{Value: s}
+		return nil
+	}
+	type variable Variable
+	return json.Unmarshal(b, (*variable)(v))
+}
+
+// Validate checks the variable configuration.
+func (v Variable) Validate() error {
+	set := 0
+	for _, s := range []string{v.Value, v.Env, v.Query} {
+		if s != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("exactly one of value, env or query must be set")
+	}
+	if v.Query == "" && (v.Server != "" || v.Label != "" || v.Refresh.Duration != 0) {
+		return errors.New("server, label and refresh require query")
+	}
+	if v.Refresh.Duration < 0 {
+		return errors.New("refresh can't be negative")
+	}
+	return nil
+}
+
+// variables holds the variables the queries can use.
+type variables struct {
+	defs map[string]Variable
+	// servers are the servers of the variables with their own.
+	servers map[string]*Server
+
+	mu sync.Mutex
+	// values are the last values of the variable queries, by variable and server, see cacheKey.
+	values map[string]cachedValue
+}
+
+// cachedValue is the value of a variable query and when it was evaluated.
+type cachedValue struct {
+	value string
+	at    time.Time
+}
+
+// BindVariables makes the variables available to the given queries, the variables with a query are sent
+// to the given servers, like the queries with BindServers.
+func BindVariables(queries map[string]Query, vars map[string]Variable, servers map[string]*Server) error {
+	varQueries := make(map[string]Query)
+	for name, v := range vars {
+		if err := v.Validate(); err != nil {
+			return fmt.Errorf("variable %s: %w", name, err)
+		}
+		if v.Server != "" {
+			varQueries[name] = Query{Server: v.Server}
+		}
+	}
+	if err := BindServers(varQueries, servers); err != nil {
+		return err
+	}
+	b := &variables{defs: vars, servers: make(map[string]*Server, len(varQueries)), values: make(map[string]cachedValue)}
+	for name, q := range varQueries {
+		b.servers[name] = q.server
+	}
+	for name, q := range queries {
+		q.vars = b
+		queries[name] = q
+	}
+	return nil
+}
+
+// equal reports whether both hold the same variables, regardless of their cached values.
+func (vs *variables) equal(o *variables) bool {
+	return reflect.DeepEqual(vs.defs, o.defs) && reflect.DeepEqual(vs.servers, o.servers)
+}
+
+// resolve returns the value of a variable for the given query.
+func (vs *variables) resolve(ctx context.Context, q *Query, name string) (string, error) {
+	var (
+		v  Variable
+		ok bool
+	)
+	if vs != nil {
+		v, ok = vs.defs[name]
+	}
+	if !ok {
+		return "", fmt.Errorf("unknown variable %q", name)
+	}
+	switch {
+	case v.Env != "":
+		value, ok := os.LookupEnv(v.Env)
+		if !ok {
+			return "", fmt.Errorf("variable %s: environment variable %s isn't set", name, v.Env)
+		}
+		return value, nil
+	case v.Query == "":
+		return v.Value, nil
+	}
+
+	// variables of queries with several servers use them for failover, even when the query fans out
+	vq := Query{Server: q.Server, Servers: q.Servers, PromQL: v.Query, Timeout: q.Timeout, Retry: q.Retry, server: q.server, servers: q.servers}
+	if v.Server != "" {
+		vq.Server, vq.Servers, vq.server, vq.servers = v.Server, nil, vs.servers[name], nil
+	}
+	refresh := v.Refresh.Duration
+	if refresh == 0 {
+		refresh = defaultVariableRefresh
+	}
+	key := name + "\x00" + strings.Join(vq.serverNames(), ",")
+	vs.mu.Lock()
+	cached, ok := vs.values[key]
+	vs.mu.Unlock()
+	if ok && time.Since(cached.at) < refresh {
+		return cached.value, nil
+	}
+	value, err := vs.evaluate(ctx, name, v, vq)
+	if err != nil {
+		return "", err
+	}
+	vs.mu.Lock()
+	vs.values[key] = cachedValue{value: value, at: time.Now()}
+	vs.mu.Unlock()
+	return value, nil
+}
+
+// evaluate runs the query of a variable and returns its value.
+func (vs *variables) evaluate(ctx context.Context, name string, v Variable, vq Query) (string, error) {
+	samples, err := vq.Run(ctx, name).Samples()
+	if err != nil {
+		return "", fmt.Errorf("variable %s: %w", name, err)
+	}
+	if v.Label != "" {
+		seen := make(map[string]bool)
+		var values []string
+		for _, s := range samples {
+			if l := s.Metric[v.Label]; !seen[l] {
+				seen[l] = true
+				values = append(values, l)
+			}
+		}
+		sort.Strings(values)
+		return labelRegexp(values), nil
+	}
+	if len(samples) != 1 {
+		return "", fmt.Errorf("variable %s: expected a single sample, got %d", name, len(samples))
+	}
+	return strconv.FormatFloat(samples[0].Value, 'f', -1, 64), nil
+}
+
+// promQLString escapes the backslashes, double quotes and newlines of s for a PromQL double-quoted string.
+var promQLString = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// labelRegexp returns a regular expression matching any of the label values, to be used between the double quotes
+// of a matcher, e.g. namespace=~"...". The values are matched literally and escaped for the PromQL string.
+func labelRegexp(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = promQLString.Replace(regexp.QuoteMeta(v))
+	}
+	return strings.Join(quoted, "|")
+}
+
+// ExpandPromQL renders the PromQL of the query, calling lookup for the value of each variable it uses.
+func (q *Query) ExpandPromQL(lookup func(name string) (string, error)) (string, error) {
+	if !strings.Contains(q.PromQL, "{{") {
+		return q.PromQL, nil
+	}
+	t, err := template.New("promql").Funcs(template.FuncMap{"var": lookup}).Parse(q.PromQL)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, nil); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// expr returns the PromQL of the query with the values of its variables.
+func (q *Query) expr(ctx context.Context) (string, error) {
+	values := make(map[string]string)
+	return q.ExpandPromQL(func(name string) (string, error) {
+		if v, ok := values[name]; ok {
+			return v, nil
+		}
+		v, err := q.vars.resolve(ctx, q, name)
+		values[name] = v
+		return v, err
+	})
+}
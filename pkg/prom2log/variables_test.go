@@ -0,0 +1,139 @@
+package prom2log
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLabelRegexp(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{name: "none", want: ""},
+		{name: "plain", values: []string{"a", "b"}, want: "a|b"},
+		{name: "regexp metacharacters", values: []string{"a.b", "c|d", "(e)+"}, want: `a\\.b|c\\|d|\\(e\\)\\+`},
+		{name: "quotes and newlines", values: []string{`say "hi"`, "two\nlines"}, want: `say \"hi\"|two\nlines`},
+		{name: "backslashes", values: []string{`C:\dir`}, want: `C:\\\\dir`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := labelRegexp(tt.values); got != tt.want {
+				t.Errorf("labelRegexp(%q) = %s, want %s", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandPromQL(t *testing.T) {
+	vars := map[string]string{"job": "node", "ns": "a|b"}
+	lookup := func(name string) (string, error) {
+		if v, ok := vars[name]; ok {
+			return v, nil
+		}
+		return "", errors.New("unknown variable")
+	}
+	tests := []struct {
+		promql  string
+		want    string
+		wantErr bool
+	}{
+		{promql: `up{job="node"}`, want: `up{job="node"}`},
+		{promql: `up{job="{{ var "job" }}"}`, want: `up{job="node"}`},
+		{promql: `up{job="{{ var "job" }}", namespace=~"{{ var "ns" }}"}`, want: `up{job="node", namespace=~"a|b"}`},
+		{promql: `up{job="{{ var "missing" }}"}`, wantErr: true},
+		{promql: `up{job="{{ var "job" }"}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.promql, func(t *testing.T) {
+			q := Query{PromQL: tt.promql}
+			got, err := q.ExpandPromQL(lookup)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpandPromQL() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ExpandPromQL() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVariableValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       Variable
+		wantErr bool
+	}{
+		{name: "value", v: Variable{Value: "x"}},
+		{name: "env", v: Variable{Env: "X"}},
+		{name: "query", v: Variable{Query: "up", Label: "job", Server: "main"}},
+		{name: "none", v: Variable{}, wantErr: true},
+		{name: "several", v: Variable{Value: "x", Env: "X"}, wantErr: true},
+		{name: "label without query", v: Variable{Value: "x", Label: "job"}, wantErr: true},
+		{name: "refresh without query", v: Variable{Value: "x", Refresh: metav1.Duration{Duration: time.Minute}}, wantErr: true},
+		{name: "negative refresh", v: Variable{Query: "up", Refresh: metav1.Duration{Duration: -time.Minute}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.v.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVariableRefresh(t *testing.T) {
+	tests := []struct {
+		name    string
+		refresh time.Duration
+		want    int32
+	}{
+		{name: "default", want: 1},
+		{name: "expired", refresh: time.Nanosecond, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				n := requests.Add(1)
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"status": "success", "data": {"resultType": "scalar", "result": [1700000000, "%d"]}}`, n)
+			}))
+			defer prom.Close()
+			srv, err := NewServer(ServerConfig{URL: prom.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			servers := map[string]*Server{"main": srv}
+			queries := map[string]Query{
+				"a": {Server: "main", PromQL: `up > {{ var "threshold" }}`},
+				"b": {Server: "main", PromQL: `up < {{ var "threshold" }}`},
+			}
+			vars := map[string]Variable{"threshold": {Query: "scalar(up)", Refresh: metav1.Duration{Duration: tt.refresh}}}
+			if err := BindVariables(queries, vars, servers); err != nil {
+				t.Fatal(err)
+			}
+			if err := BindServers(queries, servers); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"a", "b", "a"} {
+				q := queries[name]
+				if _, err := q.expr(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := requests.Load(); got != tt.want {
+				t.Errorf("got %d variable queries, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"golang.org/x/exp/slog"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+// watch reloads the configuration of the scheduler when the process receives a SIGHUP,
+// the config file or the YAML files in the config dir change, calling reloaded, if set, with the new configuration.
+func watch(ctx context.Context, path, dir string, current *Configuration, scheduler *prom2log.Scheduler, reloaded func(*Configuration)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var (
+		events <-chan fsnotify.Event
+		errs   <-chan error
+	)
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	dirs := make(map[string]bool)
+	if _, err := os.Stat(path); err == nil {
+		// editors often replace the file instead of writing to it, so watch its directory instead
+		dirs[filepath.Dir(path)] = true
+	}
+	if dir != "" {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		dirs[dir] = true
+	}
+	if len(dirs) > 0 {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			slog.Error("failed to watch the config files", "error", err)
+		} else {
+			defer watcher.Close()
+			for d := range dirs {
+				if err := watcher.Add(d); err != nil {
+					slog.Error("failed to watch the config files", "error", err)
+				}
+			}
+			events, errs = watcher.Events, watcher.Errors
+		}
+	}
+	isConfig := func(name string) bool {
+		if name == path {
+			return true
+		}
+		ext := filepath.Ext(name)
+		return filepath.Dir(name) == dir && (ext == ".yaml" || ext == ".yml")
+	}
+
+	// changes to the file come in bursts of events, wait for them to settle before reloading
+	var settled <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-events:
+			if isConfig(ev.Name) && !ev.Has(fsnotify.Chmod) {
+				settled = time.After(200 * time.Millisecond)
+			}
+			continue
+		case err := <-errs:
+			slog.Error("failed to watch the config files", "error", err)
+			continue
+		case <-hup:
+		case <-settled:
+			settled = nil
+		}
+		next, err := reload(current, scheduler)
+		if err != nil {
+			slog.Error("failed to reload the configuration", "error", err)
+			continue
+		}
+		current = next
+		if reloaded != nil {
+			reloaded(next)
+		}
+		slog.Info("configuration reloaded")
+	}
+}
+
+// reload loads the configuration again and applies it to the scheduler, reusing the servers and sinks that didn't change.
+func reload(current *Configuration, scheduler *prom2log.Scheduler) (*Configuration, error) {
+	next, err := loadConfiguration()
+	if err != nil {
+		return nil, err
+	}
+	queries := next.queries()
+	// the admin API can update the scheduler meanwhile, so its servers and sinks are read with its accessors
+	prevServers, prevSinks := scheduler.CurrentServers(), scheduler.CurrentSinks()
+
+	servers := make(map[string]*prom2log.Server, len(next.Servers))
+	for name, cfg := range next.Servers {
+		if prev, ok := current.Servers[name]; ok && reflect.DeepEqual(prev, cfg) {
+			servers[name] = prevServers[name]
+			continue
+		}
+		if servers[name], err = prom2log.NewServer(cfg); err != nil {
+			return nil, fmt.Errorf("server %s: %w", name, err)
+		}
+	}
+	// keep the servers of queries that use a URL instead of a named server
+	for _, q := range queries {
+		if _, named := servers[q.Server]; named {
+			continue
+		}
+		if _, named := current.Servers[q.Server]; named {
+			continue
+		}
+		if srv, ok := prevServers[q.Server]; ok {
+			servers[q.Server] = srv
+		}
+	}
+
+	if err := prom2log.BindVariables(queries, next.Variables, servers); err != nil {
+		return nil, err
+	}
+
+	sinks := make(map[string]prom2log.Sink, len(next.Sinks))
+	created := make(map[string]prom2log.Sink)
+	for name, cfg := range next.Sinks {
+		if prev, ok := current.Sinks[name]; ok && reflect.DeepEqual(prev, cfg) {
+			sinks[name] = prevSinks[name]
+			continue
+		}
+		sink, err := prom2log.NewSink(cfg)
+		if err != nil {
+			_ = prom2log.CloseSinks(created)
+			return nil, fmt.Errorf("sink %s: %w", name, err)
+		}
+		sinks[name] = sink
+		created[name] = sink
+	}
+
+	if err := scheduler.Update(queries, servers, sinks, next.Output); err != nil {
+		_ = prom2log.CloseSinks(created)
+		return nil, err
+	}
+	for name, sink := range prevSinks {
+		if sinks[name] != sink {
+			_ = sink.Close()
+		}
+	}
+	return next, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+// closedSink records whether it was closed.
+type closedSink struct {
+	recordSink
+	closed atomic.Bool
+}
+
+func (s *closedSink) Close() error {
+	s.closed.Store(true)
+	return nil
+}
+
+func init() {
+	prom2log.RegisterSink("test", func(prom2log.SinkConfig) (prom2log.Sink, error) {
+		return &closedSink{}, nil
+	})
+}
+
+func TestReload(t *testing.T) {
+	prom := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": []}}`)
+	}))
+	defer prom.Close()
+
+	// the configuration is loaded from the command line, without the config.yaml of the working dir
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.Chdir(wd) }()
+	path := filepath.Join(dir, "prom2log.yaml")
+	args := os.Args
+	os.Args = []string{"prom2log", "start", "-c", path}
+	defer func() { os.Args = args }()
+
+	writeConfig := func(sinkID string, queries ...string) {
+		t.Helper()
+		config := fmt.Sprintf("servers:\n  main:\n    url: %s\nsinks:\n  out:\n    type: test\n    id: %s\noutput: [out]\nqueries:\n", prom.URL, sinkID)
+		for _, q := range queries {
+			config += fmt.Sprintf("  %s:\n    server: main\n    promQL: up\n    interval: 1h\n", q)
+		}
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names := func(s *prom2log.Scheduler) string {
+		var names []string
+		for _, st := range s.Status() {
+			names = append(names, st.Name)
+		}
+		return fmt.Sprint(names)
+	}
+
+	writeConfig("a", "a")
+	current, err := loadConfiguration()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheduler, err := current.scheduler(current.queries())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = scheduler.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		_ = prom2log.CloseSinks(scheduler.CurrentSinks())
+	}()
+	for !scheduler.Running() {
+		time.Sleep(time.Millisecond)
+	}
+	server, sink := scheduler.CurrentServers()["main"], scheduler.CurrentSinks()["out"]
+
+	// the unchanged server and sink are kept
+	writeConfig("a", "a", "b")
+	if current, err = reload(current, scheduler); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(scheduler); got != "[a b]" {
+		t.Errorf("got queries %s, want [a b]", got)
+	}
+	if scheduler.CurrentServers()["main"] != server {
+		t.Error("the unchanged server was replaced")
+	}
+	if scheduler.CurrentSinks()["out"] != sink {
+		t.Error("the unchanged sink was replaced")
+	}
+
+	// the changed sink is replaced and the previous one closed
+	writeConfig("b", "b")
+	if current, err = reload(current, scheduler); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(scheduler); got != "[b]" {
+		t.Errorf("got queries %s, want [b]", got)
+	}
+	if scheduler.CurrentSinks()["out"] == sink {
+		t.Error("the changed sink was kept")
+	}
+	if !sink.(*closedSink).closed.Load() {
+		t.Error("the previous sink wasn't closed")
+	}
+
+	// an invalid configuration keeps the current one
+	if err := os.WriteFile(path, []byte("queries:\n  c:\n    server: main\n    promQL: up\n    interval: soon\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reload(current, scheduler); err == nil {
+		t.Error("reload() of an invalid configuration succeeded")
+	}
+	if got := names(scheduler); got != "[b]" {
+		t.Errorf("got queries %s after a failed reload, want [b]", got)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/peterh/liner"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+const replHelp = `Enter a PromQL query to run it, queries with unbalanced brackets or ending with \ continue on the next line.
+Commands:
+  .server <url or name>  query another server
+  .format <format>       set the output format (json, logfmt, csv, tsv or ecs)
+  .flatten               toggle emitting one record per sample
+  .help                  show this help
+  .quit                  exit, like Ctrl-D
+`
+
+type ReplCMD struct {
+	formatOps
+	baseCMD
+	History string `default:"~/.prom2log_history" type:"path" help:"File the history is saved to, empty to not save it"`
+	Server  string `arg:"" help:"URL or name of the Prometheus server"`
+}
+
+// repl is the state of an interactive session.
+type repl struct {
+	config    *Configuration
+	formatter prom2log.Formatter
+	server    string
+	format    string
+	flatten   bool
+}
+
+func (r *ReplCMD) Run(c *Configuration) error {
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetMultiLineMode(true)
+
+	if r.History != "" {
+		if f, err := os.Open(r.History); err == nil {
+			_, _ = line.ReadHistory(f)
+			f.Close()
+		}
+		defer func() {
+			if err := os.MkdirAll(filepath.Dir(r.History), 0o755); err != nil {
+				return
+			}
+			if f, err := os.Create(r.History); err == nil {
+				_, _ = line.WriteHistory(f)
+				f.Close()
+			}
+		}()
+	}
+
+	s := &repl{
+		config:    c,
+		formatter: r.formatter(),
+		server:    r.Server,
+		format:    c.Format,
+	}
+	fmt.Println(`Type .help for help.`)
+	var input []string
+	for {
+		prompt := s.server + "> "
+		if len(input) > 0 {
+			prompt = strings.Repeat(" ", len(s.server)-1) + "... "
+		}
+		l, err := line.Prompt(prompt)
+		if errors.Is(err, liner.ErrPromptAborted) {
+			input = nil
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			fmt.Println()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(input) == 0 && strings.HasPrefix(strings.TrimSpace(l), ".") {
+			line.AppendHistory(l)
+			if quit := s.command(strings.Fields(l)); quit {
+				return nil
+			}
+			continue
+		}
+		input = append(input, strings.TrimSuffix(l, `\`))
+		text := strings.Join(input, "\n")
+		if strings.HasSuffix(l, `\`) || !balanced(text) {
+			continue
+		}
+		input = nil
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		// the history file has one entry per line
+		line.AppendHistory(strings.Join(strings.Fields(text), " "))
+		if err := s.run(text); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+	}
+}
+
+// command runs a repl command, reporting whether the session should end.
+func (s *repl) command(args []string) bool {
+	switch args[0] {
+	case ".quit", ".exit":
+		return true
+	case ".help":
+		fmt.Print(replHelp)
+	case ".server":
+		if len(args) != 2 {
+			fmt.Println("usage: .server <url or name>")
+			break
+		}
+		s.server = args[1]
+	case ".format":
+		if len(args) != 2 || !contains(prom2log.Formats, args[1]) {
+			fmt.Printf("usage: .format <%s>\n", strings.Join(prom2log.Formats, "|"))
+			break
+		}
+		s.format = args[1]
+	case ".flatten":
+		s.flatten = !s.flatten
+		fmt.Printf("flatten: %t\n", s.flatten)
+	default:
+		fmt.Printf("unknown command %s, type .help for help\n", args[0])
+	}
+	return false
+}
+
+// run runs a query, it can be interrupted with Ctrl-C.
+func (s *repl) run(promQL string) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT)
+	defer cancel()
+	queries := map[string]prom2log.Query{"repl": {
+		Server:  s.server,
+		PromQL:  promQL,
+		Format:  s.format,
+		Flatten: s.flatten,
+		Timeout: metav1.Duration{Duration: s.config.Timeout},
+	}}
+	if err := s.config.bind(queries); err != nil {
+		return err
+	}
+	// the csv header is repeated for every query
+	f := s.formatter
+	return prettyQuery(ctx, os.Stdout, "repl", queries["repl"], &f)
+}
+
+// balanced reports whether all the brackets and quotes in the query are closed.
+func balanced(q string) bool {
+	depth := 0
+	var quote rune
+	escaped := false
+	for _, c := range q {
+		switch {
+		case escaped:
+			escaped = false
+		case quote != 0:
+			if c == '\\' {
+				escaped = true
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '(' || c == '[' || c == '{':
+			depth++
+		case c == ')' || c == ']' || c == '}':
+			depth--
+		}
+	}
+	return depth <= 0 && quote == 0
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
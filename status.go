@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+type StatusCMD struct {
+	baseCMD
+	Address string `help:"URL of a running prom2log started with --listen to get the status of the queries from, e.g. http://localhost:8080"`
+	JSON    bool   `help:"Output JSON"`
+}
+
+func (s *StatusCMD) Run(c *Configuration) error {
+	var status []prom2log.QueryStatus
+	if s.Address != "" {
+		var err error
+		if status, err = fetchStatus(s.Address); err != nil {
+			return err
+		}
+	} else {
+		queries := c.queries()
+		for _, name := range sortedKeys(queries) {
+			q := queries[name]
+			status = append(status, prom2log.QueryStatus{
+				Name:     name,
+				Server:   q.Server,
+				Schedule: q.DescribeSchedule(),
+			})
+		}
+	}
+
+	if s.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSERVER\tSCHEDULE\tLAST RUN\tDURATION\tSAMPLES\tERROR")
+	for _, st := range status {
+		lastRun, duration, samples := "-", "-", "-"
+		if !st.LastRun.IsZero() {
+			lastRun = st.LastRun.Format(time.RFC3339)
+			duration = time.Duration(st.Duration * float64(time.Second)).Round(time.Millisecond).String()
+			samples = strconv.Itoa(st.Samples)
+		}
+		schedule := st.Schedule
+		if st.Paused {
+			schedule += " (paused)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", st.Name, st.Server, schedule, lastRun, duration, samples, st.Error)
+	}
+	return w.Flush()
+}
+
+// fetchStatus gets the status of the queries from a running instance.
+func fetchStatus(addr string) ([]prom2log.QueryStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(addr, "/")+"/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	var status []prom2log.QueryStatus
+	return status, json.NewDecoder(resp.Body).Decode(&status)
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/prometheus/prometheus/promql/parser"
+	"gopkg.in/yaml.v3"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+type ValidateCMD struct {
+	baseCMD
+	Probe bool `help:"Check that the servers can be queried"`
+}
+
+func (v *ValidateCMD) Run(c *Configuration, k *kong.Context) error {
+	var problems []string
+	reported := make(map[string]bool)
+	report := func(format string, args ...interface{}) {
+		p := fmt.Sprintf(format, args...)
+		if !reported[p] {
+			reported[p] = true
+			problems = append(problems, p)
+		}
+	}
+
+	// the configuration was already parsed leniently, check the files for unknown fields
+	path := string(v.Config)
+	if path == "" {
+		if _, err := os.Stat(defaultConfig); err == nil {
+			path = defaultConfig
+		}
+	}
+	if path != "" {
+		allowed := make(map[string]bool)
+		for _, f := range k.Model.Flags {
+			allowed[f.Name] = true
+		}
+		for _, err := range strictCheck(path, allowed) {
+			report("%s: %v", path, err)
+		}
+	}
+	if c.ConfigDir != "" {
+		files, err := configDirFiles(c.ConfigDir)
+		if err != nil {
+			report("%v", err)
+		}
+		for _, f := range files {
+			for _, err := range strictCheck(f, map[string]bool{"queries": true, "servers": true, "sinks": true}) {
+				report("%s: %v", f, err)
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(c.Servers) {
+		if _, err := prom2log.NewServer(c.Servers[name]); err != nil {
+			report("server %s: %v", name, err)
+		}
+	}
+	sinkTypes := make(map[string]bool)
+	for _, t := range prom2log.SinkTypes() {
+		sinkTypes[t] = true
+	}
+	for _, name := range sortedKeys(c.Sinks) {
+		if t := c.Sinks[name].Type; !sinkTypes[t] {
+			report("sink %s: unknown type %q", name, t)
+		}
+	}
+	checkSinks := func(prefix string, names []string) {
+		for _, n := range names {
+			if _, ok := c.Sinks[n]; !ok {
+				report("%sunknown sink %q", prefix, n)
+			}
+		}
+	}
+	checkSinks("output: ", c.Output)
+
+	for _, name := range sortedKeys(c.Variables) {
+		v := c.Variables[name]
+		if err := v.Validate(); err != nil {
+			report("variable %s: %v", name, err)
+		} else if v.Query != "" {
+			if _, err := parser.ParseExpr(v.Query); err != nil {
+				report("variable %s: invalid PromQL: %v", name, err)
+			}
+		}
+	}
+
+	queries := c.queries()
+	servers := make(map[string]bool)
+	for _, name := range sortedKeys(queries) {
+		q := queries[name]
+		if err := q.Validate(); err != nil {
+			report("query %s: %v", name, err)
+		}
+		if err := q.ValidateSchedule(); err != nil {
+			report("query %s: %v", name, err)
+		}
+		// check the PromQL with a placeholder for the variables, it's a valid value in most places
+		expr, err := q.ExpandPromQL(func(v string) (string, error) {
+			if _, ok := c.Variables[v]; !ok {
+				return "", fmt.Errorf("unknown variable %q", v)
+			}
+			return "0", nil
+		})
+		switch {
+		case q.PromQL == "" && !q.IsPromQL():
+		case q.PromQL == "":
+			report("query %s: promQL is required", name)
+		case err != nil:
+			report("query %s: invalid PromQL: %v", name, err)
+		default:
+			if _, err := parser.ParseExpr(expr); err != nil {
+				report("query %s: invalid PromQL: %v", name, err)
+			}
+		}
+		names := q.Servers
+		if len(names) == 0 {
+			names = []string{q.Server}
+		}
+		for _, server := range names {
+			if _, named := c.Servers[server]; !named {
+				if u, err := url.Parse(server); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					report("query %s: server %q is neither a URL nor a configured server", name, server)
+					continue
+				}
+			}
+			servers[server] = true
+		}
+		checkSinks("query "+name+": ", q.Sinks)
+	}
+
+	if v.Probe && len(problems) == 0 {
+		for _, name := range sortedKeys(servers) {
+			cfg, ok := c.Servers[name]
+			if !ok {
+				cfg = prom2log.ServerConfig{URL: name}
+			}
+			s, err := prom2log.NewServer(cfg)
+			if err == nil {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				err = s.Probe(ctx)
+				cancel()
+			}
+			if err != nil {
+				report("server %s: %v", name, err)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		return fmt.Errorf("found %d problems in the configuration", len(problems))
+	}
+	fmt.Println("the configuration is valid")
+	return nil
+}
+
+// strictCheck decodes a config file reporting unknown keys.
+func strictCheck(path string, allowed map[string]bool) []error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return []error{err}
+	}
+	config := map[string]interface{}{}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return []error{err}
+	}
+	expanded, err := expand(config)
+	if err != nil {
+		return []error{err}
+	}
+	config = expanded.(map[string]interface{})
+
+	var errs []error
+	for _, key := range sortedKeys(config) {
+		if !allowed[key] {
+			errs = append(errs, fmt.Errorf("unknown key %q", key))
+		}
+	}
+	if v, ok := config["defaults"]; ok {
+		if err := strictDecode(v, &prom2log.Query{}); err != nil {
+			errs = append(errs, fmt.Errorf("defaults: %w", err))
+		}
+	}
+	queries, _ := config["queries"].(map[string]interface{})
+	for _, name := range sortedKeys(queries) {
+		if err := strictDecode(queries[name], &prom2log.Query{}); err != nil {
+			errs = append(errs, fmt.Errorf("query %s: %w", name, err))
+		}
+	}
+	servers, _ := config["servers"].(map[string]interface{})
+	for _, name := range sortedKeys(servers) {
+		if err := strictDecode(servers[name], &prom2log.ServerConfig{}); err != nil {
+			errs = append(errs, fmt.Errorf("server %s: %w", name, err))
+		}
+	}
+	return errs
+}
+
+// strictDecode decodes v into out rejecting unknown fields.
+func strictDecode(v, out interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(out)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
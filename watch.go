@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/luisdavim/prom2log/pkg/prom2log"
+)
+
+// clearScreen moves the cursor to the top left corner of the terminal and clears it.
+const clearScreen = "\033[H\033[2J"
+
+// watchQuery runs the query every interval until interrupted, like watch(1), redrawing the result in place on terminals.
+func watchQuery(interval time.Duration, name string, query prom2log.Query, formatter *prom2log.Formatter) error {
+	if interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	terminal := isTerminal(os.Stdout)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		// render the whole result before writing it, so the screen isn't blank while the query runs
+		var buf bytes.Buffer
+		if terminal {
+			buf.WriteString(clearScreen)
+		}
+		fmt.Fprintf(&buf, "Every %s: %s    %s\n\n", interval, query.PromQL, time.Now().Format(time.RFC1123))
+		// the csv header is repeated on every run
+		f := *formatter
+		if err := prettyQuery(ctx, &buf, name, query, &f); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			fmt.Fprintf(&buf, "error: %v\n", err)
+		}
+		if _, err := os.Stdout.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}